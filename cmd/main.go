@@ -2,28 +2,57 @@ package main
 
 import (
 	"context"
+	"flag"
+	"fmt"
 	"os"
-
-	"github.com/sourcegraph/jsonrpc2"
+	"strconv"
 
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
-
-	"github.com/miselin/c64lsp/pkg/lsp"
 )
 
 func main() {
+	// the flags editors pass when launching a server are accepted too
+	flag.Bool("stdio", false, "talk to the client over stdin and stdout (the default)")
+	listen := flag.String("listen", "", "listen for clients at `address`: host:port for TCP or unix:path for a Unix socket")
+	connect := flag.String("connect", "", "connect to a client listening at `address`: host:port for TCP, unix:path for a Unix socket or pipe:name for a named pipe")
+	socket := flag.Int("socket", 0, "connect to a client listening on a TCP `port` on localhost")
+	pipe := flag.String("pipe", "", "connect to a client listening on a named pipe or Unix socket with this `name`")
+	flag.Int("clientProcessId", 0, "process ID of the client (ignored)")
+	flag.Parse()
+
 	zerolog.TimeFieldFormat = zerolog.TimeFormatUnix
 
 	log.Logger = log.With().Caller().Logger().Output(zerolog.ConsoleWriter{Out: os.Stderr})
 
 	ctx := log.Logger.WithContext(context.Background())
 
-	<-jsonrpc2.NewConn(
-		ctx,
-		jsonrpc2.NewBufferedStream(stdrwc{}, jsonrpc2.VSCodeObjectCodec{}),
-		lsp.NewHandler(),
-	).DisconnectNotify()
+	switch {
+	case *socket != 0:
+		*connect = "127.0.0.1:" + strconv.Itoa(*socket)
+	case *pipe != "":
+		*connect = "pipe:" + *pipe
+	}
+	if *listen != "" && *connect != "" {
+		fmt.Fprintln(os.Stderr, "can't both listen for clients and connect to one")
+		os.Exit(2)
+	}
+
+	switch {
+	case *listen != "":
+		// runs until killed
+		if err := serveListener(ctx, *listen); err != nil {
+			log.Fatal().Err(err).Msgf("listening at %s", *listen)
+		}
+	case *connect != "":
+		conn, err := dial(*connect)
+		if err != nil {
+			log.Fatal().Err(err).Msgf("connecting to %s", *connect)
+		}
+		os.Exit(serve(ctx, conn))
+	default:
+		os.Exit(serve(ctx, stdrwc{}))
+	}
 }
 
 type stdrwc struct{}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"net"
+	"os"
+	"runtime"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+	"github.com/sourcegraph/jsonrpc2"
+
+	"github.com/miselin/c64lsp/pkg/lsp"
+)
+
+// serve runs a server for one client until the connection closes, and
+// returns the code to exit with.
+func serve(ctx context.Context, rwc io.ReadWriteCloser) int {
+	handler := lsp.NewHandler()
+	<-jsonrpc2.NewConn(
+		ctx,
+		jsonrpc2.NewBufferedStream(rwc, jsonrpc2.VSCodeObjectCodec{}),
+		handler,
+	).DisconnectNotify()
+
+	return handler.ExitCode()
+}
+
+// serveListener accepts clients at an address, each with a server of its own,
+// until accepting fails or ctx is done.
+func serveListener(ctx context.Context, address string) error {
+	network, addr := splitAddress(address)
+	if network == "pipe" {
+		return errors.New("can only connect to a named pipe, not listen on one")
+	}
+	if network == "unix" {
+		removeStaleSocket(addr)
+	}
+
+	l, err := net.Listen(network, addr)
+	if err != nil {
+		return err
+	}
+	defer l.Close()
+	stop := context.AfterFunc(ctx, func() { l.Close() })
+	defer stop()
+
+	log.Info().Msgf("listening at %s", l.Addr())
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+
+		go func() {
+			logger := log.With().Str("client", conn.RemoteAddr().String()).Logger()
+			logger.Info().Msg("client connected")
+			code := serve(logger.WithContext(ctx), conn)
+			logger.Info().Msgf("client disconnected, exit code %d", code)
+		}()
+	}
+}
+
+// dial connects to a client listening at an address.
+func dial(address string) (io.ReadWriteCloser, error) {
+	network, addr := splitAddress(address)
+	if network != "pipe" {
+		return net.Dial(network, addr)
+	}
+
+	// Windows named pipes are opened like files, and elsewhere the same
+	// thing is a Unix socket
+	if runtime.GOOS == "windows" {
+		return os.OpenFile(addr, os.O_RDWR, 0)
+	}
+	return net.Dial("unix", addr)
+}
+
+// splitAddress splits an address from the command line into its network and
+// the address on that network: unix:path is a Unix socket, pipe:name a named
+// pipe, and anything else a TCP host:port.
+func splitAddress(address string) (network, addr string) {
+	if path, ok := strings.CutPrefix(address, "unix:"); ok {
+		return "unix", path
+	}
+	if name, ok := strings.CutPrefix(address, "pipe:"); ok {
+		return "pipe", name
+	}
+	return "tcp", address
+}
+
+// removeStaleSocket removes a Unix socket left behind by a server that was
+// killed, so that it can be listened on again. Anything else at the path is
+// left alone, and listening fails.
+func removeStaleSocket(path string) {
+	info, err := os.Lstat(path)
+	if err != nil || info.Mode().Type() != fs.ModeSocket {
+		return
+	}
+	// a live server still accepts connections
+	if conn, err := net.Dial("unix", path); err == nil {
+		conn.Close()
+		return
+	}
+	os.Remove(path)
+}
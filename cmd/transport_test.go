@@ -0,0 +1,181 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/sourcegraph/jsonrpc2"
+)
+
+// session runs a client over a connection through initialize, shutdown and
+// exit.
+func session(t *testing.T, rwc io.ReadWriteCloser) {
+	t.Helper()
+
+	ctx := context.Background()
+	conn := jsonrpc2.NewConn(ctx, jsonrpc2.NewBufferedStream(rwc, jsonrpc2.VSCodeObjectCodec{}), jsonrpc2.HandlerWithError(
+		func(ctx context.Context, conn *jsonrpc2.Conn, req *jsonrpc2.Request) (any, error) {
+			return nil, nil
+		}))
+	defer conn.Close()
+
+	var result struct {
+		Capabilities struct {
+			HoverProvider bool `json:"hoverProvider"`
+		} `json:"capabilities"`
+	}
+	if err := conn.Call(ctx, "initialize", map[string]any{"rootUri": "file://" + filepath.ToSlash(t.TempDir())}, &result); err != nil {
+		t.Fatalf("initialize: %v", err)
+	}
+	if !result.Capabilities.HoverProvider {
+		t.Errorf("initialize = %+v, want the server's capabilities", result)
+	}
+	if err := conn.Call(ctx, "shutdown", nil, nil); err != nil {
+		t.Fatalf("shutdown: %v", err)
+	}
+	if err := conn.Notify(ctx, "exit", nil); err != nil {
+		t.Fatalf("exit: %v", err)
+	}
+
+	select {
+	case <-conn.DisconnectNotify():
+	case <-time.After(5 * time.Second):
+		t.Fatal("server didn't close the connection on exit")
+	}
+}
+
+func TestSplitAddress(t *testing.T) {
+	tests := []struct {
+		address, network, addr string
+	}{
+		{"localhost:2087", "tcp", "localhost:2087"},
+		{":2087", "tcp", ":2087"},
+		{"unix:/tmp/c64lsp.sock", "unix", "/tmp/c64lsp.sock"},
+		{`pipe:\\.\pipe\c64lsp`, "pipe", `\\.\pipe\c64lsp`},
+	}
+
+	for _, tt := range tests {
+		if network, addr := splitAddress(tt.address); network != tt.network || addr != tt.addr {
+			t.Errorf("splitAddress(%q) = %q, %q, want %q, %q", tt.address, network, addr, tt.network, tt.addr)
+		}
+	}
+}
+
+// TestDial checks that the server can connect to a client listening on each
+// kind of address.
+func TestDial(t *testing.T) {
+	tests := []struct {
+		name    string
+		network string
+		// the address to listen on, and the one to connect to
+		listen  func(t *testing.T) string
+		address func(l net.Listener) string
+	}{
+		{"tcp", "tcp",
+			func(t *testing.T) string { return "127.0.0.1:0" },
+			func(l net.Listener) string { return l.Addr().String() }},
+		{"unix", "unix",
+			func(t *testing.T) string { return filepath.Join(t.TempDir(), "c64lsp.sock") },
+			func(l net.Listener) string { return "unix:" + l.Addr().String() }},
+		{"pipe", "unix",
+			func(t *testing.T) string { return filepath.Join(t.TempDir(), "c64lsp.sock") },
+			func(l net.Listener) string { return "pipe:" + l.Addr().String() }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.name == "pipe" && runtime.GOOS == "windows" {
+				t.Skip("named pipes need a Windows pipe server")
+			}
+
+			l, err := net.Listen(tt.network, tt.listen(t))
+			if err != nil {
+				t.Skipf("can't listen: %v", err)
+			}
+			defer l.Close()
+
+			rwc, err := dial(tt.address(l))
+			if err != nil {
+				t.Fatal(err)
+			}
+			code := make(chan int, 1)
+			go func() { code <- serve(context.Background(), rwc) }()
+
+			client, err := l.Accept()
+			if err != nil {
+				t.Fatal(err)
+			}
+			session(t, client)
+			if c := <-code; c != 0 {
+				t.Errorf("exit code = %d, want 0", c)
+			}
+		})
+	}
+}
+
+// TestServeListener checks that each client of a listening server gets a
+// server of its own, and that a Unix socket left behind is listened on again.
+func TestServeListener(t *testing.T) {
+	tests := []struct {
+		name    string
+		network string
+		address func(t *testing.T) string
+	}{
+		{"tcp", "tcp", func(t *testing.T) string {
+			// a port that was free a moment ago
+			l, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Skipf("can't listen: %v", err)
+			}
+			defer l.Close()
+			return l.Addr().String()
+		}},
+		{"stale unix socket", "unix", func(t *testing.T) string {
+			path := filepath.Join(t.TempDir(), "c64lsp.sock")
+			l, err := net.ListenUnix("unix", &net.UnixAddr{Name: path, Net: "unix"})
+			if err != nil {
+				t.Skipf("can't listen: %v", err)
+			}
+			// as if the server was killed
+			l.SetUnlinkOnClose(false)
+			l.Close()
+			return "unix:" + path
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			address := tt.address(t)
+			ctx, cancel := context.WithCancel(context.Background())
+			done := make(chan error, 1)
+			go func() { done <- serveListener(ctx, address) }()
+
+			for i := 0; i < 2; i++ {
+				var conn net.Conn
+				_, addr := splitAddress(address)
+				for start := time.Now(); ; time.Sleep(10 * time.Millisecond) {
+					var err error
+					if conn, err = net.Dial(tt.network, addr); err == nil {
+						break
+					}
+					if time.Since(start) > 5*time.Second {
+						t.Fatalf("connecting to %s: %v", address, err)
+					}
+				}
+				session(t, conn)
+			}
+
+			cancel()
+			select {
+			case <-done:
+			case <-time.After(5 * time.Second):
+				t.Fatal("server still listening once cancelled")
+			}
+		})
+	}
+}
@@ -22,8 +22,13 @@ export function activate(context: ExtensionContext) {
 
   const clientOptions: LanguageClientOptions = {
     documentSelector: [{ scheme: "file", pattern: "**/*.bas" }],
+    initializationOptions: workspace.getConfiguration("c64lsp"),
     synchronize: {
-      fileEvents: workspace.createFileSystemWatcher("**/*.bas"),
+      configurationSection: "c64lsp",
+      fileEvents: [
+        workspace.createFileSystemWatcher("**/*.{bas,prg}"),
+        workspace.createFileSystemWatcher("**/.c64lsp.json"),
+      ],
     },
   };
 
@@ -0,0 +1,51 @@
+package analysis
+
+import (
+	"github.com/miselin/c64lsp/pkg/grammar"
+)
+
+// FunctionCall is a call to a built-in function such as CHR$ or PEEK.
+type FunctionCall struct {
+	Command  *Command
+	Function *grammar.StatementToken
+	// Parenthesised argument list following the function.
+	Paren *grammar.StatementToken
+}
+
+// Args returns the tokens inside the parentheses.
+func (call *FunctionCall) Args() []*grammar.StatementToken {
+	return call.Paren.Value.Subexpression.Tokens
+}
+
+// CollectFunctionCalls returns every call to a built-in function in a program.
+func CollectFunctionCalls(program *grammar.Program, function string) []*FunctionCall {
+	calls := []*FunctionCall{}
+	for _, line := range program.Lines {
+		for _, cmd := range LineCommands(line) {
+			calls = append(calls, commandCalls(cmd, function)...)
+		}
+	}
+	return calls
+}
+
+func commandCalls(cmd *Command, function string) []*FunctionCall {
+	calls := tokenCalls(cmd, cmd.Args, function)
+	if cmd.Then != nil {
+		calls = append(calls, commandCalls(cmd.Then, function)...)
+	}
+	return calls
+}
+
+// tokenCalls finds calls in a run of tokens, including nested ones.
+func tokenCalls(cmd *Command, toks []*grammar.StatementToken, function string) []*FunctionCall {
+	calls := []*FunctionCall{}
+	for i, tok := range toks {
+		if tok.IsKeyword(function) && i+1 < len(toks) && toks[i+1].Value != nil && toks[i+1].Value.Subexpression != nil {
+			calls = append(calls, &FunctionCall{Command: cmd, Function: tok, Paren: toks[i+1]})
+		}
+		if tok.Value != nil && tok.Value.Subexpression != nil {
+			calls = append(calls, tokenCalls(cmd, tok.Value.Subexpression.Tokens, function)...)
+		}
+	}
+	return calls
+}
@@ -0,0 +1,507 @@
+package analysis
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/miselin/c64lsp/pkg/grammar"
+)
+
+// EdgeKind describes how control passes along an edge of a control-flow graph.
+type EdgeKind int
+
+const (
+	// FallThroughEdge continues with the next statement.
+	FallThroughEdge EdgeKind = iota
+	// TrueEdge is taken when an IF condition is true.
+	TrueEdge
+	// FalseEdge is taken when an IF condition is false, skipping the rest of the line.
+	FalseEdge
+	// GotoEdge jumps to a line with GOTO, THEN or ON ... GOTO.
+	GotoEdge
+	// GosubEdge calls a subroutine with GOSUB or ON ... GOSUB.
+	GosubEdge
+	// CallReturnEdge summarises a subroutine call, from the GOSUB to the
+	// statement its RETURN resumes at.
+	CallReturnEdge
+	// ReturnEdge returns from a subroutine to the statement after the GOSUB.
+	ReturnEdge
+	// LoopEdge goes from a NEXT back to the body of its FOR loop.
+	LoopEdge
+	// RunEdge restarts the program with RUN.
+	RunEdge
+	// EndEdge ends the program with END or STOP.
+	EndEdge
+)
+
+func (k EdgeKind) String() string {
+	switch k {
+	case TrueEdge:
+		return "true"
+	case FalseEdge:
+		return "false"
+	case GotoEdge:
+		return "GOTO"
+	case GosubEdge:
+		return "GOSUB"
+	case CallReturnEdge:
+		return "call"
+	case ReturnEdge:
+		return "RETURN"
+	case LoopEdge:
+		return "NEXT"
+	case RunEdge:
+		return "RUN"
+	case EndEdge:
+		return "END"
+	}
+	return ""
+}
+
+// Edge is a possible transfer of control between two statements.
+type Edge struct {
+	From, To *Node
+	Kind     EdgeKind
+	// Set when the target is chosen at runtime, by ON ... GOTO or ON ... GOSUB.
+	Computed bool
+}
+
+// Node is a statement in a control-flow graph. The statement run when an IF
+// condition is true has its own node. Lines with no statements, such as REM
+// lines, have a single node with the keyword "REM".
+type Node struct {
+	ID int
+	// Nil for the entry and exit nodes.
+	Command *Command
+	Out     []*Edge
+	In      []*Edge
+
+	// statement that runs after this one, if it doesn't branch
+	next *Node
+}
+
+// Line returns the BASIC line the node belongs to, or nil for the entry and
+// exit nodes.
+func (n *Node) Line() *grammar.BasicLine {
+	if n.Command == nil {
+		return nil
+	}
+	return n.Command.Line
+}
+
+// CFG is a control-flow graph of a BASIC program at statement granularity.
+type CFG struct {
+	Program *grammar.Program
+	// Every node, starting with the entry and exit nodes.
+	Nodes []*Node
+	// Entry precedes the first statement; RUN returns here.
+	Entry *Node
+	// Exit follows END, STOP and the last line.
+	Exit *Node
+	// Every line-number reference, in program order.
+	Branches []*Branch
+	// Branches to lines that don't exist.
+	Undefined []*Branch
+
+	lines map[*grammar.BasicLine][]*Node
+	// Branches by their line number token, and by the line they go to
+	branchAt   map[*grammar.StatementToken]*Branch
+	branchesTo map[int][]*Branch
+	// FOR loops not yet closed by a NEXT, innermost last
+	loops []*Node
+	// everything about the graph the analyses depend on, other than the
+	// RETURN edges, which follow from the rest
+	shape string
+}
+
+// BuildCFG constructs the control-flow graph of a program. Lines run in the
+// order they appear in the file.
+//
+// FOR and NEXT are paired statically: a NEXT closes the innermost preceding
+// FOR with the same counter, or the innermost one if it names no counter.
+// RETURN edges go back to every GOSUB whose subroutine can reach the RETURN.
+func BuildCFG(program *grammar.Program) *CFG {
+	return UpdateCFG(nil, program)
+}
+
+// UpdateCFG is BuildCFG for a new version of a program whose graph was prev.
+// Finding the RETURN edges walks the body of every subroutine, so if the
+// edit didn't change the shape of the graph, they are copied from prev
+// instead.
+func UpdateCFG(prev *CFG, program *grammar.Program) *CFG {
+	g := &CFG{
+		Program:    program,
+		lines:      map[*grammar.BasicLine][]*Node{},
+		branchAt:   map[*grammar.StatementToken]*Branch{},
+		branchesTo: map[int][]*Branch{},
+	}
+	g.Entry = g.newNode(nil)
+	g.Exit = g.newNode(nil)
+
+	// statements in execution order, excluding those after THEN
+	seq := []*Node{}
+	for _, line := range program.Lines {
+		cmds := LineCommands(line)
+		if len(cmds) == 0 {
+			cmds = []*Command{{Line: line, Keyword: "REM"}}
+		}
+		for _, cmd := range cmds {
+			n := g.newNode(cmd)
+			g.lines[line] = append(g.lines[line], n)
+			seq = append(seq, n)
+		}
+	}
+
+	for i, n := range seq {
+		n.next = g.Exit
+		if i+1 < len(seq) {
+			n.next = seq[i+1]
+		}
+	}
+	if len(seq) > 0 {
+		g.addEdge(g.Entry, seq[0], FallThroughEdge, false)
+	} else {
+		g.addEdge(g.Entry, g.Exit, FallThroughEdge, false)
+	}
+
+	for _, n := range seq {
+		g.link(n, g.nextLine(n))
+	}
+
+	g.shape = g.describe()
+	if prev != nil && prev.shape == g.shape {
+		g.copyReturns(prev)
+	} else {
+		g.linkReturns()
+	}
+
+	return g
+}
+
+// describe writes down everything about the graph that the analyses look
+// at: each node's statement, line and loop counters, and the edges leaving
+// it. Graphs with the same description have nodes with the same IDs that
+// the analyses treat the same way.
+func (g *CFG) describe() string {
+	lines := map[*grammar.BasicLine]int{}
+	for i, line := range g.Program.Lines {
+		lines[line] = i
+	}
+
+	var sb strings.Builder
+	for _, n := range g.Nodes {
+		if n.Command != nil {
+			line := n.Line()
+			fmt.Fprintf(&sb, "%d:%d %s", lines[line], line.Label, n.Command.Keyword)
+			switch n.Command.Keyword {
+			case "FOR":
+				sb.WriteString(" " + loopCounter(n))
+			case "NEXT":
+				sb.WriteString(" " + strings.Join(nextCounters(n.Command), ","))
+			}
+		}
+		if n.next != nil {
+			fmt.Fprintf(&sb, " >%d", n.next.ID)
+		}
+		for _, e := range n.Out {
+			fmt.Fprintf(&sb, " %d:%d:%t", e.To.ID, e.Kind, e.Computed)
+		}
+		sb.WriteString("\n")
+	}
+	return sb.String()
+}
+
+// copyReturns adds the RETURN edges of a graph with the same shape.
+func (g *CFG) copyReturns(prev *CFG) {
+	for _, n := range prev.Nodes {
+		for _, e := range n.Out {
+			if e.Kind == ReturnEdge {
+				g.addEdge(g.Nodes[e.From.ID], g.Nodes[e.To.ID], ReturnEdge, false)
+			}
+		}
+	}
+}
+
+func (g *CFG) newNode(cmd *Command) *Node {
+	n := &Node{ID: len(g.Nodes), Command: cmd}
+	g.Nodes = append(g.Nodes, n)
+	return n
+}
+
+func (g *CFG) addEdge(from, to *Node, kind EdgeKind, computed bool) {
+	for _, e := range from.Out {
+		if e.To == to && e.Kind == kind {
+			return
+		}
+	}
+	e := &Edge{From: from, To: to, Kind: kind, Computed: computed}
+	from.Out = append(from.Out, e)
+	to.In = append(to.In, e)
+}
+
+// nextLine returns the first statement of the line after a node's line.
+func (g *CFG) nextLine(n *Node) *Node {
+	line := n.Line()
+	for n.next != g.Exit && n.next.Line() == line {
+		n = n.next
+	}
+	return n.next
+}
+
+// lineEntry returns the node a branch to a line number arrives at.
+func (g *CFG) lineEntry(b *Branch) *Node {
+	g.Branches = append(g.Branches, b)
+	g.branchAt[b.Token] = b
+	g.branchesTo[b.Target] = append(g.branchesTo[b.Target], b)
+
+	line := g.Program.FindBasicLine(b.Target)
+	if line == nil || len(g.lines[line]) == 0 {
+		g.Undefined = append(g.Undefined, b)
+		return nil
+	}
+	return g.lines[line][0]
+}
+
+// link adds the edges leaving a statement. nextLine is where a false IF
+// condition continues.
+func (g *CFG) link(n *Node, nextLine *Node) {
+	cmd := n.Command
+
+	switch cmd.Keyword {
+	case "IF":
+		g.addEdge(n, nextLine, FalseEdge, false)
+		if cmd.Then == nil {
+			g.addEdge(n, n.next, TrueEdge, false)
+			return
+		}
+		then := g.newNode(cmd.Then)
+		then.next = n.next
+		g.addEdge(n, then, TrueEdge, false)
+		g.link(then, nextLine)
+	case "GOTO", "GOSUB", "ON":
+		for _, b := range cmd.Branches() {
+			target := g.lineEntry(b)
+			if target == nil {
+				continue
+			}
+			if b.IsCall() {
+				g.addEdge(n, target, GosubEdge, cmd.Keyword == "ON")
+				g.addEdge(n, n.next, CallReturnEdge, false)
+			} else {
+				g.addEdge(n, target, GotoEdge, cmd.Keyword == "ON")
+			}
+		}
+		if cmd.Keyword == "ON" {
+			// an index out of range continues with the next statement
+			g.addEdge(n, n.next, FallThroughEdge, false)
+		}
+	case "RUN":
+		target := g.Entry
+		if branches := cmd.Branches(); len(branches) > 0 {
+			target = g.lineEntry(branches[0])
+		}
+		if target != nil {
+			g.addEdge(n, target, RunEdge, false)
+		}
+	case "FOR":
+		g.loops = append(g.loops, n)
+		g.addEdge(n, n.next, FallThroughEdge, false)
+	case "NEXT":
+		var closed []*Node
+		g.loops, closed = closeLoops(g.loops, cmd)
+		for _, loop := range closed {
+			g.addEdge(n, loop.next, LoopEdge, false)
+		}
+		g.addEdge(n, n.next, FallThroughEdge, false)
+	case "END", "STOP", "NEW":
+		g.addEdge(n, g.Exit, EndEdge, false)
+	case "RETURN":
+		// linked once every call is known
+	default:
+		g.addEdge(n, n.next, FallThroughEdge, false)
+	}
+}
+
+// linkReturns adds edges from each RETURN to the statements after the GOSUBs
+// whose subroutines reach it.
+func (g *CFG) linkReturns() {
+	// the RETURNs reached from each GOSUB target, shared by every call to it
+	returns := map[*Node][]*Node{}
+	for _, n := range g.Nodes {
+		for _, call := range n.Out {
+			if call.Kind != GosubEdge {
+				continue
+			}
+			found, ok := returns[call.To]
+			if !ok {
+				body := g.Reachable(call.To, func(e *Edge) bool {
+					// nested calls are followed by their summary edges
+					return e.Kind != GosubEdge && e.Kind != ReturnEdge
+				})
+				for m := range body {
+					if m.Command != nil && m.Command.Keyword == "RETURN" {
+						found = append(found, m)
+					}
+				}
+				sort.Slice(found, func(i, j int) bool { return found[i].ID < found[j].ID })
+				returns[call.To] = found
+			}
+			for _, m := range found {
+				g.addEdge(m, n.next, ReturnEdge, false)
+			}
+		}
+	}
+}
+
+// closeLoops pops the FOR loops closed by a NEXT from a stack of open loops.
+func closeLoops(open []*Node, next *Command) ([]*Node, []*Node) {
+	closed := []*Node{}
+	for _, name := range nextCounters(next) {
+		for i := len(open) - 1; i >= 0; i-- {
+			if name == "" || loopCounter(open[i]) == name {
+				closed = append(closed, open[i])
+				open = open[:i]
+				break
+			}
+		}
+	}
+	return open, closed
+}
+
+// BranchAt returns the branch whose line number is tok, or nil.
+func (g *CFG) BranchAt(tok *grammar.StatementToken) *Branch {
+	return g.branchAt[tok]
+}
+
+// BranchesTo returns the branches to a line number, in program order.
+func (g *CFG) BranchesTo(target int) []*Branch {
+	return g.branchesTo[target]
+}
+
+// LineNodes returns the nodes for the statements on a line, excluding those
+// run after THEN.
+func (g *CFG) LineNodes(line *grammar.BasicLine) []*Node {
+	return g.lines[line]
+}
+
+// Reachable returns the nodes reachable from a node, including itself, along
+// edges accepted by follow. A nil follow accepts every edge.
+func (g *CFG) Reachable(from *Node, follow func(*Edge) bool) map[*Node]bool {
+	seen := map[*Node]bool{from: true}
+	work := []*Node{from}
+	for len(work) > 0 {
+		n := work[len(work)-1]
+		work = work[:len(work)-1]
+		for _, e := range n.Out {
+			if seen[e.To] || (follow != nil && !follow(e)) {
+				continue
+			}
+			seen[e.To] = true
+			work = append(work, e.To)
+		}
+	}
+	return seen
+}
+
+// Reach describes how a statement can be reached from the start of a program.
+type Reach int
+
+const (
+	// Unreached statements can't be reached by any path.
+	Unreached Reach = iota
+	// ReachedComputed statements can only be reached through a computed jump,
+	// so depend on the value given to ON.
+	ReachedComputed
+	// Reached statements can be reached without any computed jump.
+	Reached
+)
+
+// Reach works out how each node can be reached from the entry node.
+func (g *CFG) Reach() map[*Node]Reach {
+	reach := map[*Node]Reach{}
+	for n := range g.Reachable(g.Entry, nil) {
+		reach[n] = ReachedComputed
+	}
+	direct := g.Reachable(g.Entry, func(e *Edge) bool {
+		return !e.Computed
+	})
+	for n := range direct {
+		reach[n] = Reached
+	}
+	return reach
+}
+
+// Label returns a short description of a node's statement.
+func (g *CFG) Label(n *Node) string {
+	switch {
+	case n == g.Entry:
+		return "start"
+	case n == g.Exit:
+		return "exit"
+	case n.Command.Line.Comment != nil:
+		return strings.TrimSpace(*n.Command.Line.Comment)
+	case n.Command.Statement == nil:
+		return n.Command.Keyword
+	case n.Command.Keyword == "LET" && !n.Command.Statement.Tokens[0].IsKeyword("LET"):
+		return g.Program.TokensText(n.Command.Args)
+	}
+	return strings.TrimSpace(n.Command.Keyword + " " + g.Program.TokensText(n.Command.Args))
+}
+
+// DOT renders the graph in the Graphviz DOT language, with a cluster for each
+// BASIC line.
+func (g *CFG) DOT() string {
+	var sb strings.Builder
+
+	sb.WriteString("digraph program {\n")
+	sb.WriteString("\tnode [shape=box, fontname=\"monospace\"];\n")
+	fmt.Fprintf(&sb, "\tn%d [label=\"start\", shape=oval];\n", g.Entry.ID)
+	fmt.Fprintf(&sb, "\tn%d [label=\"exit\", shape=oval];\n", g.Exit.ID)
+
+	// every statement of each line, including those after THEN
+	byLine := map[*grammar.BasicLine][]*Node{}
+	for _, n := range g.Nodes {
+		if line := n.Line(); line != nil {
+			byLine[line] = append(byLine[line], n)
+		}
+	}
+
+	for i, line := range g.Program.Lines {
+		fmt.Fprintf(&sb, "\tsubgraph cluster_%d {\n", i)
+		fmt.Fprintf(&sb, "\t\tlabel=\"%d\";\n", line.Label)
+		for _, n := range byLine[line] {
+			fmt.Fprintf(&sb, "\t\tn%d [label=\"%s\"];\n", n.ID, dotEscape(g.Label(n)))
+		}
+		sb.WriteString("\t}\n")
+	}
+
+	for _, n := range g.Nodes {
+		for _, e := range n.Out {
+			attrs := []string{}
+			if label := e.Kind.String(); label != "" {
+				attrs = append(attrs, fmt.Sprintf("label=\"%s\"", label))
+			}
+			switch {
+			case e.Computed:
+				attrs = append(attrs, "style=dashed")
+			case e.Kind == CallReturnEdge || e.Kind == ReturnEdge:
+				attrs = append(attrs, "style=dotted")
+			}
+			fmt.Fprintf(&sb, "\tn%d -> n%d", e.From.ID, e.To.ID)
+			if len(attrs) > 0 {
+				fmt.Fprintf(&sb, " [%s]", strings.Join(attrs, ", "))
+			}
+			sb.WriteString(";\n")
+		}
+	}
+
+	sb.WriteString("}\n")
+	return sb.String()
+}
+
+var dotEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`)
+
+func dotEscape(s string) string {
+	return dotEscaper.Replace(s)
+}
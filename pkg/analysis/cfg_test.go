@@ -0,0 +1,188 @@
+package analysis
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/miselin/c64lsp/pkg/grammar"
+)
+
+// edges describes the edges of a graph, other than the one into the first
+// statement, in the order of the graph.
+func edges(g *CFG) []string {
+	name := func(n *Node) string {
+		if n.Command == nil {
+			return g.Label(n)
+		}
+		return fmt.Sprintf("%d %s", n.Line().Label, g.Label(n))
+	}
+
+	found := []string{}
+	for _, n := range g.Nodes {
+		if n == g.Entry {
+			continue
+		}
+		for _, e := range n.Out {
+			kind := e.Kind.String()
+			if kind == "" {
+				kind = "-"
+			}
+			if e.Computed {
+				kind += "?"
+			}
+			found = append(found, fmt.Sprintf("%s %s %s", name(n), kind, name(e.To)))
+		}
+	}
+	return found
+}
+
+func TestBuildCFG(t *testing.T) {
+	tests := []struct {
+		name string
+		code string
+		want []string
+	}{
+		{"GOSUB and RETURN", "10 GOSUB 100\n20 END\n100 RETURN\n", []string{
+			"10 GOSUB 100 GOSUB 100 RETURN",
+			"10 GOSUB 100 call 20 END",
+			"20 END END exit",
+			"100 RETURN RETURN 20 END",
+		}},
+		{"ON GOTO", "10 ON K GOTO 30,40\n20 END\n30 PRINT\n40 END\n", []string{
+			"10 ON K GOTO 30,40 GOTO? 30 PRINT",
+			"10 ON K GOTO 30,40 GOTO? 40 END",
+			"10 ON K GOTO 30,40 - 20 END",
+			"20 END END exit",
+			"30 PRINT - 40 END",
+			"40 END END exit",
+		}},
+		{"ON GOSUB", "10 ON K GOSUB 30\n20 END\n30 RETURN\n", []string{
+			"10 ON K GOSUB 30 GOSUB? 30 RETURN",
+			"10 ON K GOSUB 30 call 20 END",
+			"10 ON K GOSUB 30 - 20 END",
+			"20 END END exit",
+			"30 RETURN RETURN 20 END",
+		}},
+		{"IF THEN line", "10 IF A THEN 30\n20 PRINT\n30 END\n", []string{
+			"10 IF A false 20 PRINT",
+			"10 IF A true 10 GOTO 30",
+			"20 PRINT - 30 END",
+			"30 END END exit",
+			"10 GOTO 30 GOTO 30 END",
+		}},
+		{"IF THEN statements", "10 IF A THEN PRINT:B=1\n20 END\n", []string{
+			"10 IF A false 20 END",
+			"10 IF A true 10 PRINT",
+			"10 B=1 - 20 END",
+			"20 END END exit",
+			"10 PRINT - 10 B=1",
+		}},
+		{"RUN", "10 PRINT\n20 RUN\n30 RUN 10\n", []string{
+			"10 PRINT - 20 RUN",
+			"20 RUN RUN start",
+			"30 RUN 10 RUN 10 PRINT",
+		}},
+		// the target isn't known, so there is nothing to link
+		{"computed GOTO", "10 GOTO X\n20 END\n", []string{
+			"20 END END exit",
+		}},
+		{"FOR and NEXT", "10 FOR I=1 TO 3:PRINT I\n20 NEXT\n", []string{
+			"10 FOR I=1 TO 3 - 10 PRINT I",
+			"10 PRINT I - 20 NEXT",
+			"20 NEXT NEXT 10 PRINT I",
+			"20 NEXT - exit",
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := edges(BuildCFG(parse(t, tt.code))); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("edges =\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(tt.want, "\n"))
+			}
+		})
+	}
+}
+
+func TestDOT(t *testing.T) {
+	g := BuildCFG(parse(t, "10 IF A THEN PRINT \"HI\"\n20 END\n"))
+	want := `digraph program {
+	node [shape=box, fontname="monospace"];
+	n0 [label="start", shape=oval];
+	n1 [label="exit", shape=oval];
+	subgraph cluster_0 {
+		label="10";
+		n2 [label="IF A"];
+		n4 [label="PRINT \"HI\""];
+	}
+	subgraph cluster_1 {
+		label="20";
+		n3 [label="END"];
+	}
+	n0 -> n2;
+	n2 -> n3 [label="false"];
+	n2 -> n4 [label="true"];
+	n3 -> n1 [label="END"];
+	n4 -> n3;
+}
+`
+	if got := g.DOT(); got != want {
+		t.Errorf("DOT =\n%s\nwant\n%s", got, want)
+	}
+}
+
+// listing returns a program of n lines with a loop, two GOSUBs to a
+// subroutine and a GOTO around it in every ten lines.
+func listing(n int) string {
+	templates := []string{
+		"rem section {n}",
+		"for i=1 to {n}:poke 1024+i,32",
+		"if a>{n} then gosub {+40}",
+		"next i",
+		"gosub {+20}",
+		"goto {+40}",
+		"x=x+1",
+		"print x",
+		"return",
+		"print chr$(147);x",
+	}
+
+	var sb strings.Builder
+	for i := 0; i < n; i++ {
+		label := (i + 1) * 10
+		r := strings.NewReplacer("{n}", strconv.Itoa(i), "{+20}", strconv.Itoa(label+20), "{+40}", strconv.Itoa(label+40))
+		fmt.Fprintf(&sb, "%d %s\n", label, r.Replace(templates[i%len(templates)]))
+	}
+	return sb.String()
+}
+
+// editedProgram parses a large program, and the same program with a line
+// inserted in the middle, which changes the shape of its graph.
+func editedProgram(b *testing.B) (*grammar.Program, *grammar.Program) {
+	g := grammar.NewGrammar()
+	src := listing(4000)
+	old, err := g.Parse("test.bas", src)
+	if err != nil {
+		b.Fatal(err)
+	}
+	mid := len(src) / 2
+	mid += strings.IndexByte(src[mid:], '\n') + 1
+	label := old.Lines[len(old.Lines)/2].Label
+	edited, err := g.Reparse(old, "test.bas", src[:mid]+fmt.Sprintf("%d print\n", label+5)+src[mid:])
+	if err != nil {
+		b.Fatal(err)
+	}
+	return old, edited
+}
+
+func BenchmarkUpdateCFG(b *testing.B) {
+	old, edited := editedProgram(b)
+	prev := BuildCFG(old)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		UpdateCFG(prev, edited)
+	}
+}
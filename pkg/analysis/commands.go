@@ -0,0 +1,209 @@
+// Package analysis performs semantic analysis over parsed BASIC programs.
+package analysis
+
+import (
+	"strings"
+
+	"github.com/miselin/c64lsp/pkg/grammar"
+)
+
+// Command is a single BASIC statement split into its leading keyword and its
+// arguments.
+type Command struct {
+	Line      *grammar.BasicLine
+	Statement *grammar.Statement
+
+	// Upper-case leading keyword. Implicit assignments use "LET", and
+	// "GO TO" is normalised to "GOTO".
+	Keyword string
+	// Token of the leading keyword, nil for implicit commands.
+	Token *grammar.StatementToken
+	// Tokens following the keyword. For IF this is the condition, and for
+	// assignments it starts with the target variable.
+	Args []*grammar.StatementToken
+	// Command executed when an IF condition is true, nil otherwise.
+	Then *Command
+	// Set when the command was implied by a bare line number after THEN.
+	Implicit bool
+}
+
+// LineCommands returns the commands on a BASIC line, in execution order.
+func LineCommands(line *grammar.BasicLine) []*Command {
+	cmds := []*Command{}
+	for _, stmt := range line.Statements {
+		if cmd := newCommand(line, stmt, stmt.Tokens); cmd != nil {
+			cmds = append(cmds, cmd)
+		}
+	}
+	return cmds
+}
+
+func newCommand(line *grammar.BasicLine, stmt *grammar.Statement, toks []*grammar.StatementToken) *Command {
+	if len(toks) == 0 {
+		return nil
+	}
+
+	cmd := &Command{Line: line, Statement: stmt}
+
+	first := toks[0]
+	switch {
+	case first.VariableName() != "":
+		cmd.Keyword = "LET"
+		cmd.Args = toks
+	case first.IsKeyword("GO") && len(toks) > 1 && toks[1].IsKeyword("TO"):
+		cmd.Keyword = "GOTO"
+		cmd.Token = first
+		cmd.Args = toks[2:]
+	case first.IsKeyword("IF"):
+		cmd.Keyword = "IF"
+		cmd.Token = first
+		cmd.Args, cmd.Then = splitIf(line, stmt, toks[1:])
+	default:
+		cmd.Keyword = first.Keyword()
+		cmd.Token = first
+		cmd.Args = toks[1:]
+	}
+
+	return cmd
+}
+
+// splitIf separates an IF condition from the command run when it is true.
+// Both "IF c THEN 100" and "IF c GOTO 100" branch directly to a line.
+func splitIf(line *grammar.BasicLine, stmt *grammar.Statement, toks []*grammar.StatementToken) ([]*grammar.StatementToken, *Command) {
+	for i, tok := range toks {
+		switch {
+		case tok.IsKeyword("THEN"):
+			rest := toks[i+1:]
+			if len(rest) > 0 && rest[0].Value != nil && rest[0].Value.Number != nil {
+				return toks[:i], &Command{Line: line, Statement: stmt, Keyword: "GOTO", Args: rest, Implicit: true}
+			}
+			return toks[:i], newCommand(line, stmt, rest)
+		case tok.IsKeyword("GOTO"), tok.IsKeyword("GO"):
+			return toks[:i], newCommand(line, stmt, toks[i:])
+		}
+	}
+
+	return toks, nil
+}
+
+// SplitArgs splits a run of tokens into comma-separated arguments.
+func SplitArgs(toks []*grammar.StatementToken) [][]*grammar.StatementToken {
+	args := [][]*grammar.StatementToken{}
+	cur := []*grammar.StatementToken{}
+	for _, tok := range toks {
+		cur = append(cur, tok)
+		if tok.Trailing != nil && *tok.Trailing == "," {
+			args = append(args, cur)
+			cur = []*grammar.StatementToken{}
+		}
+	}
+	if len(cur) > 0 {
+		args = append(args, cur)
+	}
+	return args
+}
+
+// Branch is a reference to a BASIC line number from GOTO, GOSUB, THEN, ON or RUN.
+type Branch struct {
+	Command *Command
+	Token   *grammar.StatementToken
+	// Either "GOTO", "GOSUB" or "RUN". IF ... THEN <line> is a GOTO.
+	Kind   string
+	Target int
+}
+
+// IsCall checks if the branch is a subroutine call that will RETURN.
+func (b *Branch) IsCall() bool {
+	return b.Kind == "GOSUB"
+}
+
+// Branches returns the line-number references made by the command, including
+// those after THEN.
+func (cmd *Command) Branches() []*Branch {
+	branches := []*Branch{}
+	if cmd.Then != nil {
+		branches = append(branches, cmd.Then.Branches()...)
+	}
+
+	kind := cmd.Keyword
+	toks := cmd.Args
+	switch cmd.Keyword {
+	case "GOTO", "GOSUB", "RUN":
+	case "ON":
+		toks = nil
+		for i, tok := range cmd.Args {
+			if tok.IsKeyword("GOTO") || tok.IsKeyword("GOSUB") {
+				kind = tok.Keyword()
+				toks = cmd.Args[i+1:]
+				break
+			}
+			if tok.IsKeyword("GO") && i+1 < len(cmd.Args) && cmd.Args[i+1].IsKeyword("TO") {
+				kind = "GOTO"
+				toks = cmd.Args[i+2:]
+				break
+			}
+		}
+	default:
+		return branches
+	}
+
+	for _, tok := range toks {
+		if tok.Value != nil && tok.Value.Number != nil {
+			branches = append(branches, &Branch{Command: cmd, Token: tok, Kind: kind, Target: int(*tok.Value.Number)})
+		}
+	}
+	return branches
+}
+
+// CollectBranches returns every line-number reference in a program.
+func CollectBranches(program *grammar.Program) []*Branch {
+	branches := []*Branch{}
+	for _, line := range program.Lines {
+		for _, cmd := range LineCommands(line) {
+			branches = append(branches, cmd.Branches()...)
+		}
+	}
+	return branches
+}
+
+// LeadingComments returns the block of REM lines immediately preceding a line,
+// in program order.
+func LeadingComments(program *grammar.Program, line *grammar.BasicLine) []*grammar.BasicLine {
+	idx := program.LineIndex(line)
+	start := idx
+	for start > 0 && program.Lines[start-1].Comment != nil {
+		start--
+	}
+	if idx < 0 || start == idx {
+		return nil
+	}
+	return program.Lines[start:idx]
+}
+
+// LineName describes a line by its REM, or the last REM of the block
+// immediately above it, e.g. "key control" for a subroutine introduced by
+// "300 REM KEY CONTROL". It returns "" if there is no such REM.
+func LineName(program *grammar.Program, line *grammar.BasicLine) string {
+	rem := line
+	if rem.Comment == nil {
+		comments := LeadingComments(program, line)
+		if len(comments) == 0 {
+			return ""
+		}
+		rem = comments[len(comments)-1]
+	}
+	return RemText(rem)
+}
+
+// RemText returns the text of a REM line without the REM keyword or any
+// decoration such as "REM *** SETUP ***". It returns "" for other lines.
+func RemText(line *grammar.BasicLine) string {
+	if line.Comment == nil {
+		return ""
+	}
+	text := strings.TrimSpace(*line.Comment)
+	if len(text) >= 3 && strings.EqualFold(text[:3], "rem") {
+		text = text[3:]
+	}
+	return strings.Trim(text, " \t*-=#:")
+}
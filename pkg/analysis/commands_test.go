@@ -0,0 +1,60 @@
+package analysis
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestBranches(t *testing.T) {
+	tests := []struct {
+		code string
+		want []string
+	}{
+		{"10 GOTO 100", []string{"GOTO 100"}},
+		{"10 GO TO 100", []string{"GOTO 100"}},
+		{"10 GOSUB 100", []string{"GOSUB 100"}},
+		{"10 RUN 100", []string{"RUN 100"}},
+		{"10 RUN", []string{}},
+		{"10 GOTO X", []string{}},
+		{"10 IF A THEN 100", []string{"GOTO 100"}},
+		{"10 IF A GOTO 100", []string{"GOTO 100"}},
+		{"10 IF A THEN GOSUB 100", []string{"GOSUB 100"}},
+		{"10 ON K GOTO 100,200", []string{"GOTO 100", "GOTO 200"}},
+		{"10 ON K GO TO 100,200", []string{"GOTO 100", "GOTO 200"}},
+		{"10 ON K GOSUB 100,200", []string{"GOSUB 100", "GOSUB 200"}},
+		{"10 ON K+1 GOTO 100", []string{"GOTO 100"}},
+		{"10 PRINT 100", []string{}},
+	}
+
+	for _, tt := range tests {
+		program := parse(t, tt.code+"\n")
+		got := []string{}
+		for _, b := range CollectBranches(program) {
+			got = append(got, fmt.Sprintf("%s %d", b.Kind, b.Target))
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: branches = %q, want %q", tt.code, got, tt.want)
+		}
+
+		// the graph finds the same ones
+		g := BuildCFG(program)
+		got = []string{}
+		for _, b := range g.Branches {
+			got = append(got, fmt.Sprintf("%s %d", b.Kind, b.Target))
+			if g.BranchAt(b.Token) != b {
+				t.Errorf("%s: branch at %v = %v, want %v", tt.code, b.Token, g.BranchAt(b.Token), b)
+			}
+			found := false
+			for _, other := range g.BranchesTo(b.Target) {
+				found = found || other == b
+			}
+			if !found {
+				t.Errorf("%s: branches to %d = %v, want %v among them", tt.code, b.Target, g.BranchesTo(b.Target), b)
+			}
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: graph branches = %q, want %q", tt.code, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,692 @@
+package analysis
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/miselin/c64lsp/pkg/grammar"
+)
+
+// Value is the result of evaluating a constant expression.
+type Value struct {
+	Number float64
+	// PETSCII bytes of a string, one per character, with mnemonics decoded.
+	Text     string
+	IsString bool
+}
+
+// NumberValue creates a numeric value, rounded to C64 floating point precision.
+func NumberValue(n float64) Value {
+	return Value{Number: roundMFLPT(n)}
+}
+
+// StringValue creates a string value.
+func StringValue(s string) Value {
+	return Value{Text: s, IsString: true}
+}
+
+func (v Value) String() string {
+	if v.IsString {
+		return strconv.Quote(v.Text)
+	}
+	return strings.TrimSpace(FormatNumber(v.Number))
+}
+
+// NotConstantError explains why an expression could not be folded.
+type NotConstantError struct {
+	// Token responsible, nil if the expression as a whole is at fault.
+	Token  *grammar.StatementToken
+	Reason string
+	// Error a C64 would raise evaluating the expression, e.g. "DIVISION BY ZERO".
+	// Empty if the expression is simply not known at compile time.
+	BasicError string
+}
+
+func (e *NotConstantError) Error() string {
+	if e.BasicError != "" {
+		return fmt.Sprintf("?%s ERROR: %s", e.BasicError, e.Reason)
+	}
+	return e.Reason
+}
+
+// Evaluator folds constant expressions using C64 arithmetic semantics.
+type Evaluator struct {
+	// Known values of variables, keyed by effective name. Anything else is
+	// not constant.
+	Variables map[string]Value
+}
+
+// Evaluate folds an expression with no known variables.
+func Evaluate(toks []*grammar.StatementToken) (Value, error) {
+	return (&Evaluator{}).Evaluate(toks)
+}
+
+// Evaluate folds an expression to a value, or returns a *NotConstantError.
+func (e *Evaluator) Evaluate(toks []*grammar.StatementToken) (Value, error) {
+	if len(toks) == 0 {
+		return Value{}, &NotConstantError{Reason: "empty expression", BasicError: "SYNTAX"}
+	}
+
+	p := &exprParser{eval: e, toks: toks}
+	v, err := p.parseOr()
+	if err != nil {
+		return Value{}, err
+	}
+	if p.pos < len(toks) {
+		return Value{}, &NotConstantError{Token: toks[p.pos], Reason: "unexpected token", BasicError: "SYNTAX"}
+	}
+	return v, nil
+}
+
+// EvaluateInt folds an expression and converts it to an integer in the given
+// range, as POKE, CHR$ and friends do.
+func (e *Evaluator) EvaluateInt(toks []*grammar.StatementToken, min, max int) (int, error) {
+	v, err := e.Evaluate(toks)
+	if err != nil {
+		return 0, err
+	}
+	if v.IsString {
+		return 0, &NotConstantError{Reason: "expected a number", BasicError: "TYPE MISMATCH"}
+	}
+	n := math.Trunc(v.Number)
+	if n < float64(min) || n > float64(max) {
+		return 0, &NotConstantError{Reason: fmt.Sprintf("%g is outside %d-%d", v.Number, min, max), BasicError: "ILLEGAL QUANTITY"}
+	}
+	return int(n), nil
+}
+
+type exprParser struct {
+	eval *Evaluator
+	toks []*grammar.StatementToken
+	pos  int
+}
+
+func (p *exprParser) peek() *grammar.StatementToken {
+	if p.pos >= len(p.toks) {
+		return nil
+	}
+	return p.toks[p.pos]
+}
+
+func (p *exprParser) accept(keyword string) bool {
+	if tok := p.peek(); tok != nil && tok.IsKeyword(keyword) {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+// Operator precedence, loosest first: OR, AND, NOT, relational, + -, * /,
+// unary -, ^.
+
+func (p *exprParser) parseOr() (Value, error) {
+	return p.parseLogical("OR", p.parseAnd, func(a, b int) int { return a | b })
+}
+
+func (p *exprParser) parseAnd() (Value, error) {
+	return p.parseLogical("AND", p.parseNot, func(a, b int) int { return a & b })
+}
+
+func (p *exprParser) parseLogical(op string, next func() (Value, error), fn func(a, b int) int) (Value, error) {
+	left, err := next()
+	if err != nil {
+		return Value{}, err
+	}
+	for p.accept(op) {
+		right, err := next()
+		if err != nil {
+			return Value{}, err
+		}
+		a, err := toInteger(left)
+		if err != nil {
+			return Value{}, err
+		}
+		b, err := toInteger(right)
+		if err != nil {
+			return Value{}, err
+		}
+		left = NumberValue(float64(int16(fn(a, b))))
+	}
+	return left, nil
+}
+
+func (p *exprParser) parseNot() (Value, error) {
+	if p.accept("NOT") {
+		v, err := p.parseNot()
+		if err != nil {
+			return Value{}, err
+		}
+		n, err := toInteger(v)
+		if err != nil {
+			return Value{}, err
+		}
+		return NumberValue(float64(^n)), nil
+	}
+	return p.parseRelational()
+}
+
+func (p *exprParser) parseRelational() (Value, error) {
+	left, err := p.parseAdditive()
+	if err != nil {
+		return Value{}, err
+	}
+
+	for {
+		// <, = and > combine freely: <>, <=, =>, >< ...
+		lt, eq, gt := false, false, false
+		for {
+			switch {
+			case p.accept("<"):
+				lt = true
+				continue
+			case p.accept("="):
+				eq = true
+				continue
+			case p.accept(">"):
+				gt = true
+				continue
+			}
+			break
+		}
+		if !lt && !eq && !gt {
+			return left, nil
+		}
+
+		right, err := p.parseAdditive()
+		if err != nil {
+			return Value{}, err
+		}
+		if left.IsString != right.IsString {
+			return Value{}, &NotConstantError{Reason: "comparing a string with a number", BasicError: "TYPE MISMATCH"}
+		}
+
+		var cmp int
+		if left.IsString {
+			cmp = strings.Compare(left.Text, right.Text)
+		} else if left.Number < right.Number {
+			cmp = -1
+		} else if left.Number > right.Number {
+			cmp = 1
+		}
+
+		result := (lt && cmp < 0) || (eq && cmp == 0) || (gt && cmp > 0)
+		left = boolValue(result)
+	}
+}
+
+func (p *exprParser) parseAdditive() (Value, error) {
+	left, err := p.parseMultiplicative()
+	if err != nil {
+		return Value{}, err
+	}
+
+	for {
+		switch {
+		case p.accept("+"):
+			right, err := p.parseMultiplicative()
+			if err != nil {
+				return Value{}, err
+			}
+			if left.IsString && right.IsString {
+				if len(left.Text)+len(right.Text) > 255 {
+					return Value{}, &NotConstantError{Reason: "string longer than 255 characters", BasicError: "STRING TOO LONG"}
+				}
+				left = StringValue(left.Text + right.Text)
+				continue
+			}
+			if left, err = arith(left, right, func(a, b float64) float64 { return a + b }); err != nil {
+				return Value{}, err
+			}
+		case p.accept("-"):
+			right, err := p.parseMultiplicative()
+			if err != nil {
+				return Value{}, err
+			}
+			if left, err = arith(left, right, func(a, b float64) float64 { return a - b }); err != nil {
+				return Value{}, err
+			}
+		default:
+			return left, nil
+		}
+	}
+}
+
+func (p *exprParser) parseMultiplicative() (Value, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return Value{}, err
+	}
+
+	for {
+		switch {
+		case p.accept("*"):
+			right, err := p.parseUnary()
+			if err != nil {
+				return Value{}, err
+			}
+			if left, err = arith(left, right, func(a, b float64) float64 { return a * b }); err != nil {
+				return Value{}, err
+			}
+		case p.accept("/"):
+			right, err := p.parseUnary()
+			if err != nil {
+				return Value{}, err
+			}
+			if !right.IsString && right.Number == 0 {
+				return Value{}, &NotConstantError{Reason: "division by zero", BasicError: "DIVISION BY ZERO"}
+			}
+			if left, err = arith(left, right, func(a, b float64) float64 { return a / b }); err != nil {
+				return Value{}, err
+			}
+		default:
+			return left, nil
+		}
+	}
+}
+
+func (p *exprParser) parseUnary() (Value, error) {
+	switch {
+	case p.accept("-"):
+		v, err := p.parseUnary()
+		if err != nil {
+			return Value{}, err
+		}
+		if v.IsString {
+			return Value{}, &NotConstantError{Reason: "negating a string", BasicError: "TYPE MISMATCH"}
+		}
+		return NumberValue(-v.Number), nil
+	case p.accept("+"):
+		return p.parseUnary()
+	}
+	return p.parsePower()
+}
+
+func (p *exprParser) parsePower() (Value, error) {
+	left, err := p.parsePrimary()
+	if err != nil {
+		return Value{}, err
+	}
+
+	for p.accept("^") {
+		// unary minus binds looser than ^, but is allowed in the exponent
+		right, err := p.parseUnaryPrimary()
+		if err != nil {
+			return Value{}, err
+		}
+		if !left.IsString && !right.IsString && left.Number < 0 && right.Number != math.Trunc(right.Number) {
+			return Value{}, &NotConstantError{Reason: "fractional power of a negative number", BasicError: "ILLEGAL QUANTITY"}
+		}
+		if left, err = arith(left, right, math.Pow); err != nil {
+			return Value{}, err
+		}
+	}
+	return left, nil
+}
+
+func (p *exprParser) parseUnaryPrimary() (Value, error) {
+	if p.accept("-") {
+		v, err := p.parseUnaryPrimary()
+		if err != nil {
+			return Value{}, err
+		}
+		if v.IsString {
+			return Value{}, &NotConstantError{Reason: "negating a string", BasicError: "TYPE MISMATCH"}
+		}
+		return NumberValue(-v.Number), nil
+	}
+	return p.parsePrimary()
+}
+
+func (p *exprParser) parsePrimary() (Value, error) {
+	tok := p.peek()
+	if tok == nil {
+		return Value{}, &NotConstantError{Reason: "expression ends unexpectedly", BasicError: "SYNTAX"}
+	}
+	p.pos++
+
+	if tok.Value != nil {
+		v := tok.Value
+		switch {
+		case v.Number != nil:
+			return NumberValue(*v.Number), nil
+		case v.String != nil:
+			return stringLiteral(tok, *v.String)
+		case v.Subexpression != nil:
+			return p.eval.Evaluate(v.Subexpression.Tokens)
+		case v.Variable != nil:
+			return p.variable(tok)
+		}
+	}
+
+	return p.function(tok)
+}
+
+// stringLiteral decodes the mnemonics in a string literal, so that "{clr}" is
+// the one character it prints.
+func stringLiteral(tok *grammar.StatementToken, contents string) (Value, error) {
+	text := []byte{}
+	for _, part := range ResolveString(contents) {
+		if part.Err != nil {
+			return Value{}, &NotConstantError{Token: tok, Reason: part.Err.Error()}
+		}
+		text = append(text, part.Bytes...)
+	}
+	return StringValue(string(text)), nil
+}
+
+func (p *exprParser) variable(tok *grammar.StatementToken) (Value, error) {
+	name := EffectiveName(tok.VariableName())
+	if next := p.peek(); next != nil && next.Value != nil && next.Value.Subexpression != nil {
+		return Value{}, &NotConstantError{Token: tok, Reason: fmt.Sprintf("array %s() is not constant", name)}
+	}
+	if v, ok := p.eval.Variables[name]; ok {
+		return v, nil
+	}
+	return Value{}, &NotConstantError{Token: tok, Reason: fmt.Sprintf("variable %s is not constant", name)}
+}
+
+// constantFunctions are the built-in functions whose result depends only on
+// their arguments.
+var constantFunctions = map[string]func(args []Value) (Value, error){
+	"SGN": numeric(func(n float64) (float64, error) {
+		switch {
+		case n > 0:
+			return 1, nil
+		case n < 0:
+			return -1, nil
+		}
+		return 0, nil
+	}),
+	"INT": numeric(func(n float64) (float64, error) { return math.Floor(n), nil }),
+	"ABS": numeric(func(n float64) (float64, error) { return math.Abs(n), nil }),
+	"SQR": numeric(func(n float64) (float64, error) {
+		if n < 0 {
+			return 0, illegalQuantity("square root of a negative number")
+		}
+		return math.Sqrt(n), nil
+	}),
+	"LOG": numeric(func(n float64) (float64, error) {
+		if n <= 0 {
+			return 0, illegalQuantity("logarithm of a number that is not positive")
+		}
+		return math.Log(n), nil
+	}),
+	"EXP": numeric(func(n float64) (float64, error) { return math.Exp(n), nil }),
+	"COS": numeric(func(n float64) (float64, error) { return math.Cos(n), nil }),
+	"SIN": numeric(func(n float64) (float64, error) { return math.Sin(n), nil }),
+	"TAN": numeric(func(n float64) (float64, error) { return math.Tan(n), nil }),
+	"ATN": numeric(func(n float64) (float64, error) { return math.Atan(n), nil }),
+	"LEN": func(args []Value) (Value, error) {
+		s, err := stringArg(args, 0)
+		return NumberValue(float64(len(s))), err
+	},
+	"STR$": func(args []Value) (Value, error) {
+		n, err := numberArg(args, 0)
+		return StringValue(FormatNumber(n)), err
+	},
+	"VAL": func(args []Value) (Value, error) {
+		s, err := stringArg(args, 0)
+		return NumberValue(parseVal(s)), err
+	},
+	"ASC": func(args []Value) (Value, error) {
+		s, err := stringArg(args, 0)
+		if err != nil {
+			return Value{}, err
+		}
+		if s == "" {
+			return Value{}, illegalQuantity("ASC of an empty string")
+		}
+		return NumberValue(float64(s[0])), nil
+	},
+	"CHR$": func(args []Value) (Value, error) {
+		n, err := byteArg(args, 0)
+		return StringValue(string([]byte{byte(n)})), err
+	},
+	"LEFT$": func(args []Value) (Value, error) {
+		s, err := stringArg(args, 0)
+		if err != nil {
+			return Value{}, err
+		}
+		n, err := byteArg(args, 1)
+		return StringValue(s[:min(n, len(s))]), err
+	},
+	"RIGHT$": func(args []Value) (Value, error) {
+		s, err := stringArg(args, 0)
+		if err != nil {
+			return Value{}, err
+		}
+		n, err := byteArg(args, 1)
+		return StringValue(s[len(s)-min(n, len(s)):]), err
+	},
+	"MID$": func(args []Value) (Value, error) {
+		s, err := stringArg(args, 0)
+		if err != nil {
+			return Value{}, err
+		}
+		start, err := byteArg(args, 1)
+		if err != nil {
+			return Value{}, err
+		}
+		if start == 0 {
+			return Value{}, illegalQuantity("MID$ start position 0")
+		}
+		n := 255
+		if len(args) > 2 {
+			if n, err = byteArg(args, 2); err != nil {
+				return Value{}, err
+			}
+		}
+		if start > len(s) {
+			return StringValue(""), nil
+		}
+		return StringValue(s[start-1 : min(start-1+n, len(s))]), nil
+	},
+}
+
+func (p *exprParser) function(tok *grammar.StatementToken) (Value, error) {
+	name := tok.Keyword()
+	fn, ok := constantFunctions[name]
+	if !ok {
+		reason := fmt.Sprintf("%s is not constant", name)
+		if name == "" || !IsFunction(name) {
+			return Value{}, &NotConstantError{Token: tok, Reason: "unexpected token", BasicError: "SYNTAX"}
+		}
+		return Value{}, &NotConstantError{Token: tok, Reason: reason}
+	}
+
+	paren := p.peek()
+	if paren == nil || paren.Value == nil || paren.Value.Subexpression == nil {
+		return Value{}, &NotConstantError{Token: tok, Reason: fmt.Sprintf("%s without arguments", name), BasicError: "SYNTAX"}
+	}
+	p.pos++
+
+	args := []Value{}
+	for _, arg := range SplitArgs(paren.Value.Subexpression.Tokens) {
+		v, err := p.eval.Evaluate(arg)
+		if err != nil {
+			return Value{}, err
+		}
+		args = append(args, v)
+	}
+
+	v, err := fn(args)
+	if err != nil {
+		return Value{}, err
+	}
+	if !v.IsString {
+		return checkOverflow(v.Number)
+	}
+	return v, nil
+}
+
+// IsFunction checks if a keyword is a function that can appear in an expression.
+func IsFunction(keyword string) bool {
+	switch keyword {
+	case "RND", "PEEK", "FRE", "POS", "USR", "FN", "TAB(", "SPC(":
+		return true
+	}
+	_, ok := constantFunctions[keyword]
+	return ok
+}
+
+func numeric(fn func(float64) (float64, error)) func([]Value) (Value, error) {
+	return func(args []Value) (Value, error) {
+		n, err := numberArg(args, 0)
+		if err != nil {
+			return Value{}, err
+		}
+		r, err := fn(n)
+		return NumberValue(r), err
+	}
+}
+
+func numberArg(args []Value, i int) (float64, error) {
+	if i >= len(args) {
+		return 0, &NotConstantError{Reason: "missing argument", BasicError: "SYNTAX"}
+	}
+	if args[i].IsString {
+		return 0, &NotConstantError{Reason: "expected a number", BasicError: "TYPE MISMATCH"}
+	}
+	return args[i].Number, nil
+}
+
+func stringArg(args []Value, i int) (string, error) {
+	if i >= len(args) {
+		return "", &NotConstantError{Reason: "missing argument", BasicError: "SYNTAX"}
+	}
+	if !args[i].IsString {
+		return "", &NotConstantError{Reason: "expected a string", BasicError: "TYPE MISMATCH"}
+	}
+	return args[i].Text, nil
+}
+
+func byteArg(args []Value, i int) (int, error) {
+	n, err := numberArg(args, i)
+	if err != nil {
+		return 0, err
+	}
+	n = math.Trunc(n)
+	if n < 0 || n > 255 {
+		return 0, illegalQuantity(fmt.Sprintf("%g is outside 0-255", n))
+	}
+	return int(n), nil
+}
+
+func illegalQuantity(reason string) error {
+	return &NotConstantError{Reason: reason, BasicError: "ILLEGAL QUANTITY"}
+}
+
+func arith(a, b Value, fn func(a, b float64) float64) (Value, error) {
+	if a.IsString || b.IsString {
+		return Value{}, &NotConstantError{Reason: "arithmetic on a string", BasicError: "TYPE MISMATCH"}
+	}
+	return checkOverflow(fn(a.Number, b.Number))
+}
+
+// maxMFLPT is the largest magnitude a C64 floating point number can hold.
+const maxMFLPT = 1.70141183e38
+
+func checkOverflow(n float64) (Value, error) {
+	if math.IsNaN(n) || math.IsInf(n, 0) || math.Abs(n) > maxMFLPT {
+		return Value{}, &NotConstantError{Reason: "result too large", BasicError: "OVERFLOW"}
+	}
+	return NumberValue(n), nil
+}
+
+// toInteger converts a value to a 16-bit signed integer, as AND, OR and NOT do.
+func toInteger(v Value) (int, error) {
+	if v.IsString {
+		return 0, &NotConstantError{Reason: "logical operator on a string", BasicError: "TYPE MISMATCH"}
+	}
+	n := math.Trunc(v.Number)
+	if n < -32768 || n > 32767 {
+		return 0, illegalQuantity(fmt.Sprintf("%g is outside -32768-32767", v.Number))
+	}
+	return int(n), nil
+}
+
+func boolValue(b bool) Value {
+	if b {
+		return NumberValue(-1)
+	}
+	return NumberValue(0)
+}
+
+// roundMFLPT rounds a number to the 32-bit mantissa of the C64's 5-byte
+// floating point format.
+func roundMFLPT(n float64) float64 {
+	if n == 0 || math.IsInf(n, 0) || math.IsNaN(n) {
+		return n
+	}
+	frac, exp := math.Frexp(n)
+	frac = math.Round(frac*(1<<32)) / (1 << 32)
+	r := math.Ldexp(frac, exp)
+	// anything smaller than the smallest exponent underflows to zero
+	if math.Abs(r) < 2.93873588e-39 {
+		return 0
+	}
+	return r
+}
+
+// FormatNumber formats a number as PRINT and STR$ do, with a leading space in
+// place of the sign for positive numbers.
+func FormatNumber(n float64) string {
+	sign := " "
+	if n < 0 {
+		sign = "-"
+		n = -n
+	}
+	if n == 0 {
+		return " 0"
+	}
+
+	if n >= 0.01 && n < 1e9 {
+		s := strconv.FormatFloat(n, 'g', 9, 64)
+		if strings.Contains(s, ".") {
+			s = strings.TrimRight(strings.TrimRight(s, "0"), ".")
+		}
+		return sign + strings.TrimPrefix(s, "0")
+	}
+
+	s := strconv.FormatFloat(n, 'e', 8, 64)
+	mantissa, exp, _ := strings.Cut(s, "e")
+	mantissa = strings.TrimRight(strings.TrimRight(mantissa, "0"), ".")
+	expn, _ := strconv.Atoi(exp)
+	expSign := "+"
+	if expn < 0 {
+		expSign = "-"
+		expn = -expn
+	}
+	return fmt.Sprintf("%s%sE%s%02d", sign, mantissa, expSign, expn)
+}
+
+// parseVal converts the leading numeric part of a string, as VAL does.
+func parseVal(s string) float64 {
+	s = strings.ReplaceAll(s, " ", "")
+	end := 0
+	seenDot, seenExp := false, false
+scan:
+	for end < len(s) {
+		c := s[end]
+		switch {
+		case c >= '0' && c <= '9':
+		case (c == '+' || c == '-') && (end == 0 || s[end-1] == 'E' || s[end-1] == 'e'):
+		case c == '.' && !seenDot && !seenExp:
+			seenDot = true
+		case (c == 'E' || c == 'e') && !seenExp && end > 0:
+			seenExp = true
+		default:
+			break scan
+		}
+		end++
+	}
+	for end > 0 {
+		if n, err := strconv.ParseFloat(s[:end], 64); err == nil {
+			return n
+		}
+		end--
+	}
+	return 0
+}
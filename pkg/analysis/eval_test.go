@@ -0,0 +1,197 @@
+package analysis
+
+import (
+	"errors"
+	"math"
+	"testing"
+)
+
+// evaluate folds the expression printed by a one-line program.
+func evaluate(t *testing.T, expr string) (Value, error) {
+	t.Helper()
+
+	program := parse(t, "10 PRINT "+expr+"\n")
+	cmds := LineCommands(program.Lines[0])
+	if len(cmds) != 1 {
+		t.Fatalf("%s: %d commands, want 1", expr, len(cmds))
+	}
+	return Evaluate(cmds[0].Args)
+}
+
+func TestEvaluate(t *testing.T) {
+	tests := []struct {
+		expr string
+		want Value
+	}{
+		// precedence
+		{"2+3*4", NumberValue(14)},
+		{"(2+3)*4", NumberValue(20)},
+		{"10-4-3", NumberValue(3)},
+		{"2^3^2", NumberValue(64)},
+		{"-2^2", NumberValue(-4)},
+		{"2^-1", NumberValue(0.5)},
+		{"1+2=3", NumberValue(-1)},
+		{"NOT 1=2", NumberValue(-1)},
+		{"1 OR 2 AND 3", NumberValue(3)},
+		{"NOT 0 AND 5", NumberValue(5)},
+		{"3<>3", NumberValue(0)},
+		{"2=<3", NumberValue(-1)},
+
+		// 16-bit logical operators
+		{"NOT 0", NumberValue(-1)},
+		{"NOT -1", NumberValue(0)},
+		{"-1 AND 255", NumberValue(255)},
+		{"32767 OR 1", NumberValue(32767)},
+		{"-32768 OR 0", NumberValue(-32768)},
+		{"12.9 AND 15", NumberValue(12)},
+
+		// strings
+		{`"ab"+"c"`, StringValue("ABC")},
+		{`ASC("a")`, NumberValue(65)},
+		{`ASC("A")`, NumberValue(193)},
+		{`"AB"<"B"`, NumberValue(-1)},
+		{`LEN("{clr}")`, NumberValue(1)},
+		{`ASC("{clr}")`, NumberValue(147)},
+		{`CHR$(147)="{clr}"`, NumberValue(-1)},
+		{`LEN("A{red}B")`, NumberValue(3)},
+		{`MID$("hello",2,3)`, StringValue("ELL")},
+		{`LEFT$("hello",9)`, StringValue("HELLO")},
+		{`VAL("12.5X")`, NumberValue(12.5)},
+
+		// functions
+		{"INT(-2.5)", NumberValue(-3)},
+		{"SGN(-7)", NumberValue(-1)},
+		{"ABS(-7)", NumberValue(7)},
+		{"SQR(16)", NumberValue(4)},
+	}
+
+	for _, test := range tests {
+		v, err := evaluate(t, test.expr)
+		if err != nil {
+			t.Errorf("%s: %v", test.expr, err)
+			continue
+		}
+		if v != test.want {
+			t.Errorf("%s = %v, want %v", test.expr, v, test.want)
+		}
+	}
+}
+
+func TestEvaluateErrors(t *testing.T) {
+	tests := []struct {
+		expr string
+		// C64 error, or empty if the expression just isn't constant
+		want string
+	}{
+		{"1/0", "DIVISION BY ZERO"},
+		{"1/(2-2)", "DIVISION BY ZERO"},
+		{`"A"+1`, "TYPE MISMATCH"},
+		{`1-"A"`, "TYPE MISMATCH"},
+		{`"A"<1`, "TYPE MISMATCH"},
+		{`-"A"`, "TYPE MISMATCH"},
+		{`NOT "A"`, "TYPE MISMATCH"},
+		{`LEN(1)`, "TYPE MISMATCH"},
+		{`CHR$("A")`, "TYPE MISMATCH"},
+		{"32768 AND 1", "ILLEGAL QUANTITY"},
+		{"1 OR -32769", "ILLEGAL QUANTITY"},
+		{"NOT 32768", "ILLEGAL QUANTITY"},
+		{"CHR$(256)", "ILLEGAL QUANTITY"},
+		{"SQR(-1)", "ILLEGAL QUANTITY"},
+		{"LOG(0)", "ILLEGAL QUANTITY"},
+		{`ASC("")`, "ILLEGAL QUANTITY"},
+		{`MID$("HELLO",0)`, "ILLEGAL QUANTITY"},
+		{"(-8)^.5", "ILLEGAL QUANTITY"},
+		{"10^38*10", "OVERFLOW"},
+		{"A+1", ""},
+		{"RND(1)", ""},
+		{`LEN("{nosuchkey}")`, ""},
+	}
+
+	for _, test := range tests {
+		v, err := evaluate(t, test.expr)
+		var nc *NotConstantError
+		if !errors.As(err, &nc) {
+			t.Errorf("%s = %v, %v, want a *NotConstantError", test.expr, v, err)
+			continue
+		}
+		if nc.BasicError != test.want {
+			t.Errorf("%s: error %q, want %q", test.expr, nc.BasicError, test.want)
+		}
+	}
+}
+
+func TestRoundMFLPT(t *testing.T) {
+	tests := []struct {
+		n, want float64
+	}{
+		{0, 0},
+		{1, 1},
+		{-2.5, -2.5},
+		// 32 bits of mantissa survive, 33 don't
+		{1 + math.Ldexp(1, -31), 1 + math.Ldexp(1, -31)},
+		{1 + math.Ldexp(1, -33), 1},
+		{1 + math.Ldexp(3, -33), 1 + math.Ldexp(1, -31)},
+		{0.1, math.Ldexp(math.Round(0.8*(1<<32))/(1<<32), -3)},
+		// below the smallest exponent
+		{1e-39, 0},
+		{-1e-39, 0},
+	}
+
+	for _, test := range tests {
+		if got := roundMFLPT(test.n); got != test.want {
+			t.Errorf("roundMFLPT(%g) = %g, want %g", test.n, got, test.want)
+		}
+	}
+}
+
+func TestFormatNumber(t *testing.T) {
+	tests := []struct {
+		n    float64
+		want string
+	}{
+		{0, " 0"},
+		{5, " 5"},
+		{-5, "-5"},
+		{1.5, " 1.5"},
+		{-0.5, "-.5"},
+		{0.25, " .25"},
+		{0.01, " .01"},
+		{0.001, " 1E-03"},
+		{1.0 / 3, " .333333333"},
+		{123456789, " 123456789"},
+		{999999999, " 999999999"},
+		{1e9, " 1E+09"},
+		{1234567890, " 1.23456789E+09"},
+		{-1e10, "-1E+10"},
+		{1.70141183e38, " 1.70141183E+38"},
+	}
+
+	for _, test := range tests {
+		if got := FormatNumber(test.n); got != test.want {
+			t.Errorf("FormatNumber(%g) = %q, want %q", test.n, got, test.want)
+		}
+	}
+}
+
+func TestEvaluateStr(t *testing.T) {
+	tests := []struct {
+		expr, want string
+	}{
+		{"STR$(5)", " 5"},
+		{"STR$(-1.5)", "-1.5"},
+		{"STR$(1/3)", " .333333333"},
+		{"STR$(10^10)", " 1E+10"},
+		{"STR$(2^-10)", " 9.765625E-04"},
+	}
+
+	for _, test := range tests {
+		v, err := evaluate(t, test.expr)
+		if err != nil {
+			t.Errorf("%s: %v", test.expr, err)
+			continue
+		}
+		if v != StringValue(test.want) {
+			t.Errorf("%s = %v, want %q", test.expr, v, test.want)
+		}
+	}
+}
@@ -0,0 +1,355 @@
+package analysis
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/miselin/c64lsp/pkg/grammar"
+)
+
+// LoopProblemKind classifies a problem with FOR and NEXT.
+type LoopProblemKind int
+
+const (
+	// NextWithoutFor is a NEXT that can run with no matching FOR loop open,
+	// stopping with ?NEXT WITHOUT FOR ERROR.
+	NextWithoutFor LoopProblemKind = iota
+	// NextClosesInner is a NEXT naming an outer loop's counter while inner
+	// loops are still open, which silently discards the inner loops.
+	NextClosesInner
+	// LoopLeak is a GOTO out of a FOR loop's body, which leaves the loop on
+	// the stack.
+	LoopLeak
+	// CounterReused is a FOR nested in a loop with the same counter, which
+	// ends the outer loop.
+	CounterReused
+)
+
+// LoopProblem is a problem with FOR and NEXT found by AnalyseLoops.
+type LoopProblem struct {
+	Kind LoopProblemKind
+	// Statement with the problem.
+	Node *Node
+	// The FOR loop involved, if any.
+	Loop *Node
+	// Set for NextWithoutFor when some paths do reach the NEXT with a
+	// matching loop open.
+	Maybe   bool
+	Message string
+}
+
+// Loops pairs FOR statements with the NEXT statements that close them.
+type Loops struct {
+	CFG *CFG
+	// NEXT statements that can close each FOR.
+	Nexts map[*Node][]*Node
+	// FOR statements that each NEXT can close.
+	Fors     map[*Node][]*Node
+	Problems []*LoopProblem
+}
+
+// loopState is a path through the program with the FOR loops it has open,
+// innermost last.
+type loopState struct {
+	node  *Node
+	stack []*Node
+}
+
+// maxLoopStates bounds the distinct loop stacks tracked at each statement.
+const maxLoopStates = 32
+
+// AnalyseLoops follows every path through a program, tracking the FOR loops
+// open on the BASIC stack, to pair each NEXT with the loops it can close.
+//
+// As on the C64, a FOR replaces any open loop with the same counter, NEXT
+// without a counter closes the innermost loop, and loops opened outside a
+// subroutine aren't visible inside it. Subroutines are followed from each
+// GOSUB target with no loops open.
+func AnalyseLoops(g *CFG) *Loops {
+	loops := &Loops{CFG: g, Nexts: map[*Node][]*Node{}, Fors: map[*Node][]*Node{}}
+
+	seen := map[*Node]map[string]bool{}
+	work := []loopState{{node: g.Entry}}
+	for _, n := range g.Nodes {
+		for _, e := range n.Out {
+			if e.Kind == GosubEdge {
+				work = append(work, loopState{node: e.To})
+			}
+		}
+	}
+
+	// whether each NEXT was reached with and without a matching loop
+	matched := map[*Node]bool{}
+	// the counter that found no loop, or "" for a bare NEXT
+	unmatched := map[*Node]string{}
+	inner := map[*Node]map[*Node][]*Node{}
+
+	push := func(n *Node, stack []*Node) {
+		key := stackKey(stack)
+		if seen[n] == nil {
+			seen[n] = map[string]bool{}
+		}
+		if seen[n][key] || len(seen[n]) >= maxLoopStates {
+			return
+		}
+		seen[n][key] = true
+		work = append(work, loopState{node: n, stack: stack})
+	}
+
+	for len(work) > 0 {
+		st := work[len(work)-1]
+		work = work[:len(work)-1]
+		n, stack := st.node, st.stack
+
+		if n.Command != nil {
+			switch n.Command.Keyword {
+			case "FOR":
+				counter := loopCounter(n)
+				for i, loop := range stack {
+					if loopCounter(loop) == counter {
+						stack = stack[:i]
+						break
+					}
+				}
+				stack = append(stack[:len(stack):len(stack)], n)
+			case "NEXT":
+				ok := true
+				missing := ""
+				for _, name := range nextCounters(n.Command) {
+					missing = name
+					i := len(stack) - 1
+					if name != "" {
+						for i >= 0 && loopCounter(stack[i]) != name {
+							i--
+						}
+					}
+					if i < 0 {
+						ok = false
+						break
+					}
+
+					loop := stack[i]
+					loops.pair(loop, n)
+					if i < len(stack)-1 {
+						if inner[n] == nil {
+							inner[n] = map[*Node][]*Node{}
+						}
+						inner[n][loop] = stack[i+1:]
+					}
+
+					// around the loop again, or on to the next counter
+					push(loop.next, stack[:i+1])
+					stack = stack[:i]
+				}
+				// carry on as if the error didn't stop the program, to find
+				// any further problems
+				if ok {
+					matched[n] = true
+				} else {
+					unmatched[n] = missing
+				}
+			}
+		}
+
+		for _, e := range n.Out {
+			switch e.Kind {
+			case GosubEdge, ReturnEdge, LoopEdge:
+				// calls are analysed separately, and NEXT is handled above
+			case RunEdge:
+				push(e.To, nil)
+			default:
+				push(e.To, stack)
+			}
+		}
+	}
+
+	for _, n := range g.Nodes {
+		if name, ok := unmatched[n]; ok {
+			loops.Problems = append(loops.Problems, &LoopProblem{
+				Kind:    NextWithoutFor,
+				Node:    n,
+				Maybe:   matched[n],
+				Message: strings.Join(strings.Fields("NEXT "+name+" without FOR"), " "),
+			})
+		}
+		for _, loop := range sortedNodes(inner[n]) {
+			closed := inner[n][loop]
+			names := []string{}
+			for _, l := range closed {
+				names = append(names, loopCounter(l))
+			}
+			loops.Problems = append(loops.Problems, &LoopProblem{
+				Kind:    NextClosesInner,
+				Node:    n,
+				Loop:    loop,
+				Message: fmt.Sprintf("NEXT %s also ends the open loop over %s", loopCounter(loop), strings.Join(names, ", ")),
+			})
+		}
+	}
+
+	loops.checkBodies()
+
+	return loops
+}
+
+// UpdateLoops is AnalyseLoops for a new version of a program whose loops
+// were prev. An edit that doesn't change the shape of the graph, such as one
+// to a PRINT statement, can't change the loops either, so they are moved
+// across to the new graph's nodes rather than followed again.
+func UpdateLoops(prev *Loops, g *CFG) *Loops {
+	if prev == nil || prev.CFG.shape != g.shape {
+		return AnalyseLoops(g)
+	}
+
+	// nodes of graphs with the same shape have the same IDs
+	moved := func(nodes []*Node) []*Node {
+		m := make([]*Node, len(nodes))
+		for i, n := range nodes {
+			m[i] = g.Nodes[n.ID]
+		}
+		return m
+	}
+
+	loops := &Loops{CFG: g, Nexts: map[*Node][]*Node{}, Fors: map[*Node][]*Node{}}
+	for loop, nexts := range prev.Nexts {
+		loops.Nexts[g.Nodes[loop.ID]] = moved(nexts)
+	}
+	for next, fors := range prev.Fors {
+		loops.Fors[g.Nodes[next.ID]] = moved(fors)
+	}
+	for _, problem := range prev.Problems {
+		p := *problem
+		p.Node = g.Nodes[problem.Node.ID]
+		if problem.Loop != nil {
+			p.Loop = g.Nodes[problem.Loop.ID]
+		}
+		loops.Problems = append(loops.Problems, &p)
+	}
+	return loops
+}
+
+func (loops *Loops) pair(loop, next *Node) {
+	for _, n := range loops.Nexts[loop] {
+		if n == next {
+			return
+		}
+	}
+	loops.Nexts[loop] = append(loops.Nexts[loop], next)
+	loops.Fors[next] = append(loops.Fors[next], loop)
+}
+
+// checkBodies looks for GOTOs out of loop bodies, and loops nested in a loop
+// with the same counter. A loop's body runs from its FOR to the last line
+// with a NEXT that closes it.
+func (loops *Loops) checkBodies() {
+	g := loops.CFG
+
+	lines := map[*grammar.BasicLine]int{}
+	for i, line := range g.Program.Lines {
+		lines[line] = i
+	}
+	// every statement of each line, including those after THEN
+	index := map[*Node]int{}
+	byLine := make([][]*Node, len(g.Program.Lines))
+	// the NEXTs that pair with each loop by their position, by the statement
+	// they go back to
+	closing := map[*Node][]*Node{}
+	for _, n := range g.Nodes {
+		if n.Command == nil {
+			continue
+		}
+		i := lines[n.Line()]
+		index[n] = i
+		byLine[i] = append(byLine[i], n)
+		for _, e := range n.Out {
+			if e.Kind == LoopEdge {
+				closing[e.To] = append(closing[e.To], n)
+			}
+		}
+	}
+
+	for _, loop := range g.Nodes {
+		if loop.Command == nil || loop.Command.Keyword != "FOR" {
+			continue
+		}
+		// include the NEXTs that pair with the loop by their position, so
+		// that a loop ended early still has a body
+		nexts := append(loops.Nexts[loop][:len(loops.Nexts[loop]):len(loops.Nexts[loop])], closing[loop.next]...)
+		if len(nexts) == 0 {
+			continue
+		}
+		start, end := index[loop], index[loop]
+		for _, n := range nexts {
+			end = max(end, index[n])
+		}
+
+		for _, line := range byLine[start : end+1] {
+			for _, n := range line {
+				if n.ID == loop.ID || (index[n] == start && n.ID < loop.ID) {
+					continue
+				}
+				if n.Command.Keyword == "FOR" && loopCounter(n) == loopCounter(loop) {
+					loops.Problems = append(loops.Problems, &LoopProblem{
+						Kind:    CounterReused,
+						Node:    n,
+						Loop:    loop,
+						Message: fmt.Sprintf("FOR %s is nested in a loop over %s, and ends it", loopCounter(n), loopCounter(loop)),
+					})
+				}
+				for _, e := range n.Out {
+					if e.Kind == GotoEdge && e.To.Command != nil && (index[e.To] < start || index[e.To] > end) {
+						loops.Problems = append(loops.Problems, &LoopProblem{
+							Kind:    LoopLeak,
+							Node:    n,
+							Loop:    loop,
+							Message: fmt.Sprintf("GOTO %d leaves the loop over %s without NEXT, leaving it on the stack", e.To.Line().Label, loopCounter(loop)),
+						})
+						break
+					}
+				}
+			}
+		}
+	}
+}
+
+// loopCounter returns the effective name of a FOR loop's counter.
+func loopCounter(n *Node) string {
+	if len(n.Command.Args) == 0 {
+		return ""
+	}
+	return EffectiveName(n.Command.Args[0].VariableName())
+}
+
+// nextCounters returns the effective names of the counters given to NEXT, or a
+// single empty name if there are none.
+func nextCounters(cmd *Command) []string {
+	names := []string{}
+	for _, arg := range SplitArgs(cmd.Args) {
+		if name := arg[0].VariableName(); name != "" {
+			names = append(names, EffectiveName(name))
+		}
+	}
+	if len(names) == 0 {
+		return []string{""}
+	}
+	return names
+}
+
+func stackKey(stack []*Node) string {
+	ids := make([]string, len(stack))
+	for i, n := range stack {
+		ids[i] = fmt.Sprint(n.ID)
+	}
+	return strings.Join(ids, ",")
+}
+
+// sortedNodes returns the keys of a map of nodes in the order of the graph.
+func sortedNodes[V any](m map[*Node]V) []*Node {
+	nodes := make([]*Node, 0, len(m))
+	for n := range m {
+		nodes = append(nodes, n)
+	}
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i].ID < nodes[j].ID })
+	return nodes
+}
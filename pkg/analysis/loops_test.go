@@ -0,0 +1,160 @@
+package analysis
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/miselin/c64lsp/pkg/grammar"
+)
+
+// loopProblems describes the problems found in a program's loops by the
+// line they are on and their message.
+func loopProblems(loops *Loops) []string {
+	found := []string{}
+	for _, p := range loops.Problems {
+		found = append(found, fmt.Sprintf("%d: %s", p.Node.Line().Label, p.Message))
+	}
+	return found
+}
+
+func TestUpdateLoops(t *testing.T) {
+	const code = `10 FOR I=1 TO 3
+20 PRINT "A";I
+30 IF I=2 THEN GOTO 60
+40 NEXT I
+50 NEXT
+60 END
+`
+	tests := []struct {
+		name   string
+		code   string
+		reused bool
+	}{
+		{"same program", code, true},
+		{"add a statement", `10 FOR I=1 TO 3
+20 PRINT "B";I*2:PRINT
+30 IF I=2 THEN GOTO 60
+40 NEXT I
+50 NEXT
+60 END
+`, false},
+		{"edit an expression", `10 FOR I=1 TO 3
+20 PRINT "BCD";I*2
+30 IF I=2 THEN GOTO 60
+40 NEXT I
+50 NEXT
+60 END
+`, true},
+		{"add a line", `10 FOR I=1 TO 3
+15 PRINT
+20 PRINT "A";I
+30 IF I=2 THEN GOTO 60
+40 NEXT I
+50 NEXT
+60 END
+`, false},
+		{"change a counter", `10 FOR I=1 TO 3
+20 PRINT "A";I
+30 IF I=2 THEN GOTO 60
+40 NEXT J
+50 NEXT
+60 END
+`, false},
+		{"change a target", `10 FOR I=1 TO 3
+20 PRINT "A";I
+30 IF I=2 THEN GOTO 50
+40 NEXT I
+50 NEXT
+60 END
+`, false},
+	}
+
+	g := grammar.NewGrammar()
+	old, err := g.Parse("test.bas", code)
+	if err != nil {
+		t.Fatal(err)
+	}
+	prev := AnalyseLoops(BuildCFG(old))
+	if len(prev.Problems) == 0 {
+		t.Fatal("no problems to move across")
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			program, err := g.Reparse(old, "test.bas", tt.code)
+			if err != nil {
+				t.Fatal(err)
+			}
+			cfg := UpdateCFG(prev.CFG, program)
+			if reused := cfg.shape == prev.CFG.shape; reused != tt.reused {
+				t.Errorf("reused = %v, want %v", reused, tt.reused)
+			}
+
+			loops := UpdateLoops(prev, cfg)
+			fresh := AnalyseLoops(BuildCFG(program))
+			if got, want := loopProblems(loops), loopProblems(fresh); !reflect.DeepEqual(got, want) {
+				t.Errorf("problems = %q, want %q", got, want)
+			}
+			if got, want := cfg.DOT(), fresh.CFG.DOT(); got != want {
+				t.Errorf("graph = %s, want %s", got, want)
+			}
+
+			// everything refers to the new program
+			for _, p := range loops.Problems {
+				if cfg.Nodes[p.Node.ID] != p.Node || p.Node.Line() != program.FindBasicLine(p.Node.Line().Label) {
+					t.Errorf("problem %q is on a node of the old program", p.Message)
+				}
+			}
+			for loop, nexts := range loops.Nexts {
+				for _, n := range append([]*Node{loop}, nexts...) {
+					if cfg.Nodes[n.ID] != n {
+						t.Errorf("loop at line %d pairs with a node of the old program", loop.Line().Label)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestAnalyseLoops(t *testing.T) {
+	tests := []struct {
+		name string
+		code string
+		want []string
+	}{
+		{"nested loops", "10 FOR I=1 TO 3:FOR J=1 TO 3\n20 NEXT J,I\n", []string{}},
+		{"bare NEXT", "10 FOR I=1 TO 3\n20 NEXT\n", []string{}},
+		{"NEXT without FOR", "10 NEXT I\n", []string{"10: NEXT I without FOR"}},
+		{"NEXT closes inner", "10 FOR I=1 TO 3:FOR J=1 TO 3:FOR K=1 TO 3\n20 NEXT I\n", []string{
+			"20: NEXT I also ends the open loop over J, K",
+		}},
+		{"GOTO out of the body", "10 FOR I=1 TO 3\n20 IF I=2 THEN 50\n30 GOTO 40\n40 NEXT I\n50 END\n", []string{
+			"20: GOTO 50 leaves the loop over I without NEXT, leaving it on the stack",
+		}},
+		{"counter reused", "10 FOR I=1 TO 3\n20 FOR I=1 TO 2\n30 NEXT I\n40 NEXT I\n", []string{
+			"40: NEXT I without FOR",
+			"20: FOR I is nested in a loop over I, and ends it",
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			loops := AnalyseLoops(BuildCFG(parse(t, tt.code)))
+			if got := loopProblems(loops); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("problems = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func BenchmarkUpdateLoops(b *testing.B) {
+	old, edited := editedProgram(b)
+	prev := AnalyseLoops(BuildCFG(old))
+	cfg := UpdateCFG(prev.CFG, edited)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		UpdateLoops(prev, cfg)
+	}
+}
@@ -0,0 +1,154 @@
+package analysis
+
+import (
+	"github.com/miselin/c64lsp/pkg/grammar"
+)
+
+// MemoryAccess is an address used by POKE, PEEK, WAIT or SYS.
+type MemoryAccess struct {
+	Command *Command
+	// One of "POKE", "PEEK", "WAIT" or "SYS".
+	Keyword string
+	Address []*grammar.StatementToken
+	// Value stored by POKE, empty otherwise.
+	Value []*grammar.StatementToken
+	// Parenthesised argument of PEEK, nil otherwise.
+	Paren *grammar.StatementToken
+}
+
+// CollectMemoryAccesses returns every memory access in a program.
+func CollectMemoryAccesses(program *grammar.Program) []*MemoryAccess {
+	accesses := []*MemoryAccess{}
+	for _, line := range program.Lines {
+		for _, cmd := range LineCommands(line) {
+			accesses = append(accesses, commandAccesses(cmd)...)
+		}
+	}
+	return accesses
+}
+
+func commandAccesses(cmd *Command) []*MemoryAccess {
+	accesses := []*MemoryAccess{}
+
+	args := SplitArgs(cmd.Args)
+	switch cmd.Keyword {
+	case "POKE", "WAIT":
+		if len(args) > 0 {
+			access := &MemoryAccess{Command: cmd, Keyword: cmd.Keyword, Address: args[0]}
+			if cmd.Keyword == "POKE" && len(args) > 1 {
+				access.Value = args[1]
+			}
+			accesses = append(accesses, access)
+		}
+	case "SYS":
+		if len(args) > 0 {
+			accesses = append(accesses, &MemoryAccess{Command: cmd, Keyword: cmd.Keyword, Address: args[0]})
+		}
+	}
+
+	accesses = append(accesses, peeks(cmd, cmd.Args)...)
+	if cmd.Then != nil {
+		accesses = append(accesses, commandAccesses(cmd.Then)...)
+	}
+	return accesses
+}
+
+// peeks finds every PEEK() in a run of tokens, including nested ones.
+func peeks(cmd *Command, toks []*grammar.StatementToken) []*MemoryAccess {
+	accesses := []*MemoryAccess{}
+	for _, call := range tokenCalls(cmd, toks, "PEEK") {
+		accesses = append(accesses, &MemoryAccess{Command: cmd, Keyword: "PEEK", Address: call.Args(), Paren: call.Paren})
+	}
+	return accesses
+}
+
+// Contains checks if a token is part of the address expression.
+func (a *MemoryAccess) Contains(tok *grammar.StatementToken) bool {
+	return containsToken(a.Address, tok)
+}
+
+// ValueContains checks if a token is part of the value stored by a POKE.
+func (a *MemoryAccess) ValueContains(tok *grammar.StatementToken) bool {
+	return containsToken(a.Value, tok)
+}
+
+func containsToken(toks []*grammar.StatementToken, tok *grammar.StatementToken) bool {
+	for _, t := range toks {
+		if t == tok {
+			return true
+		}
+		if t.Value != nil && t.Value.Subexpression != nil && containsToken(t.Value.Subexpression.Tokens, tok) {
+			return true
+		}
+	}
+	return false
+}
+
+// AddressBase folds the constant terms of an address expression, so that
+// "55908+i" has the base 55908. If exact is false, non-constant terms remain
+// and the actual address is offset from the base. Only variables and array
+// elements are taken as offsets: in "A*256+5" the 5 isn't a base at all.
+func AddressBase(toks []*grammar.StatementToken) (base int, exact bool, ok bool) {
+	if addr, err := (&Evaluator{}).EvaluateInt(toks, 0, 65535); err == nil {
+		return addr, true, true
+	}
+
+	total := 0.0
+	sign := 1.0
+	term := []*grammar.StatementToken{}
+	offsets := true
+
+	flush := func() {
+		if len(term) == 0 {
+			return
+		}
+		if v, err := Evaluate(term); err == nil && !v.IsString {
+			total += sign * v.Number
+			ok = true
+		} else if !isVariableTerm(term) {
+			offsets = false
+		}
+		term = term[:0]
+	}
+
+	for _, tok := range toks {
+		switch {
+		case tok.IsKeyword("+"):
+			flush()
+			sign = 1
+		case tok.IsKeyword("-"):
+			flush()
+			sign = -1
+		default:
+			term = append(term, tok)
+		}
+	}
+	flush()
+
+	if !offsets {
+		return 0, false, false
+	}
+	return int(total), false, ok
+}
+
+// isVariableTerm checks if a term is a lone variable, such as "I", or an
+// array element, such as "O(I)".
+func isVariableTerm(term []*grammar.StatementToken) bool {
+	if term[0].VariableName() == "" {
+		return false
+	}
+	switch len(term) {
+	case 1:
+		return true
+	case 2:
+		return term[1].Value != nil && term[1].Value.Subexpression != nil
+	}
+	return false
+}
+
+// ConstantByte folds an expression into a value from 0-255, as stored by POKE
+// or passed to CHR$.
+func ConstantByte(toks []*grammar.StatementToken) (int, bool) {
+	n, err := (&Evaluator{}).EvaluateInt(toks, 0, 255)
+	return n, err == nil
+}
@@ -0,0 +1,51 @@
+package analysis
+
+import (
+	"testing"
+
+	"github.com/miselin/c64lsp/pkg/grammar"
+)
+
+// parse parses a program for a test, failing it if the program doesn't parse.
+func parse(t *testing.T, code string) *grammar.Program {
+	t.Helper()
+
+	g := grammar.NewGrammar()
+	program, err := g.Parse("test.bas", code)
+	if err != nil {
+		t.Fatalf("parsing %q: %v", code, err)
+	}
+	return program
+}
+
+func TestAddressBase(t *testing.T) {
+	tests := []struct {
+		address string
+		base    int
+		exact   bool
+		ok      bool
+	}{
+		{"53280", 53280, true, true},
+		{"53248+32", 53280, true, true},
+		{"55296+I", 55296, false, true},
+		{"1024+O(I)-40", 984, false, true},
+		{"I%+1024", 1024, false, true},
+		{"A*256+5", 0, false, false},
+		{"1024+A*40", 0, false, false},
+		{"PEEK(43)+256*PEEK(44)", 0, false, false},
+		{"A+B", 0, false, false},
+	}
+
+	for _, test := range tests {
+		program := parse(t, "10 POKE "+test.address+",0\n")
+		accesses := CollectMemoryAccesses(program)
+		if len(accesses) == 0 {
+			t.Fatalf("%s: no memory access", test.address)
+		}
+
+		base, exact, ok := AddressBase(accesses[0].Address)
+		if base != test.base || exact != test.exact || ok != test.ok {
+			t.Errorf("AddressBase(%s) = %d, %v, %v, want %d, %v, %v", test.address, base, exact, ok, test.base, test.exact, test.ok)
+		}
+	}
+}
@@ -0,0 +1,303 @@
+package analysis
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/miselin/c64lsp/pkg/grammar"
+	"github.com/miselin/c64lsp/pkg/reference"
+)
+
+// StringPart is a segment of a string literal resolved to PETSCII.
+type StringPart struct {
+	grammar.StringSegment
+	// PETSCII bytes the segment produces when printed.
+	Bytes []byte
+	// Set if a mnemonic is unknown.
+	Err error
+}
+
+// StringLiteral is a string literal in the program, split into parts.
+type StringLiteral struct {
+	Command *Command
+	Token   *grammar.StatementToken
+	Parts   []*StringPart
+}
+
+// Bytes returns the PETSCII bytes of the whole literal, skipping unknown mnemonics.
+func (lit *StringLiteral) Bytes() []byte {
+	b := []byte{}
+	for _, part := range lit.Parts {
+		b = append(b, part.Bytes...)
+	}
+	return b
+}
+
+// CollectStringLiterals returns every string literal in a program.
+func CollectStringLiterals(program *grammar.Program) []*StringLiteral {
+	literals := []*StringLiteral{}
+	for _, line := range program.Lines {
+		for _, cmd := range LineCommands(line) {
+			literals = append(literals, commandStrings(program, cmd, cmd.Args)...)
+			if cmd.Then != nil {
+				literals = append(literals, commandStrings(program, cmd.Then, cmd.Then.Args)...)
+			}
+		}
+	}
+	return literals
+}
+
+func commandStrings(program *grammar.Program, cmd *Command, toks []*grammar.StatementToken) []*StringLiteral {
+	literals := []*StringLiteral{}
+	for _, tok := range toks {
+		if tok.Value == nil {
+			continue
+		}
+		if tok.Value.String != nil {
+			contents, _ := program.StringContents(tok)
+			literals = append(literals, &StringLiteral{Command: cmd, Token: tok, Parts: ResolveString(contents)})
+		}
+		if tok.Value.Subexpression != nil {
+			literals = append(literals, commandStrings(program, cmd, tok.Value.Subexpression.Tokens)...)
+		}
+	}
+	return literals
+}
+
+// ResolveString splits the contents of a string literal into parts and maps
+// each to PETSCII.
+func ResolveString(contents string) []*StringPart {
+	parts := []*StringPart{}
+	for _, seg := range grammar.StringSegments(contents) {
+		part := &StringPart{StringSegment: seg}
+		if seg.IsMnemonic {
+			code, err := reference.LookupMnemonic(seg.Mnemonic)
+			if err != nil {
+				part.Err = fmt.Errorf("%w: {%s}", err, seg.Mnemonic)
+			} else {
+				for i := 0; i < seg.Count; i++ {
+					part.Bytes = append(part.Bytes, byte(code))
+				}
+			}
+		} else {
+			for _, r := range seg.Text {
+				part.Bytes = append(part.Bytes, reference.AsciiToPetscii(r))
+			}
+		}
+		parts = append(parts, part)
+	}
+	return parts
+}
+
+// StringTerm is a constant piece of a string expression: a literal or a call
+// to CHR$ with a constant argument.
+type StringTerm struct {
+	// Set for literals.
+	Literal *StringLiteral
+	// Set for CHR$ calls.
+	Call *FunctionCall
+	// PETSCII bytes the term produces.
+	Bytes []byte
+}
+
+// Tokens returns the first and last token of the term.
+func (term *StringTerm) Tokens() (*grammar.StatementToken, *grammar.StatementToken) {
+	if term.Call != nil {
+		return term.Call.Function, term.Call.Paren
+	}
+	return term.Literal.Token, term.Literal.Token
+}
+
+// StringRun is a sequence of constant string terms joined by + or ;, such as
+// CHR$(147);CHR$(19);"TITLE". A single term is a run of one.
+type StringRun struct {
+	Command *Command
+	Terms   []*StringTerm
+	// Set if the run is directly in the arguments of the command rather than
+	// nested in parentheses.
+	TopLevel bool
+}
+
+// Bytes returns the PETSCII bytes of the whole run.
+func (run *StringRun) Bytes() []byte {
+	b := []byte{}
+	for _, term := range run.Terms {
+		b = append(b, term.Bytes...)
+	}
+	return b
+}
+
+// Tokens returns the first and last token of the run.
+func (run *StringRun) Tokens() (*grammar.StatementToken, *grammar.StatementToken) {
+	first, _ := run.Terms[0].Tokens()
+	_, last := run.Terms[len(run.Terms)-1].Tokens()
+	return first, last
+}
+
+// CollectStringRuns returns every run of constant string terms in a program.
+func CollectStringRuns(program *grammar.Program) []*StringRun {
+	runs := []*StringRun{}
+	for _, line := range program.Lines {
+		for _, cmd := range LineCommands(line) {
+			runs = append(runs, commandRuns(program, cmd, cmd.Args, true)...)
+			if cmd.Then != nil {
+				runs = append(runs, commandRuns(program, cmd.Then, cmd.Then.Args, true)...)
+			}
+		}
+	}
+	return runs
+}
+
+func commandRuns(program *grammar.Program, cmd *Command, toks []*grammar.StatementToken, topLevel bool) []*StringRun {
+	runs := []*StringRun{}
+	var run *StringRun
+	print := topLevel && strings.HasPrefix(cmd.Keyword, "PRINT")
+	// set once the previous term is followed by a separator
+	joined := false
+
+	for i := 0; i < len(toks); i++ {
+		tok := toks[i]
+
+		term, n := stringTerm(program, cmd, toks[i:])
+		if term == nil {
+			if run != nil && joined && tok.IsKeyword("+") {
+				continue
+			}
+			run = nil
+			if tok.Value != nil && tok.Value.Subexpression != nil {
+				runs = append(runs, commandRuns(program, cmd, tok.Value.Subexpression.Tokens, false)...)
+			}
+			continue
+		}
+
+		if run == nil || !joined {
+			run = &StringRun{Command: cmd, TopLevel: topLevel}
+			runs = append(runs, run)
+		}
+		run.Terms = append(run.Terms, term)
+
+		i += n - 1
+		trailing := ""
+		if toks[i].Trailing != nil {
+			trailing = *toks[i].Trailing
+		}
+		switch {
+		case trailing == ";":
+			joined = true
+		case trailing != "":
+			run = nil
+		case i+1 < len(toks) && toks[i+1].IsKeyword("+"):
+			joined = true
+		default:
+			// PRINT also concatenates terms written next to each other
+			joined = print
+		}
+	}
+
+	return runs
+}
+
+// stringTerm checks for a constant string term at the start of toks, and
+// returns it along with the number of tokens it covers.
+func stringTerm(program *grammar.Program, cmd *Command, toks []*grammar.StatementToken) (*StringTerm, int) {
+	tok := toks[0]
+	if tok.Value != nil && tok.Value.String != nil {
+		contents, _ := program.StringContents(tok)
+		lit := &StringLiteral{Command: cmd, Token: tok, Parts: ResolveString(contents)}
+		for _, part := range lit.Parts {
+			if part.Err != nil {
+				return nil, 0
+			}
+		}
+		return &StringTerm{Literal: lit, Bytes: lit.Bytes()}, 1
+	}
+
+	calls := tokenCalls(cmd, toks[:min(2, len(toks))], "CHR$")
+	if len(calls) == 0 || calls[0].Function != tok {
+		return nil, 0
+	}
+	code, ok := ConstantByte(calls[0].Args())
+	if !ok {
+		return nil, 0
+	}
+	return &StringTerm{Call: calls[0], Bytes: []byte{byte(code)}}, 2
+}
+
+// Restyle returns the contents of the literal with every known mnemonic
+// written in the given style. Plain text and unknown mnemonics are unchanged.
+func (lit *StringLiteral) Restyle(style reference.MnemonicStyle) string {
+	var sb strings.Builder
+	for _, part := range lit.Parts {
+		pc, err := reference.LookupCharacter(mnemonicCode(part))
+		if !part.IsMnemonic || err != nil {
+			sb.WriteString(part.Text)
+			continue
+		}
+		sb.WriteString("{")
+		if part.Count > 1 {
+			fmt.Fprintf(&sb, "%d ", part.Count)
+		}
+		sb.WriteString(pc.Name(style) + "}")
+	}
+	return sb.String()
+}
+
+// HasMnemonics checks if the literal contains any known mnemonics.
+func (lit *StringLiteral) HasMnemonics() bool {
+	for _, part := range lit.Parts {
+		if part.IsMnemonic && part.Err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// mnemonicCode returns the PETSCII code of a mnemonic part, or -1.
+func mnemonicCode(part *StringPart) int {
+	if !part.IsMnemonic || len(part.Bytes) == 0 {
+		return -1
+	}
+	return int(part.Bytes[0])
+}
+
+// GlyphsToMnemonics rewrites Unicode PETSCII graphics in the text of a string
+// or REM as mnemonics in the given style.
+func GlyphsToMnemonics(contents string, style reference.MnemonicStyle) string {
+	var sb strings.Builder
+	for _, seg := range grammar.StringSegments(contents) {
+		if seg.IsMnemonic {
+			sb.WriteString(seg.Text)
+			continue
+		}
+		for _, r := range seg.Text {
+			code, ok := reference.UnicodeToPetscii(r)
+			if !ok {
+				sb.WriteRune(r)
+				continue
+			}
+			// graphics keep their names even where a letter would do
+			if pc, err := reference.LookupCharacter(code); err == nil {
+				sb.WriteString("{" + pc.Name(style) + "}")
+			} else {
+				sb.WriteString(reference.EncodeString([]byte{byte(code)}, style))
+			}
+		}
+	}
+	return sb.String()
+}
+
+// MnemonicsToGlyphs rewrites mnemonics in the text of a string or REM as
+// Unicode characters from the given glyph set. Mnemonics without a glyph,
+// such as control codes, are unchanged.
+func MnemonicsToGlyphs(contents string, set reference.GlyphSet) string {
+	var sb strings.Builder
+	for _, part := range ResolveString(contents) {
+		r, ok := reference.PetsciiToUnicode(mnemonicCode(part), set)
+		if !ok {
+			sb.WriteString(part.Text)
+			continue
+		}
+		sb.WriteString(strings.Repeat(string(r), part.Count))
+	}
+	return sb.String()
+}
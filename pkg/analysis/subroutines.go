@@ -0,0 +1,268 @@
+package analysis
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/miselin/c64lsp/pkg/grammar"
+)
+
+// Subroutine is the code run by a GOSUB to a line, up to its RETURNs.
+type Subroutine struct {
+	// The line GOSUB jumps to, and its first statement.
+	Line  *grammar.BasicLine
+	Entry *Node
+	// Statements the subroutine can run, not including those of the
+	// subroutines it calls.
+	Body map[*Node]bool
+	// GOSUB edges to the subroutine.
+	Calls []*Edge
+	// GOSUB edges from the body to other subroutines.
+	Callees []*Edge
+	// RETURN statements in the body.
+	Returns []*Node
+
+	// Body in the order of the graph.
+	body []*Node
+}
+
+// SubroutineProblemKind classifies a problem with GOSUB and RETURN.
+type SubroutineProblemKind int
+
+const (
+	// FallsThrough is a statement where a subroutine runs on into other code,
+	// or off the end of the program, without a RETURN.
+	FallsThrough SubroutineProblemKind = iota
+	// NoReturn is a subroutine with no RETURN it can reach.
+	NoReturn
+	// ReturnWithoutGosub is a RETURN the main program can reach without a
+	// GOSUB, stopping with ?RETURN WITHOUT GOSUB ERROR.
+	ReturnWithoutGosub
+	// RecursiveCall is a GOSUB that can call back into a subroutine that is
+	// already running.
+	RecursiveCall
+	// GotoSubroutine is a GOTO into a subroutine from outside it, so that
+	// the subroutine is entered both with and without GOSUB.
+	GotoSubroutine
+)
+
+// SubroutineProblem is a problem with GOSUB and RETURN found by
+// AnalyseSubroutines.
+type SubroutineProblem struct {
+	Kind SubroutineProblemKind
+	// Statement with the problem.
+	Node       *Node
+	Subroutine *Subroutine
+	// Where control goes for FallsThrough.
+	Target  *Node
+	Message string
+}
+
+// Subroutines describes the subroutines of a program and how they call each
+// other.
+type Subroutines struct {
+	CFG *CFG
+	// Subroutines in the order of their lines.
+	All []*Subroutine
+	// Statements the main program can run, outside of any GOSUB.
+	Main     map[*Node]bool
+	Problems []*SubroutineProblem
+
+	byEntry map[*Node]*Subroutine
+}
+
+// AnalyseSubroutines finds every GOSUB target in a program, the statements
+// each subroutine can run, and problems with how they are called and return.
+func AnalyseSubroutines(g *CFG) *Subroutines {
+	subs := &Subroutines{CFG: g, byEntry: map[*Node]*Subroutine{}}
+
+	// calls are followed by their summary edges, so bodies stop at RETURN
+	// and leave out the subroutines they call
+	subs.Main = g.Reachable(g.Entry, func(e *Edge) bool {
+		return e.Kind != GosubEdge && e.Kind != ReturnEdge
+	})
+	follow := func(e *Edge) bool {
+		return e.Kind != GosubEdge && e.Kind != ReturnEdge && e.Kind != RunEdge
+	}
+
+	for _, n := range g.Nodes {
+		for _, e := range n.Out {
+			if e.Kind != GosubEdge {
+				continue
+			}
+			sub, ok := subs.byEntry[e.To]
+			if !ok {
+				sub = &Subroutine{Line: e.To.Line(), Entry: e.To}
+				subs.byEntry[e.To] = sub
+			}
+			sub.Calls = append(sub.Calls, e)
+		}
+	}
+
+	for _, line := range g.Program.Lines {
+		for _, n := range g.LineNodes(line) {
+			if sub, ok := subs.byEntry[n]; ok {
+				subs.All = append(subs.All, sub)
+			}
+		}
+	}
+
+	for _, sub := range subs.All {
+		sub.Body = g.Reachable(sub.Entry, follow)
+		delete(sub.Body, g.Exit)
+		sub.body = sortedNodes(sub.Body)
+		for _, n := range sub.body {
+			if n.Command == nil {
+				continue
+			}
+			if n.Command.Keyword == "RETURN" {
+				sub.Returns = append(sub.Returns, n)
+			}
+			for _, e := range n.Out {
+				if e.Kind == GosubEdge {
+					sub.Callees = append(sub.Callees, e)
+				}
+			}
+		}
+	}
+
+	subs.checkReturns()
+	subs.checkEntries()
+	subs.checkRecursion()
+
+	return subs
+}
+
+// Subroutine returns the subroutine starting at a node, or nil.
+func (subs *Subroutines) Subroutine(entry *Node) *Subroutine {
+	return subs.byEntry[entry]
+}
+
+// Containing returns the subroutines whose bodies include a node.
+func (subs *Subroutines) Containing(n *Node) []*Subroutine {
+	found := []*Subroutine{}
+	for _, sub := range subs.All {
+		if sub.Body[n] {
+			found = append(found, sub)
+		}
+	}
+	return found
+}
+
+func (subs *Subroutines) problem(kind SubroutineProblemKind, n *Node, sub *Subroutine, format string, args ...any) {
+	subs.Problems = append(subs.Problems, &SubroutineProblem{Kind: kind, Node: n, Subroutine: sub, Message: fmt.Sprintf(format, args...)})
+}
+
+// checkReturns looks for subroutines that don't return properly, and RETURNs
+// run by the main program.
+func (subs *Subroutines) checkReturns() {
+	g := subs.CFG
+
+	for _, n := range g.Nodes {
+		if subs.Main[n] && n.Command != nil && n.Command.Keyword == "RETURN" {
+			subs.problem(ReturnWithoutGosub, n, nil, "RETURN can be reached without GOSUB")
+		}
+	}
+
+	for _, sub := range subs.All {
+		if len(sub.Returns) == 0 {
+			subs.problem(NoReturn, sub.Entry, sub, "Subroutine at line %d never returns", sub.Line.Label)
+		}
+
+		for _, n := range sub.body {
+			if n.Command == nil {
+				continue
+			}
+			for _, e := range n.Out {
+				if e.Kind != FallThroughEdge && e.Kind != FalseEdge {
+					continue
+				}
+				var message string
+				switch other := subs.byEntry[e.To]; {
+				case e.To == g.Exit:
+					message = fmt.Sprintf("Subroutine at line %d runs off the end of the program without RETURN", sub.Line.Label)
+				case other != nil && other != sub:
+					message = fmt.Sprintf("Subroutine at line %d runs into the subroutine at line %d without RETURN", sub.Line.Label, other.Line.Label)
+				case subs.Main[e.To] && !subs.Main[n] && !subs.jumpedInto(sub, e.To):
+					message = fmt.Sprintf("Subroutine at line %d runs into the main program at line %d without RETURN", sub.Line.Label, e.To.Line().Label)
+				default:
+					continue
+				}
+				subs.Problems = append(subs.Problems, &SubroutineProblem{Kind: FallsThrough, Node: n, Subroutine: sub, Target: e.To, Message: message})
+			}
+		}
+	}
+}
+
+// jumpedInto checks if a GOTO from outside a subroutine arrives at a node in
+// its body.
+func (subs *Subroutines) jumpedInto(sub *Subroutine, n *Node) bool {
+	for _, e := range n.In {
+		if e.Kind == GotoEdge && !sub.Body[e.From] {
+			return true
+		}
+	}
+	return false
+}
+
+// checkEntries looks for GOTOs into subroutines from outside them.
+func (subs *Subroutines) checkEntries() {
+	for _, sub := range subs.All {
+		for _, n := range sub.body {
+			for _, e := range n.In {
+				if e.Kind != GotoEdge || sub.Body[e.From] {
+					continue
+				}
+				if n == sub.Entry {
+					subs.problem(GotoSubroutine, e.From, sub, "GOTO %d enters a subroutine called by GOSUB", sub.Line.Label)
+				} else {
+					subs.problem(GotoSubroutine, e.From, sub, "GOTO %d jumps into the subroutine at line %d", n.Line().Label, sub.Line.Label)
+				}
+			}
+		}
+	}
+}
+
+// checkRecursion looks for GOSUBs that can call a subroutine that is already
+// running, directly or through other subroutines.
+func (subs *Subroutines) checkRecursion() {
+	for _, sub := range subs.All {
+		for _, call := range sub.Callees {
+			path := subs.callPath(subs.byEntry[call.To], sub)
+			if path == nil {
+				continue
+			}
+			labels := []string{fmt.Sprint(sub.Line.Label)}
+			for _, s := range path {
+				labels = append(labels, fmt.Sprint(s.Line.Label))
+			}
+			subs.problem(RecursiveCall, call.From, sub, "Recursive GOSUB: %s", strings.Join(labels, " → "))
+		}
+	}
+}
+
+// callPath finds a chain of GOSUBs from one subroutine to another, including
+// both ends, or nil if there is none.
+func (subs *Subroutines) callPath(from, to *Subroutine) []*Subroutine {
+	prev := map[*Subroutine]*Subroutine{from: nil}
+	work := []*Subroutine{from}
+	for len(work) > 0 {
+		cur := work[0]
+		work = work[1:]
+		if cur == to {
+			path := []*Subroutine{}
+			for s := cur; s != nil; s = prev[s] {
+				path = append([]*Subroutine{s}, path...)
+			}
+			return path
+		}
+		for _, call := range cur.Callees {
+			next := subs.byEntry[call.To]
+			if _, ok := prev[next]; !ok {
+				prev[next] = cur
+				work = append(work, next)
+			}
+		}
+	}
+	return nil
+}
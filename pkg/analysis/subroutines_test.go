@@ -0,0 +1,77 @@
+package analysis
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestAnalyseSubroutines(t *testing.T) {
+	tests := []struct {
+		name string
+		code string
+		// the subroutines' lines, and their RETURNs' lines, those after THEN
+		// last
+		subs []string
+		want []string
+	}{
+		{"returns", "10 GOSUB 100:GOSUB 100:END\n100 PRINT\n110 RETURN\n", []string{"100: 110"}, []string{}},
+		{"conditional return", "10 GOSUB 100:END\n100 IF A THEN RETURN\n110 RETURN\n", []string{"100: 110 100"}, []string{}},
+		{"never returns", "10 GOSUB 100:END\n100 GOTO 100\n", []string{"100:"}, []string{
+			"100: Subroutine at line 100 never returns",
+		}},
+		{"runs off the end", "10 GOSUB 100:END\n100 IF A THEN RETURN\n", []string{"100: 100"}, []string{
+			"100: Subroutine at line 100 runs off the end of the program without RETURN",
+		}},
+		{"runs into another", "10 GOSUB 100:GOSUB 110:END\n100 PRINT\n110 RETURN\n", []string{"100: 110", "110: 110"}, []string{
+			"100: Subroutine at line 100 runs into the subroutine at line 110 without RETURN",
+		}},
+		{"main program returns", "10 PRINT\n20 RETURN\n", []string{}, []string{
+			"20: RETURN can be reached without GOSUB",
+		}},
+		{"GOTO into the body", "10 GOSUB 100:GOTO 110\n100 PRINT\n110 RETURN\n", []string{"100: 110"}, []string{
+			"110: RETURN can be reached without GOSUB",
+			"10: GOTO 110 jumps into the subroutine at line 100",
+		}},
+		{"recursive", "10 GOSUB 100:END\n100 GOSUB 200:RETURN\n200 GOSUB 100:RETURN\n", []string{"100: 100", "200: 200"}, []string{
+			"100: Recursive GOSUB: 100 → 200 → 100",
+			"200: Recursive GOSUB: 200 → 100 → 200",
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			subs := AnalyseSubroutines(BuildCFG(parse(t, tt.code)))
+
+			got := []string{}
+			for _, sub := range subs.All {
+				s := fmt.Sprintf("%d:", sub.Line.Label)
+				for _, n := range sub.Returns {
+					s += fmt.Sprintf(" %d", n.Line().Label)
+				}
+				got = append(got, s)
+			}
+			if !reflect.DeepEqual(got, tt.subs) {
+				t.Errorf("subroutines = %q, want %q", got, tt.subs)
+			}
+
+			got = []string{}
+			for _, p := range subs.Problems {
+				got = append(got, fmt.Sprintf("%d: %s", p.Node.Line().Label, p.Message))
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("problems = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func BenchmarkAnalyseSubroutines(b *testing.B) {
+	old, edited := editedProgram(b)
+	cfg := UpdateCFG(BuildCFG(old), edited)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		AnalyseSubroutines(cfg)
+	}
+}
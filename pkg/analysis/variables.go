@@ -0,0 +1,373 @@
+package analysis
+
+import (
+	"strings"
+
+	"github.com/miselin/c64lsp/pkg/grammar"
+)
+
+// VariableType is the type of a BASIC variable, determined by its suffix.
+type VariableType int
+
+const (
+	// FloatVariable has no suffix and holds a 5-byte floating point number.
+	FloatVariable VariableType = iota
+	// IntegerVariable has a % suffix and holds a 16-bit signed integer.
+	IntegerVariable
+	// StringVariable has a $ suffix and holds a string.
+	StringVariable
+)
+
+func (t VariableType) String() string {
+	switch t {
+	case IntegerVariable:
+		return "integer"
+	case StringVariable:
+		return "string"
+	}
+	return "float"
+}
+
+// ReferenceKind describes how a variable is referenced.
+type ReferenceKind int
+
+const (
+	// UseReference reads the variable.
+	UseReference ReferenceKind = iota
+	// AssignReference assigns the variable with LET or an implicit assignment.
+	AssignReference
+	// ForReference assigns the variable as a FOR loop counter.
+	ForReference
+	// NextReference steps the variable in a NEXT.
+	NextReference
+	// ReadReference assigns the variable from DATA with READ.
+	ReadReference
+	// InputReference assigns the variable from the keyboard or a file with INPUT.
+	InputReference
+	// GetReference assigns the variable from the keyboard or a file with GET.
+	GetReference
+	// DimReference declares the dimensions of an array.
+	DimReference
+)
+
+func (k ReferenceKind) String() string {
+	switch k {
+	case AssignReference:
+		return "LET"
+	case ForReference:
+		return "FOR"
+	case NextReference:
+		return "NEXT"
+	case ReadReference:
+		return "READ"
+	case InputReference:
+		return "INPUT"
+	case GetReference:
+		return "GET"
+	case DimReference:
+		return "DIM"
+	}
+	return "use"
+}
+
+// IsWrite checks if the reference changes the value of the variable.
+func (k ReferenceKind) IsWrite() bool {
+	return k != UseReference && k != DimReference
+}
+
+// Reference is a single occurrence of a variable in the program.
+type Reference struct {
+	Kind    ReferenceKind
+	Command *Command
+	Token   *grammar.StatementToken
+}
+
+// ForLoop is a FOR statement controlling a loop counter.
+type ForLoop struct {
+	Command *Command
+	Counter *grammar.StatementToken
+	Start   []*grammar.StatementToken
+	End     []*grammar.StatementToken
+	// Empty when the loop has no STEP clause.
+	Step []*grammar.StatementToken
+}
+
+// Variable is a single BASIC variable, identified by its effective name.
+type Variable struct {
+	// Effective name: the first two characters plus type suffix, upper-cased.
+	Name  string
+	Type  VariableType
+	Array bool
+	// Every distinct spelling of the variable in the source, upper-cased.
+	Spellings []string
+	// Dimension expressions from DIM, empty if the array is not dimensioned.
+	Dimensions [][]*grammar.StatementToken
+	References []*Reference
+	Loops      []*ForLoop
+}
+
+// Definitions returns the references that write to the variable.
+func (v *Variable) Definitions() []*Reference {
+	defs := []*Reference{}
+	for _, ref := range v.References {
+		if ref.Kind.IsWrite() {
+			defs = append(defs, ref)
+		}
+	}
+	return defs
+}
+
+// Uses returns the references that read the variable.
+func (v *Variable) Uses() []*Reference {
+	uses := []*Reference{}
+	for _, ref := range v.References {
+		if ref.Kind == UseReference {
+			uses = append(uses, ref)
+		}
+	}
+	return uses
+}
+
+// Variables holds every variable referenced by a program.
+type Variables struct {
+	// Variables in order of first reference.
+	All []*Variable
+
+	byKey   map[string]*Variable
+	byToken map[*grammar.StatementToken]*Variable
+}
+
+// EffectiveName returns the name C64 BASIC uses to identify a variable: only
+// the first two characters of a name are significant, plus the type suffix.
+func EffectiveName(name string) string {
+	name = strings.ToUpper(name)
+
+	suffix := ""
+	if strings.HasSuffix(name, "$") || strings.HasSuffix(name, "%") {
+		suffix = name[len(name)-1:]
+		name = name[:len(name)-1]
+	}
+	if len(name) > 2 {
+		name = name[:2]
+	}
+	return name + suffix
+}
+
+// TypeOf returns the type of a variable from its name.
+func TypeOf(name string) VariableType {
+	switch {
+	case strings.HasSuffix(name, "$"):
+		return StringVariable
+	case strings.HasSuffix(name, "%"):
+		return IntegerVariable
+	}
+	return FloatVariable
+}
+
+// Lookup returns the variable referenced by a token, or nil.
+func (vs *Variables) Lookup(tok *grammar.StatementToken) *Variable {
+	return vs.byToken[tok]
+}
+
+// Find returns a variable by its effective name, or nil.
+func (vs *Variables) Find(name string, array bool) *Variable {
+	return vs.byKey[variableKey(EffectiveName(name), array)]
+}
+
+func variableKey(name string, array bool) string {
+	// arrays and scalars with the same name are distinct variables
+	if array {
+		return name + "("
+	}
+	return name
+}
+
+// CollectVariables finds every variable definition and use in a program.
+func CollectVariables(program *grammar.Program) *Variables {
+	vs := &Variables{
+		byKey:   make(map[string]*Variable),
+		byToken: make(map[*grammar.StatementToken]*Variable),
+	}
+
+	for _, line := range program.Lines {
+		for _, cmd := range LineCommands(line) {
+			vs.collectCommand(cmd)
+		}
+	}
+
+	return vs
+}
+
+func (vs *Variables) add(kind ReferenceKind, cmd *Command, toks []*grammar.StatementToken, i int) *Variable {
+	tok := toks[i]
+	name := strings.ToUpper(tok.VariableName())
+	array := isSubscripted(toks, i)
+
+	key := variableKey(EffectiveName(name), array)
+	v, ok := vs.byKey[key]
+	if !ok {
+		v = &Variable{
+			Name:  EffectiveName(name),
+			Type:  TypeOf(name),
+			Array: array,
+		}
+		vs.byKey[key] = v
+		vs.All = append(vs.All, v)
+	}
+
+	found := false
+	for _, s := range v.Spellings {
+		if s == name {
+			found = true
+			break
+		}
+	}
+	if !found {
+		v.Spellings = append(v.Spellings, name)
+	}
+
+	v.References = append(v.References, &Reference{Kind: kind, Command: cmd, Token: tok})
+	vs.byToken[tok] = v
+	return v
+}
+
+func isSubscripted(toks []*grammar.StatementToken, i int) bool {
+	return i+1 < len(toks) && toks[i+1].Value != nil && toks[i+1].Value.Subexpression != nil
+}
+
+func isFunctionName(toks []*grammar.StatementToken, i int) bool {
+	return i > 0 && toks[i-1].IsKeyword("FN")
+}
+
+// collectUses records every variable in a run of tokens as a use.
+func (vs *Variables) collectUses(cmd *Command, toks []*grammar.StatementToken) {
+	vs.collectUsesExcept(cmd, toks, "")
+}
+
+// collectUsesExcept is collectUses leaving out a plain variable with the
+// effective name local, such as the parameter of a DEF FN.
+func (vs *Variables) collectUsesExcept(cmd *Command, toks []*grammar.StatementToken, local string) {
+	for i, tok := range toks {
+		if name := tok.VariableName(); name != "" && !isFunctionName(toks, i) {
+			if local == "" || EffectiveName(name) != local || isSubscripted(toks, i) {
+				vs.add(UseReference, cmd, toks, i)
+			}
+		} else if tok.Value != nil && tok.Value.Subexpression != nil {
+			vs.collectUsesExcept(cmd, tok.Value.Subexpression.Tokens, local)
+		}
+	}
+}
+
+// collectTargets records every top-level variable in a run of tokens as a
+// write of the given kind, and anything in subscripts as uses.
+func (vs *Variables) collectTargets(kind ReferenceKind, cmd *Command, toks []*grammar.StatementToken) {
+	for i, tok := range toks {
+		if tok.VariableName() != "" {
+			vs.add(kind, cmd, toks, i)
+		} else if tok.Value != nil && tok.Value.Subexpression != nil {
+			vs.collectUses(cmd, tok.Value.Subexpression.Tokens)
+		}
+	}
+}
+
+func (vs *Variables) collectCommand(cmd *Command) {
+	args := cmd.Args
+
+	switch cmd.Keyword {
+	case "LET":
+		eq := indexOfKeyword(args, "=")
+		if eq < 0 || len(args) == 0 || args[0].VariableName() == "" {
+			vs.collectUses(cmd, args)
+			return
+		}
+		vs.collectTargets(AssignReference, cmd, args[:eq])
+		vs.collectUses(cmd, args[eq+1:])
+	case "FOR":
+		if len(args) == 0 || args[0].VariableName() == "" {
+			vs.collectUses(cmd, args)
+			return
+		}
+		loop := splitFor(cmd)
+		v := vs.add(ForReference, cmd, args, 0)
+		v.Loops = append(v.Loops, loop)
+		vs.collectUses(cmd, loop.Start)
+		vs.collectUses(cmd, loop.End)
+		vs.collectUses(cmd, loop.Step)
+	case "NEXT":
+		vs.collectTargets(NextReference, cmd, args)
+	case "READ":
+		vs.collectTargets(ReadReference, cmd, args)
+	case "INPUT", "INPUT#":
+		vs.collectTargets(InputReference, cmd, args)
+	case "GET":
+		vs.collectTargets(GetReference, cmd, args)
+	case "DIM":
+		for i, tok := range args {
+			if tok.VariableName() == "" {
+				continue
+			}
+			v := vs.add(DimReference, cmd, args, i)
+			if isSubscripted(args, i) {
+				sub := args[i+1].Value.Subexpression.Tokens
+				v.Dimensions = SplitArgs(sub)
+				vs.collectUses(cmd, sub)
+			}
+		}
+	case "DEF":
+		// DEF FN name(param) = body; the parameter is local to the body.
+		eq := indexOfKeyword(args, "=")
+		if eq < 0 {
+			return
+		}
+		param := ""
+		if eq > 0 && args[eq-1].Value != nil && args[eq-1].Value.Subexpression != nil {
+			if toks := args[eq-1].Value.Subexpression.Tokens; len(toks) > 0 {
+				param = EffectiveName(toks[0].VariableName())
+			}
+		}
+		vs.collectUsesExcept(cmd, args[eq+1:], param)
+	case "IF":
+		vs.collectUses(cmd, args)
+		if cmd.Then != nil {
+			vs.collectCommand(cmd.Then)
+		}
+	default:
+		vs.collectUses(cmd, args)
+	}
+}
+
+func indexOfKeyword(toks []*grammar.StatementToken, keyword string) int {
+	for i, tok := range toks {
+		if tok.IsKeyword(keyword) {
+			return i
+		}
+	}
+	return -1
+}
+
+// splitFor splits a FOR command into its counter, start, end and step.
+func splitFor(cmd *Command) *ForLoop {
+	loop := &ForLoop{Command: cmd, Counter: cmd.Args[0]}
+
+	rest := cmd.Args[1:]
+	if len(rest) > 0 && rest[0].IsKeyword("=") {
+		rest = rest[1:]
+	}
+
+	to := indexOfKeyword(rest, "TO")
+	if to < 0 {
+		loop.Start = rest
+		return loop
+	}
+	loop.Start = rest[:to]
+	rest = rest[to+1:]
+
+	step := indexOfKeyword(rest, "STEP")
+	if step < 0 {
+		loop.End = rest
+		return loop
+	}
+	loop.End = rest[:step]
+	loop.Step = rest[step+1:]
+	return loop
+}
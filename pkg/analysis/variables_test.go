@@ -0,0 +1,50 @@
+package analysis
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestCollectVariables(t *testing.T) {
+	tests := []struct {
+		name string
+		code string
+		// each variable's references, as kind@line
+		want map[string][]string
+	}{
+		{"assignment", "10 A=1\n20 PRINT A\n", map[string][]string{
+			"A": {"LET@10", "use@20"},
+		}},
+		{"effective name", "10 SCORE=1:SC=2\n", map[string][]string{
+			"SC": {"LET@10", "LET@10"},
+		}},
+		{"DEF FN parameter", "10 X=5:Y=2\n20 DEF FN F(X)=X*Y\n30 PRINT FN F(X)\n", map[string][]string{
+			"X": {"LET@10", "use@30"},
+			"Y": {"LET@10", "use@20"},
+		}},
+		{"DEF FN array", "10 DEF FN F(X)=X(X)\n", map[string][]string{
+			"X()": {"use@10"},
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			vs := CollectVariables(parse(t, tt.code))
+
+			got := map[string][]string{}
+			for _, v := range vs.All {
+				name := v.Name
+				if v.Array {
+					name += "()"
+				}
+				for _, ref := range v.References {
+					got[name] = append(got[name], fmt.Sprintf("%s@%d", ref.Kind, ref.Command.Line.Label))
+				}
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("references = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -72,6 +72,14 @@ func (grammar *BasicGrammar) Parse(filename, code string) (*Program, error) {
 		return nil, err
 	}
 
+	program.Source = code
+	program.index()
+
+	return program, nil
+}
+
+// index builds the tables of lines by label and by file line.
+func (program *Program) index() {
 	program.BasicTable = make(map[int]*BasicLine)
 	program.FileTable = make(map[int]*BasicLine)
 
@@ -79,8 +87,6 @@ func (grammar *BasicGrammar) Parse(filename, code string) (*Program, error) {
 		program.BasicTable[cmd.Label] = cmd
 		program.FileTable[cmd.Pos.Line] = cmd
 	}
-
-	return program, nil
 }
 
 func DumpStatement(stmt *Statement, indent int) {
@@ -161,3 +167,162 @@ func (program *Program) FindBasicTokenAt(line, character int) *string {
 
 	return nil
 }
+
+// FindTokenAt returns the innermost token at the given 0-based line and
+// character, descending into parenthesised subexpressions. A token that
+// starts at the character wins over one that ends there, so that a cursor
+// just after a token still finds it when nothing follows.
+func (program *Program) FindTokenAt(line, character int) *StatementToken {
+	l := program.FindTextLine(line)
+	if l == nil {
+		return nil
+	}
+
+	// lexer columns are 1-based
+	column := character + 1
+	var before *StatementToken
+	for _, stmt := range l.Statements {
+		at, ending := program.findTokenInStatement(stmt, column)
+		if at != nil {
+			return at
+		}
+		if before == nil {
+			before = ending
+		}
+	}
+
+	return before
+}
+
+// findTokenInStatement returns the token covering a column, or failing that
+// one that ends just before it.
+func (program *Program) findTokenInStatement(stmt *Statement, column int) (at, before *StatementToken) {
+	for _, tok := range stmt.Tokens {
+		if tok.Value != nil && tok.Value.Subexpression != nil {
+			inner, ending := program.findTokenInStatement(tok.Value.Subexpression, column)
+			if inner != nil {
+				return inner, nil
+			}
+			if before == nil {
+				before = ending
+			}
+			continue
+		}
+
+		start, end := program.TokenSpan(tok)
+		if start.Column <= column && column < end.Column {
+			return tok, nil
+		}
+		if column == end.Column && before == nil {
+			before = tok
+		}
+	}
+
+	return nil, before
+}
+
+// Text returns the source text between two positions.
+func (program *Program) Text(start, end lexer.Position) string {
+	if start.Offset < 0 || end.Offset > len(program.Source) || start.Offset > end.Offset {
+		return ""
+	}
+
+	return program.Source[start.Offset:end.Offset]
+}
+
+// LineIndex returns the index of a line in Lines, or -1 if it is not part of the program.
+func (program *Program) LineIndex(line *BasicLine) int {
+	for i, l := range program.Lines {
+		if l == line {
+			return i
+		}
+	}
+	return -1
+}
+
+// LineText returns the source text of a BASIC line, including its label.
+func (program *Program) LineText(line *BasicLine) string {
+	text := program.Source[line.Pos.Offset:]
+	if i := strings.IndexAny(text, "\r\n"); i >= 0 {
+		text = text[:i]
+	}
+	return strings.TrimRight(text, " \t")
+}
+
+// TokenText returns the source text of a token, excluding any trailing separator.
+func (program *Program) TokenText(tok *StatementToken) string {
+	start, end := program.TokenSpan(tok)
+	return program.Text(start, end)
+}
+
+// TokensText returns the source text covered by a run of tokens, excluding any
+// trailing separator on the final token.
+func (program *Program) TokensText(toks []*StatementToken) string {
+	if len(toks) == 0 {
+		return ""
+	}
+
+	start, _ := program.TokenSpan(toks[0])
+	_, end := program.TokenSpan(toks[len(toks)-1])
+	return program.Text(start, end)
+}
+
+// TokenSpan returns the start and end positions of the token itself,
+// excluding any trailing separator or whitespace.
+func (program *Program) TokenSpan(tok *StatementToken) (lexer.Position, lexer.Position) {
+	if tok.Value != nil {
+		return tok.Value.Pos, program.trimEnd(tok.Value.Pos, tok.Value.EndPos)
+	}
+
+	end := tok.Pos
+	if tok.BasicToken != nil {
+		n := len(*tok.BasicToken)
+		end.Offset += n
+		end.Column += n
+	}
+	return tok.Pos, end
+}
+
+// StatementSpan returns the start and end positions of a statement, excluding
+// trailing whitespace.
+func (program *Program) StatementSpan(stmt *Statement) (lexer.Position, lexer.Position) {
+	return stmt.Pos, program.trimEnd(stmt.Pos, stmt.EndPos)
+}
+
+// trimEnd moves an end position back over any whitespace the parser consumed
+// after the final token of a node.
+func (program *Program) trimEnd(start, end lexer.Position) lexer.Position {
+	for end.Offset > start.Offset && end.Offset <= len(program.Source) {
+		c := program.Source[end.Offset-1]
+		if c != ' ' && c != '\t' {
+			break
+		}
+		end.Offset--
+		end.Column--
+	}
+	return end
+}
+
+// Keyword returns the upper-case BASIC token, or an empty string for values.
+func (tok *StatementToken) Keyword() string {
+	if tok.BasicToken == nil {
+		return ""
+	}
+
+	return strings.ToUpper(*tok.BasicToken)
+}
+
+// IsKeyword checks if the token is the given BASIC token.
+func (tok *StatementToken) IsKeyword(keyword string) bool {
+	return tok.BasicToken != nil && strings.EqualFold(*tok.BasicToken, keyword)
+}
+
+// VariableName returns the variable name referenced by the token, or an empty
+// string if the token is not a variable.
+func (tok *StatementToken) VariableName() string {
+	if tok.Value == nil || tok.Value.Variable == nil {
+		return ""
+	}
+
+	return *tok.Value.Variable
+}
@@ -13,6 +13,8 @@ type Program struct {
 	BasicTable map[int]*BasicLine
 	// Map of real file line numbers to tokenized lines
 	FileTable map[int]*BasicLine
+	// Source text the program was parsed from
+	Source string
 }
 
 type BasicLine struct {
@@ -26,13 +28,15 @@ type BasicLine struct {
 }
 
 type Statement struct {
-	Pos lexer.Position
+	Pos    lexer.Position
+	EndPos lexer.Position
 
 	Tokens []*StatementToken `( @@ )+`
 }
 
 type StatementToken struct {
-	Pos lexer.Position
+	Pos    lexer.Position
+	EndPos lexer.Position
 
 	BasicToken *string `( @BasicToken`
 	Value      *Value  ` | @@ )`
@@ -40,7 +44,8 @@ type StatementToken struct {
 }
 
 type Value struct {
-	Pos lexer.Position
+	Pos    lexer.Position
+	EndPos lexer.Position
 
 	Number   *float64 `  @Number`
 	Variable *string  `| @Ident`
@@ -0,0 +1,40 @@
+package grammar
+
+import "testing"
+
+func TestFindTokenAt(t *testing.T) {
+	g := NewGrammar()
+	program, err := g.Parse("test.bas", "10 p=p-1\n20 x=q+rnd(1)\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		line, character int
+		want            string
+	}{
+		{0, 3, "p"},
+		{0, 4, "="},
+		{0, 5, "p"},
+		{0, 6, "-"},
+		{0, 7, "1"},
+		// just after the last token
+		{0, 8, "1"},
+		{1, 5, "q"},
+		{1, 6, "+"},
+		{1, 7, "rnd"},
+		{1, 9, "rnd"},
+		{1, 11, "1"},
+	}
+
+	for _, tt := range tests {
+		tok := program.FindTokenAt(tt.line, tt.character)
+		if tok == nil {
+			t.Errorf("token at %d:%d = nil, want %q", tt.line, tt.character, tt.want)
+			continue
+		}
+		if got := program.TokenText(tok); got != tt.want {
+			t.Errorf("token at %d:%d = %q, want %q", tt.line, tt.character, got, tt.want)
+		}
+	}
+}
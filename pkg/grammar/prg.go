@@ -0,0 +1,76 @@
+package grammar
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/miselin/c64lsp/pkg/reference"
+)
+
+// Detokenize lists a tokenised BASIC program, as saved in a .prg file, as
+// source text in the style of petcat: keywords in lower case, and mnemonics
+// for anything in strings and REMs that can't be typed directly.
+func Detokenize(prg []byte) (string, error) {
+	if len(prg) < 2 {
+		return "", fmt.Errorf("program has no load address")
+	}
+
+	// each line is a pointer to the next line, a line number, and the
+	// tokenised line ending in a zero byte; a null pointer ends the program
+	data := prg[2:]
+	var sb strings.Builder
+	for pos := 0; pos+1 < len(data) && (data[pos] != 0 || data[pos+1] != 0); {
+		if pos+4 > len(data) {
+			return "", fmt.Errorf("program is truncated at offset %d", pos+2)
+		}
+		label := int(data[pos+2]) | int(data[pos+3])<<8
+		pos += 4
+
+		end := bytes.IndexByte(data[pos:], 0)
+		if end < 0 {
+			return "", fmt.Errorf("line %d is not terminated", label)
+		}
+
+		sb.WriteString(strconv.Itoa(label))
+		sb.WriteByte(' ')
+		sb.WriteString(detokenizeLine(data[pos : pos+end]))
+		sb.WriteByte('\n')
+		pos += end + 1
+	}
+
+	return sb.String(), nil
+}
+
+// detokenizeLine lists the statements of a single tokenised line.
+func detokenizeLine(line []byte) string {
+	var sb strings.Builder
+	quoted, rem := false, false
+
+	literal := []byte{}
+	flush := func() {
+		sb.WriteString(reference.EncodeString(literal, reference.PetcatStyle))
+		literal = literal[:0]
+	}
+
+	for _, c := range line {
+		switch {
+		case c == '"' && !rem:
+			flush()
+			sb.WriteByte('"')
+			quoted = !quoted
+		case c >= 0x80 && int(c-0x80) < len(tokens) && !quoted && !rem:
+			// tokens are listed in order of their values
+			flush()
+			keyword := strings.ToLower(tokens[c-0x80])
+			sb.WriteString(keyword)
+			rem = keyword == "rem"
+		default:
+			literal = append(literal, c)
+		}
+	}
+	flush()
+
+	return sb.String()
+}
@@ -0,0 +1,152 @@
+package grammar
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/alecthomas/participle/v2"
+	"github.com/alecthomas/participle/v2/lexer"
+)
+
+// Reparse parses a new version of a program's source, parsing again only the
+// lines that changed. BASIC lines are independent of each other, so the lines
+// before the change are kept as they are and the lines after it are moved to
+// their new positions. The old program is not modified, and may share lines
+// with the new one.
+//
+// The whole source is parsed if there is no old program, or if a string is
+// left open at the end of a line, as it may then run on to the next.
+func (grammar *BasicGrammar) Reparse(old *Program, filename, code string) (*Program, error) {
+	if old == nil || hasOpenString(old.Source) || hasOpenString(code) {
+		return grammar.Parse(filename, code)
+	}
+	if code == old.Source {
+		return old, nil
+	}
+
+	// find the changed bytes from the common prefix and suffix
+	src := old.Source
+	prefix := 0
+	for prefix < len(src) && prefix < len(code) && src[prefix] == code[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(src)-prefix && suffix < len(code)-prefix && src[len(src)-1-suffix] == code[len(code)-1-suffix] {
+		suffix++
+	}
+	changeEnd := len(src) - suffix
+
+	// the changed lines run from the last line starting before the change,
+	// which may gain the newlines the change starts with, to the first line
+	// starting after it
+	first := 0
+	for first+1 < len(old.Lines) && textLineStart(src, old.Lines[first+1].Pos.Offset) < prefix {
+		first++
+	}
+	last := first
+	for last < len(old.Lines) && textLineStart(src, old.Lines[last].Pos.Offset) <= changeEnd {
+		last++
+	}
+
+	start, line := 0, 0
+	if first > 0 {
+		start = textLineStart(src, old.Lines[first].Pos.Offset)
+		line = old.Lines[first].Pos.Line - 1
+	}
+	oldEnd := len(src)
+	if last < len(old.Lines) {
+		oldEnd = textLineStart(src, old.Lines[last].Pos.Offset)
+	}
+	end := oldEnd + len(code) - len(src)
+
+	fragment, err := grammar.parser.ParseString(filename, code[start:end])
+	if err != nil {
+		var perr participle.Error
+		if errors.As(err, &perr) {
+			pos := perr.Position()
+			movePos(&pos, start, line)
+			return nil, participle.Errorf(pos, "%s", perr.Message())
+		}
+		return nil, err
+	}
+
+	program := &Program{Pos: old.Pos, Source: code}
+	program.Lines = make([]*BasicLine, 0, first+len(fragment.Lines)+len(old.Lines)-last)
+	program.Lines = append(program.Lines, old.Lines[:first]...)
+	for _, l := range fragment.Lines {
+		program.Lines = append(program.Lines, moveLine(l, start, line))
+	}
+	lines := strings.Count(code[start:end], "\n") - strings.Count(src[start:oldEnd], "\n")
+	for _, l := range old.Lines[last:] {
+		program.Lines = append(program.Lines, moveLine(l, len(code)-len(src), lines))
+	}
+	program.index()
+
+	return program, nil
+}
+
+// hasOpenString checks if any line has an odd number of quotes.
+func hasOpenString(code string) bool {
+	open := false
+	for i := 0; i < len(code); i++ {
+		switch code[i] {
+		case '"':
+			open = !open
+		case '\n':
+			if open {
+				return true
+			}
+		}
+	}
+	return open
+}
+
+// textLineStart returns the offset of the start of the text line containing
+// an offset.
+func textLineStart(code string, offset int) int {
+	return strings.LastIndexByte(code[:offset], '\n') + 1
+}
+
+func movePos(pos *lexer.Position, offset, lines int) {
+	pos.Offset += offset
+	pos.Line += lines
+}
+
+// moveLine returns a copy of a line with every position moved by an offset
+// and a number of lines.
+func moveLine(line *BasicLine, offset, lines int) *BasicLine {
+	if offset == 0 && lines == 0 {
+		return line
+	}
+
+	moved := *line
+	movePos(&moved.Pos, offset, lines)
+	moved.Statements = nil
+	for _, stmt := range line.Statements {
+		moved.Statements = append(moved.Statements, moveStatement(stmt, offset, lines))
+	}
+	return &moved
+}
+
+func moveStatement(stmt *Statement, offset, lines int) *Statement {
+	moved := *stmt
+	movePos(&moved.Pos, offset, lines)
+	movePos(&moved.EndPos, offset, lines)
+	moved.Tokens = make([]*StatementToken, len(stmt.Tokens))
+	for i, tok := range stmt.Tokens {
+		t := *tok
+		movePos(&t.Pos, offset, lines)
+		movePos(&t.EndPos, offset, lines)
+		if tok.Value != nil {
+			v := *tok.Value
+			movePos(&v.Pos, offset, lines)
+			movePos(&v.EndPos, offset, lines)
+			if v.Subexpression != nil {
+				v.Subexpression = moveStatement(v.Subexpression, offset, lines)
+			}
+			t.Value = &v
+		}
+		moved.Tokens[i] = &t
+	}
+	return &moved
+}
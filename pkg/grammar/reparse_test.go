@@ -0,0 +1,107 @@
+package grammar
+
+import (
+	"fmt"
+	"math/rand"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// listing generates a program of n lines, covering most of the grammar.
+func listing(n int) string {
+	templates := []string{
+		"rem section %d",
+		"for i=1 to %d : poke 1024+i,32 : next i",
+		`print "{clr}hello";chr$(147);a$(%d)`,
+		"if a>%d then gosub 500",
+		"data 1,2,3,%d",
+		"x=peek(53280) and 15 or %d",
+		"on k goto 10,20,%d",
+		"return",
+	}
+
+	var sb strings.Builder
+	for i := 0; i < n; i++ {
+		fmt.Fprintf(&sb, "%d %s\n", (i+1)*10, strings.ReplaceAll(templates[i%len(templates)], "%d", strconv.Itoa(i)))
+	}
+	return sb.String()
+}
+
+// TestReparse checks that reparsing after random edits gives the same program
+// as parsing the edited text from scratch.
+func TestReparse(t *testing.T) {
+	g := NewGrammar()
+	src := listing(40)
+	old, err := g.Parse("test.bas", src)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	edits := 500
+	if testing.Short() {
+		edits = 50
+	}
+	r := rand.New(rand.NewSource(1))
+	pieces := []string{"", "\n", "1", "x", " ", "\n15 print 1\n", `"hi"`, `"`, ":", "20 goto 10\n", "rem z", "\n\n", "a=1"}
+	for i := 0; i < edits; i++ {
+		start := r.Intn(len(src) + 1)
+		end := start + r.Intn(min(8, len(src)-start)+1)
+		code := src[:start] + pieces[r.Intn(len(pieces))] + src[end:]
+
+		want, wantErr := g.Parse("test.bas", code)
+		got, gotErr := g.Reparse(old, "test.bas", code)
+		if (wantErr == nil) != (gotErr == nil) {
+			t.Fatalf("edit %d: Reparse error %v, Parse error %v, for %q", i, gotErr, wantErr, code)
+		}
+		if wantErr != nil {
+			continue
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Fatalf("edit %d: Reparse and Parse differ for %q", i, code)
+		}
+
+		// carry on from the edited program some of the time
+		if r.Intn(3) == 0 {
+			old, src = got, code
+		}
+	}
+}
+
+// editedListing returns a large program, and the same program with a
+// statement added to a line in the middle.
+func editedListing() (string, string) {
+	src := listing(4000)
+	mid := len(src) / 2
+	mid += strings.IndexByte(src[mid:], '\n')
+	return src, src[:mid] + ":a=1" + src[mid:]
+}
+
+func BenchmarkParse(b *testing.B) {
+	g := NewGrammar()
+	_, code := editedListing()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := g.Parse("test.bas", code); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkReparse(b *testing.B) {
+	g := NewGrammar()
+	src, code := editedListing()
+	old, err := g.Parse("test.bas", src)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := g.Reparse(old, "test.bas", code); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package grammar
+
+import (
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/alecthomas/participle/v2/lexer"
+)
+
+// StringSegment is a run of plain text or a {mnemonic} within a string literal.
+type StringSegment struct {
+	// Rune offsets of the segment within the string, after the opening quote.
+	Start, End int
+	// Source text of the segment, including any braces.
+	Text string
+	// Set for {mnemonic} segments.
+	IsMnemonic bool
+	// Mnemonic without braces or repeat count, e.g. "down" for "{3 down}".
+	Mnemonic string
+	// Number of times the mnemonic repeats, 1 unless given.
+	Count int
+}
+
+// StringSegments splits the contents of a string literal into plain text and
+// {mnemonic} segments, as used by petcat and CBM prgStudio listings. An
+// unterminated brace is treated as plain text.
+func StringSegments(contents string) []StringSegment {
+	segments := []StringSegment{}
+
+	offset := 0
+	add := func(text string, mnemonic bool) {
+		n := utf8.RuneCountInString(text)
+		seg := StringSegment{Start: offset, End: offset + n, Text: text, IsMnemonic: mnemonic, Count: 1}
+		if mnemonic {
+			seg.Mnemonic, seg.Count = splitRepeat(text[1 : len(text)-1])
+		}
+		segments = append(segments, seg)
+		offset += n
+	}
+
+	for len(contents) > 0 {
+		open := strings.IndexByte(contents, '{')
+		if open < 0 {
+			add(contents, false)
+			break
+		}
+		close := strings.IndexByte(contents[open:], '}')
+		if close < 0 {
+			add(contents, false)
+			break
+		}
+		if open > 0 {
+			add(contents[:open], false)
+		}
+		add(contents[open:open+close+1], true)
+		contents = contents[open+close+1:]
+	}
+
+	return segments
+}
+
+// splitRepeat separates a leading repeat count from a mnemonic, e.g. "3 down".
+func splitRepeat(mnemonic string) (string, int) {
+	mnemonic = strings.TrimSpace(mnemonic)
+	count, rest, found := strings.Cut(mnemonic, " ")
+	if !found {
+		return mnemonic, 1
+	}
+	n, err := strconv.Atoi(count)
+	if err != nil || n < 1 {
+		return mnemonic, 1
+	}
+	return strings.TrimSpace(rest), n
+}
+
+// StringContents returns the source text between the quotes of a string
+// literal token, and the position of its first character.
+func (program *Program) StringContents(tok *StatementToken) (string, lexer.Position) {
+	start, end := program.TokenSpan(tok)
+	text := program.Text(start, end)
+
+	text = strings.TrimPrefix(text, "\"")
+	text = strings.TrimSuffix(text, "\"")
+	start.Offset++
+	start.Column++
+	return text, start
+}
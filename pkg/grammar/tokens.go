@@ -1,5 +1,7 @@
 package grammar
 
+// tokens holds the BASIC keywords in order of their token values, starting
+// at $80.
 var tokens = []string{
 	"END",
 	"FOR",
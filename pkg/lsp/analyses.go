@@ -0,0 +1,97 @@
+package lsp
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"github.com/miselin/c64lsp/pkg/analysis"
+	"github.com/miselin/c64lsp/pkg/grammar"
+)
+
+// programAnalysis holds the whole-program analyses of a parsed program, each
+// built the first time a request needs it. Requests run concurrently, so each
+// analysis is built at most once however many ask for it at the same time.
+//
+// A new parse gets a new programAnalysis, but most edits don't change how
+// control flows through the program, so it keeps the last control-flow
+// graph and loops that were built for an earlier version. The graph's
+// RETURN edges and the loops, which take most of the time, are reused when
+// the new graph has the same shape. The other analyses are quick, and start
+// from scratch.
+type programAnalysis struct {
+	program *grammar.Program
+
+	// from an earlier version of the program, either of them nil
+	prevCFG   *analysis.CFG
+	prevLoops *analysis.Loops
+
+	cfgOnce   sync.Once
+	cfg       atomic.Pointer[analysis.CFG]
+	varsOnce  sync.Once
+	vars      *analysis.Variables
+	loopsOnce sync.Once
+	loops     atomic.Pointer[analysis.Loops]
+	subsOnce  sync.Once
+	subs      *analysis.Subroutines
+	memOnce   sync.Once
+	mem       []*analysis.MemoryAccess
+}
+
+func newProgramAnalysis(program *grammar.Program) *programAnalysis {
+	return &programAnalysis{program: program}
+}
+
+// nextProgramAnalysis starts the analyses of a new version of a program,
+// reusing what it can of those of prev, which may be nil.
+func nextProgramAnalysis(prev *programAnalysis, program *grammar.Program) *programAnalysis {
+	a := newProgramAnalysis(program)
+	if prev == nil {
+		return a
+	}
+
+	// prev may not have got round to building them, e.g. if its diagnostics
+	// were cancelled, in which case its own earlier ones are still the best
+	a.prevCFG, a.prevLoops = prev.prevCFG, prev.prevLoops
+	if cfg := prev.cfg.Load(); cfg != nil {
+		a.prevCFG = cfg
+	}
+	if loops := prev.loops.Load(); loops != nil {
+		a.prevLoops = loops
+	}
+	return a
+}
+
+func (a *programAnalysis) CFG() *analysis.CFG {
+	a.cfgOnce.Do(func() {
+		a.cfg.Store(analysis.UpdateCFG(a.prevCFG, a.program))
+	})
+	return a.cfg.Load()
+}
+
+func (a *programAnalysis) Variables() *analysis.Variables {
+	a.varsOnce.Do(func() {
+		a.vars = analysis.CollectVariables(a.program)
+	})
+	return a.vars
+}
+
+func (a *programAnalysis) Loops() *analysis.Loops {
+	a.loopsOnce.Do(func() {
+		a.loops.Store(analysis.UpdateLoops(a.prevLoops, a.CFG()))
+	})
+	return a.loops.Load()
+}
+
+func (a *programAnalysis) Subroutines() *analysis.Subroutines {
+	a.subsOnce.Do(func() {
+		a.subs = analysis.AnalyseSubroutines(a.CFG())
+	})
+	return a.subs
+}
+
+func (a *programAnalysis) MemoryAccesses() []*analysis.MemoryAccess {
+	a.memOnce.Do(func() {
+		a.mem = analysis.CollectMemoryAccesses(a.program)
+	})
+	return a.mem
+}
@@ -0,0 +1,69 @@
+package lsp
+
+import (
+	"testing"
+
+	"github.com/miselin/c64lsp/pkg/analysis"
+	"github.com/miselin/c64lsp/pkg/grammar"
+)
+
+// TestNextProgramAnalysis checks that the analyses of a program are handed on
+// to later versions, even past versions nothing analysed.
+func TestNextProgramAnalysis(t *testing.T) {
+	g := grammar.NewGrammar()
+	versions := []string{
+		"10 FOR I=1 TO 3:PRINT \"A\"\n20 NEXT J\n",
+		"10 FOR I=1 TO 3:PRINT \"AB\"\n20 NEXT J\n",
+		"10 FOR I=1 TO 3:PRINT \"ABC\"\n20 NEXT J\n",
+	}
+
+	var a *programAnalysis
+	var program *grammar.Program
+	for _, code := range versions {
+		var err error
+		if program, err = g.Reparse(program, "test.bas", code); err != nil {
+			t.Fatal(err)
+		}
+		a = nextProgramAnalysis(a, program)
+		if a.prevLoops == nil {
+			// only the first version is analysed
+			a.Loops()
+		}
+	}
+
+	if a.prevCFG == nil || a.prevLoops == nil {
+		t.Fatal("the analyses of the first version weren't handed on")
+	}
+	loops := a.Loops()
+	if loops.CFG != a.CFG() || len(loops.Problems) != 1 {
+		t.Fatalf("problems = %v, want one on the new graph", loops.Problems)
+	}
+	if p := loops.Problems[0]; p.Node.Line() != program.Lines[1] {
+		t.Errorf("problem is on line %v, want the last version's line 20", p.Node.Line())
+	}
+}
+
+// TestMemoryAccesses checks that requests running at the same time share one
+// scan of a program's memory accesses.
+func TestMemoryAccesses(t *testing.T) {
+	g := grammar.NewGrammar()
+	program, err := g.Parse("test.bas", "10 POKE 53280,0:X=PEEK(53281)\n20 SYS 64738\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := newProgramAnalysis(program)
+
+	found := make(chan []*analysis.MemoryAccess)
+	for i := 0; i < 4; i++ {
+		go func() { found <- a.MemoryAccesses() }()
+	}
+	first := <-found
+	if len(first) != 3 {
+		t.Fatalf("found %d memory accesses, want 3", len(first))
+	}
+	for i := 1; i < 4; i++ {
+		if other := <-found; &other[0] != &first[0] {
+			t.Error("memory accesses were scanned more than once")
+		}
+	}
+}
@@ -0,0 +1,308 @@
+package lsp
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/miselin/c64lsp/pkg/analysis"
+	"github.com/miselin/c64lsp/pkg/grammar"
+	"github.com/sourcegraph/jsonrpc2"
+)
+
+// Each subroutine is a function in the call hierarchy, named by its REM, with
+// the main program as one more function that calls them.
+
+// callHierarchyData identifies a call hierarchy item when the client sends it
+// back.
+type callHierarchyData struct {
+	// Label of the subroutine's line, unless Main is set.
+	Line int  `json:"line"`
+	Main bool `json:"main,omitempty"`
+}
+
+// callable is a subroutine, or the main program if sub is nil.
+type callable struct {
+	sub *analysis.Subroutine
+}
+
+func (c callable) body(subs *analysis.Subroutines) map[*analysis.Node]bool {
+	if c.sub == nil {
+		return subs.Main
+	}
+	return c.sub.Body
+}
+
+func (h *lspHandler) handleTextDocumentPrepareCallHierarchy(ctx context.Context, conn *jsonrpc2.Conn, req *jsonrpc2.Request) (result any, err error) {
+	if req.Params == nil {
+		return nil, &jsonrpc2.Error{Code: jsonrpc2.CodeInvalidParams}
+	}
+
+	var params CallHierarchyPrepareParams
+	if err := json.Unmarshal(*req.Params, &params); err != nil {
+		return nil, err
+	}
+
+	w := h.beginWorkDone(ctx, params.WorkDoneToken, "Preparing the call hierarchy", nil)
+	defer w.end(ctx, "")
+
+	return h.prepareCallHierarchy(ctx, params.TextDocument.URI, &params)
+}
+
+func (h *lspHandler) handleCallHierarchyIncomingCalls(ctx context.Context, conn *jsonrpc2.Conn, req *jsonrpc2.Request) (result any, err error) {
+	if req.Params == nil {
+		return nil, &jsonrpc2.Error{Code: jsonrpc2.CodeInvalidParams}
+	}
+
+	var params CallHierarchyIncomingCallsParams
+	if err := json.Unmarshal(*req.Params, &params); err != nil {
+		return nil, err
+	}
+
+	w := h.beginWorkDone(ctx, params.WorkDoneToken, "Finding incoming calls", nil)
+	defer w.end(ctx, "")
+
+	return h.incomingCalls(ctx, &params.Item)
+}
+
+func (h *lspHandler) handleCallHierarchyOutgoingCalls(ctx context.Context, conn *jsonrpc2.Conn, req *jsonrpc2.Request) (result any, err error) {
+	if req.Params == nil {
+		return nil, &jsonrpc2.Error{Code: jsonrpc2.CodeInvalidParams}
+	}
+
+	var params CallHierarchyOutgoingCallsParams
+	if err := json.Unmarshal(*req.Params, &params); err != nil {
+		return nil, err
+	}
+
+	w := h.beginWorkDone(ctx, params.WorkDoneToken, "Finding outgoing calls", nil)
+	defer w.end(ctx, "")
+
+	return h.outgoingCalls(ctx, &params.Item)
+}
+
+func (h *lspHandler) prepareCallHierarchy(ctx context.Context, uri DocumentURI, params *CallHierarchyPrepareParams) ([]CallHierarchyItem, error) {
+	snap, err := h.parsedSnapshot(uri)
+	if err != nil {
+		return nil, err
+	}
+	parsed := snap.program
+
+	line := parsed.FindTextLine(params.Position.Line)
+	if line == nil {
+		return nil, nil
+	}
+
+	subs := snap.analysis.Subroutines()
+	g := subs.CFG
+	items := []CallHierarchyItem{}
+
+	// on the target of a GOSUB
+	tok := tokenAt(parsed, params.Position)
+	for _, cmd := range analysis.LineCommands(line) {
+		for _, b := range cmd.Branches() {
+			if b.Token != tok || !b.IsCall() {
+				continue
+			}
+			if target := parsed.FindBasicLine(b.Target); target != nil {
+				if sub := subroutineAt(g, subs, target); sub != nil {
+					return append(items, callHierarchyItem(uri, parsed, subs, callable{sub})), nil
+				}
+			}
+		}
+	}
+
+	// on the first line of a subroutine
+	nodes := g.LineNodes(line)
+	if len(nodes) == 0 {
+		return items, nil
+	}
+	if sub := subs.Subroutine(nodes[0]); sub != nil {
+		return append(items, callHierarchyItem(uri, parsed, subs, callable{sub})), nil
+	}
+
+	// within subroutines or the main program
+	for _, sub := range subs.Containing(nodes[0]) {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		items = append(items, callHierarchyItem(uri, parsed, subs, callable{sub}))
+	}
+	if subs.Main[nodes[0]] {
+		items = append(items, callHierarchyItem(uri, parsed, subs, callable{}))
+	}
+	return items, nil
+}
+
+func (h *lspHandler) incomingCalls(ctx context.Context, item *CallHierarchyItem) ([]CallHierarchyIncomingCall, error) {
+	parsed, subs, target, err := h.resolveCallHierarchyItem(item)
+	if err != nil || target.sub == nil {
+		// nothing calls the main program
+		return nil, err
+	}
+
+	calls := []CallHierarchyIncomingCall{}
+	callers := append([]callable{{}}, subroutineCallables(subs)...)
+	for _, caller := range callers {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		body := caller.body(subs)
+		ranges := []Range{}
+		for _, e := range target.sub.Calls {
+			if body[e.From] {
+				ranges = append(ranges, callRanges(parsed, e)...)
+			}
+		}
+		if len(ranges) > 0 {
+			calls = append(calls, CallHierarchyIncomingCall{
+				From:       callHierarchyItem(item.URI, parsed, subs, caller),
+				FromRanges: ranges,
+			})
+		}
+	}
+	return calls, nil
+}
+
+func (h *lspHandler) outgoingCalls(ctx context.Context, item *CallHierarchyItem) ([]CallHierarchyOutgoingCall, error) {
+	parsed, subs, caller, err := h.resolveCallHierarchyItem(item)
+	if err != nil {
+		return nil, err
+	}
+
+	body := caller.body(subs)
+	calls := []CallHierarchyOutgoingCall{}
+	for _, callee := range subroutineCallables(subs) {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		ranges := []Range{}
+		for _, e := range callee.sub.Calls {
+			if body[e.From] {
+				ranges = append(ranges, callRanges(parsed, e)...)
+			}
+		}
+		if len(ranges) > 0 {
+			calls = append(calls, CallHierarchyOutgoingCall{
+				To:         callHierarchyItem(item.URI, parsed, subs, callee),
+				FromRanges: ranges,
+			})
+		}
+	}
+	return calls, nil
+}
+
+// resolveCallHierarchyItem finds the subroutine for an item made by
+// callHierarchyItem.
+func (h *lspHandler) resolveCallHierarchyItem(item *CallHierarchyItem) (*grammar.Program, *analysis.Subroutines, callable, error) {
+	snap, err := h.parsedSnapshot(item.URI)
+	if err != nil {
+		return nil, nil, callable{}, err
+	}
+	parsed := snap.program
+
+	var data callHierarchyData
+	raw, err := json.Marshal(item.Data)
+	if err == nil {
+		err = json.Unmarshal(raw, &data)
+	}
+	if err != nil {
+		return nil, nil, callable{}, &jsonrpc2.Error{Code: jsonrpc2.CodeInvalidParams, Message: fmt.Sprintf("call hierarchy item data: %v", err)}
+	}
+
+	subs := snap.analysis.Subroutines()
+	g := subs.CFG
+	if data.Main {
+		return parsed, subs, callable{}, nil
+	}
+
+	// the document may have changed since the item was made
+	line := parsed.FindBasicLine(data.Line)
+	if line == nil {
+		return nil, nil, callable{}, fmt.Errorf("line %d no longer exists", data.Line)
+	}
+	sub := subroutineAt(g, subs, line)
+	if sub == nil {
+		return nil, nil, callable{}, fmt.Errorf("line %d is no longer a subroutine", data.Line)
+	}
+	return parsed, subs, callable{sub}, nil
+}
+
+// subroutineAt returns the subroutine starting at a line, or nil.
+func subroutineAt(g *analysis.CFG, subs *analysis.Subroutines, line *grammar.BasicLine) *analysis.Subroutine {
+	nodes := g.LineNodes(line)
+	if len(nodes) == 0 {
+		return nil
+	}
+	return subs.Subroutine(nodes[0])
+}
+
+func subroutineCallables(subs *analysis.Subroutines) []callable {
+	callables := []callable{}
+	for _, sub := range subs.All {
+		callables = append(callables, callable{sub})
+	}
+	return callables
+}
+
+// callHierarchyItem describes a subroutine or the main program. Its range
+// covers every line of its body.
+func callHierarchyItem(uri DocumentURI, parsed *grammar.Program, subs *analysis.Subroutines, c callable) CallHierarchyItem {
+	first, last := -1, -1
+	for i, line := range parsed.Lines {
+		for _, n := range subs.CFG.LineNodes(line) {
+			if c.body(subs)[n] {
+				if first < 0 {
+					first = i
+				}
+				last = i
+			}
+		}
+	}
+
+	item := CallHierarchyItem{Kind: FunctionSymbol, URI: uri}
+	entry := parsed.Lines[max(first, 0)]
+	if c.sub == nil {
+		item.Name = "main program"
+		item.Data = callHierarchyData{Main: true}
+	} else {
+		entry = c.sub.Line
+		item.Name, item.Detail = subroutineName(parsed, c.sub)
+		item.Data = callHierarchyData{Line: entry.Label}
+	}
+
+	item.SelectionRange = labelRange(parsed, entry)
+	item.Range = lineRange(parsed, entry)
+	if first >= 0 {
+		item.Range = linesRange(parsed, first, last)
+	}
+	if before(item.SelectionRange.Start, item.Range.Start) {
+		item.Range.Start = item.SelectionRange.Start
+	}
+	if before(item.Range.End, item.SelectionRange.End) {
+		item.Range.End = item.SelectionRange.End
+	}
+	return item
+}
+
+// subroutineName names a subroutine by its REM, with its GOSUB as the
+// detail, or by its GOSUB alone.
+func subroutineName(parsed *grammar.Program, sub *analysis.Subroutine) (name, detail string) {
+	detail = fmt.Sprintf("GOSUB %d", sub.Line.Label)
+	if name = analysis.LineName(parsed, sub.Line); name == "" {
+		return detail, ""
+	}
+	return name, detail
+}
+
+// callRanges returns the ranges of the line numbers a GOSUB edge was made
+// from.
+func callRanges(parsed *grammar.Program, e *analysis.Edge) []Range {
+	ranges := []Range{}
+	for _, b := range e.From.Command.Branches() {
+		if b.IsCall() && parsed.FindBasicLine(b.Target) == e.To.Line() {
+			ranges = append(ranges, tokenRange(parsed, b.Token))
+		}
+	}
+	return ranges
+}
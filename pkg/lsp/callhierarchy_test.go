@@ -0,0 +1,100 @@
+package lsp
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestCallHierarchy(t *testing.T) {
+	ctx := context.Background()
+	h, uri := openDocument(t, `10 GOSUB 100:GOSUB 200
+20 END
+90 REM DRAW
+100 GOSUB 200
+110 RETURN
+200 PRINT
+210 RETURN
+`)
+
+	tests := []struct {
+		name     string
+		position Position
+		want     string
+		// callers and callees, with the ranges of their GOSUB line numbers
+		incoming []string
+		outgoing []string
+	}{
+		{"GOSUB line number", Position{Line: 0, Character: 10}, "DRAW", []string{"main program@0:9-0:12"}, []string{"GOSUB 200@3:10-3:13"}},
+		{"subroutine line", Position{Line: 3, Character: 0}, "DRAW", []string{"main program@0:9-0:12"}, []string{"GOSUB 200@3:10-3:13"}},
+		{"unnamed subroutine", Position{Line: 6, Character: 5}, "GOSUB 200", []string{
+			"main program@0:19-0:22",
+			"DRAW@3:10-3:13",
+		}, []string{}},
+		{"main program", Position{Line: 1, Character: 0}, "main program", nil, []string{
+			"DRAW@0:9-0:12",
+			"GOSUB 200@0:19-0:22",
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			items, err := h.prepareCallHierarchy(ctx, uri, &CallHierarchyPrepareParams{
+				TextDocumentPositionParams: TextDocumentPositionParams{TextDocument: TextDocumentIdentifier{URI: uri}, Position: tt.position},
+			})
+			if err != nil || len(items) != 1 || items[0].Name != tt.want {
+				t.Fatalf("items = %v, %v, want %s", items, err, tt.want)
+			}
+
+			// as the client sends it back
+			raw, err := json.Marshal(items[0])
+			if err != nil {
+				t.Fatal(err)
+			}
+			var item CallHierarchyItem
+			if err := json.Unmarshal(raw, &item); err != nil {
+				t.Fatal(err)
+			}
+
+			in, err := h.incomingCalls(ctx, &item)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var incoming []string
+			for _, call := range in {
+				incoming = append(incoming, describeCall(call.From, call.FromRanges))
+			}
+			if !reflect.DeepEqual(incoming, tt.incoming) {
+				t.Errorf("incoming = %q, want %q", incoming, tt.incoming)
+			}
+
+			out, err := h.outgoingCalls(ctx, &item)
+			if err != nil {
+				t.Fatal(err)
+			}
+			outgoing := []string{}
+			for _, call := range out {
+				outgoing = append(outgoing, describeCall(call.To, call.FromRanges))
+			}
+			if !reflect.DeepEqual(outgoing, tt.outgoing) {
+				t.Errorf("outgoing = %q, want %q", outgoing, tt.outgoing)
+			}
+		})
+	}
+}
+
+// describeCall describes a call by the item at the other end and where the
+// calls are made.
+func describeCall(item CallHierarchyItem, ranges []Range) string {
+	s := item.Name
+	for i, r := range ranges {
+		sep := ","
+		if i == 0 {
+			sep = "@"
+		}
+		s += fmt.Sprintf("%s%d:%d-%d:%d", sep, r.Start.Line, r.Start.Character, r.End.Line, r.End.Character)
+	}
+	return s
+}
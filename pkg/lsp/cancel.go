@@ -0,0 +1,48 @@
+package lsp
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/sourcegraph/jsonrpc2"
+)
+
+// startRequest remembers how to cancel a request until finishRequest.
+func (h *lspHandler) startRequest(id jsonrpc2.ID, cancel context.CancelFunc) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.requests[id] = cancel
+}
+
+func (h *lspHandler) finishRequest(id jsonrpc2.ID) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if cancel, ok := h.requests[id]; ok {
+		cancel()
+		delete(h.requests, id)
+	}
+}
+
+// handleCancelRequest cancels the context of a request that is still running.
+// The request answers with RequestCancelled once its handler returns.
+func (h *lspHandler) handleCancelRequest(ctx context.Context, conn *jsonrpc2.Conn, req *jsonrpc2.Request) (result any, err error) {
+	if req.Params == nil {
+		return nil, &jsonrpc2.Error{Code: jsonrpc2.CodeInvalidParams}
+	}
+
+	var params CancelParams
+	if err := json.Unmarshal(*req.Params, &params); err != nil {
+		return nil, err
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	// it may have finished already
+	if cancel, ok := h.requests[params.ID]; ok {
+		cancel()
+	}
+	return nil, nil
+}
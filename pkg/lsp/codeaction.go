@@ -0,0 +1,185 @@
+package lsp
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/miselin/c64lsp/pkg/analysis"
+	"github.com/miselin/c64lsp/pkg/grammar"
+	"github.com/miselin/c64lsp/pkg/reference"
+	"github.com/sourcegraph/jsonrpc2"
+)
+
+func (h *lspHandler) handleTextDocumentCodeAction(ctx context.Context, conn *jsonrpc2.Conn, req *jsonrpc2.Request) (result any, err error) {
+	if req.Params == nil {
+		return nil, &jsonrpc2.Error{Code: jsonrpc2.CodeInvalidParams}
+	}
+
+	var params CodeActionParams
+	if err := json.Unmarshal(*req.Params, &params); err != nil {
+		return nil, err
+	}
+
+	return h.codeActions(ctx, params.TextDocument.URI, &params)
+}
+
+func (h *lspHandler) codeActions(ctx context.Context, uri DocumentURI, params *CodeActionParams) ([]CodeAction, error) {
+	snap, ok := h.snapshot(uri)
+	if !ok {
+		return nil, fmt.Errorf("code action but no file")
+	}
+	keywordCase := h.currentSettings().KeywordCase
+
+	actions := []CodeAction{}
+
+	// the source actions work on the text, so don't need it to parse
+	if wantKind(params.Context.Only, SourceAction) {
+		actions = append(actions, sourceActions(snap)...)
+	}
+	if !wantKind(params.Context.Only, RefactorRewrite) {
+		return actions, nil
+	}
+	// but rewrites of an earlier parse would land in the wrong place
+	if snap.program == nil || snap.parseErr != nil {
+		return actions, nil
+	}
+	parsed := snap.program
+
+	add := func(title string, r Range, text string) {
+		actions = append(actions, CodeAction{
+			Title: title,
+			Kind:  RefactorRewrite,
+			Edit: &WorkspaceEdit{
+				Changes: map[DocumentURI][]TextEdit{uri: {{Range: r, NewText: text}}},
+			},
+		})
+	}
+
+	for _, run := range analysis.CollectStringRuns(parsed) {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		first, last := run.Tokens()
+		r := Range{Start: tokenRange(parsed, first).Start, End: tokenRange(parsed, last).End}
+		if !overlaps(r, params.Range) {
+			continue
+		}
+
+		literal := "\"" + reference.EncodeString(run.Bytes(), runStyle(parsed, run)) + "\""
+		if len(run.Terms) > 1 {
+			add("Merge into a single string literal", r, literal)
+		} else if run.Terms[0].Call != nil {
+			start, _ := parsed.TokenSpan(first)
+			_, end := parsed.TokenSpan(last)
+			add(fmt.Sprintf("Replace %s with %s", parsed.Text(start, end), literal), r, literal)
+		}
+
+		for _, term := range run.Terms {
+			if term.Literal == nil || !term.Literal.HasMnemonics() {
+				continue
+			}
+			tr := tokenRange(parsed, term.Literal.Token)
+			if !overlaps(tr, params.Range) {
+				continue
+			}
+
+			add("Convert mnemonics to CHR$()", tr, chrExpression(parsed, run, term.Literal, keywordCase))
+
+			contents, _ := parsed.StringContents(term.Literal.Token)
+			for _, style := range []reference.MnemonicStyle{reference.PetcatStyle, reference.PrgStudioStyle} {
+				if restyled := term.Literal.Restyle(style); restyled != contents {
+					add(fmt.Sprintf("Convert mnemonics to %s style", style), tr, "\""+restyled+"\"")
+				}
+			}
+		}
+	}
+
+	return actions, nil
+}
+
+// sourceActions offers conversions of the whole document between Unicode
+// graphics and mnemonics, where they would change anything.
+func sourceActions(snap *snapshot) []CodeAction {
+	conversions := []struct {
+		title string
+		edits []TextEdit
+	}{
+		{"Convert Unicode graphics to mnemonics", convertToMnemonics(snap.Text)},
+		{"Convert mnemonics to Unicode block graphics", convertToUnicode(snap.Text, reference.UnicodeBlocks)},
+		{"Convert mnemonics to C64 Pro Mono graphics", convertToUnicode(snap.Text, reference.C64ProMono)},
+	}
+
+	actions := []CodeAction{}
+	for _, c := range conversions {
+		if len(c.edits) == 0 {
+			continue
+		}
+		actions = append(actions, CodeAction{
+			Title: c.title,
+			Kind:  SourceAction,
+			Edit:  &WorkspaceEdit{Changes: map[DocumentURI][]TextEdit{snap.uri: c.edits}},
+		})
+	}
+	return actions
+}
+
+// chrExpression rewrites a string literal as a concatenation of plain strings
+// and CHR$ calls, one for each mnemonic character. keywordCase is the
+// keywordCase setting.
+func chrExpression(parsed *grammar.Program, run *analysis.StringRun, lit *analysis.StringLiteral, keywordCase string) string {
+	sep := "+"
+	if run.TopLevel && strings.HasPrefix(run.Command.Keyword, "PRINT") {
+		sep = ";"
+	}
+
+	chr := "CHR$"
+	switch keywordCase {
+	case "lower":
+		chr = "chr$"
+	case "preserve":
+		if text := parsed.TokenText(run.Command.Statement.Tokens[0]); text != strings.ToUpper(text) {
+			// follow a lower case listing
+			chr = "chr$"
+		}
+	}
+
+	pieces := []string{}
+	for _, part := range lit.Parts {
+		if !part.IsMnemonic {
+			pieces = append(pieces, "\""+part.Text+"\"")
+			continue
+		}
+		for _, b := range part.Bytes {
+			pieces = append(pieces, fmt.Sprintf("%s(%d)", chr, b))
+		}
+	}
+	return strings.Join(pieces, sep)
+}
+
+// runStyle picks the mnemonic style already used by literals in a run,
+// defaulting to petcat.
+func runStyle(parsed *grammar.Program, run *analysis.StringRun) reference.MnemonicStyle {
+	for _, term := range run.Terms {
+		if term.Literal == nil || !term.Literal.HasMnemonics() {
+			continue
+		}
+		contents, _ := parsed.StringContents(term.Literal.Token)
+		if term.Literal.Restyle(reference.PetcatStyle) != contents && term.Literal.Restyle(reference.PrgStudioStyle) == contents {
+			return reference.PrgStudioStyle
+		}
+	}
+	return reference.PetcatStyle
+}
+
+// wantKind checks if a code action kind was requested by the client.
+func wantKind(only []CodeActionKind, kind CodeActionKind) bool {
+	if len(only) == 0 {
+		return true
+	}
+	return slices.ContainsFunc(only, func(k CodeActionKind) bool {
+		return k == kind || strings.HasPrefix(string(kind), string(k)+".")
+	})
+}
@@ -0,0 +1,101 @@
+package lsp
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/miselin/c64lsp/pkg/reference"
+	"github.com/rs/zerolog"
+	"github.com/sourcegraph/jsonrpc2"
+)
+
+// Commands take the document URI as their first argument.
+const (
+	// convertToMnemonicsCommand rewrites Unicode graphics in a document as mnemonics.
+	convertToMnemonicsCommand = "c64lsp.convertToMnemonics"
+	// convertToUnicodeCommand rewrites mnemonics in a document as Unicode
+	// graphics. An optional second argument of "c64pro" uses the C64 Pro Mono
+	// private use area rather than block and box-drawing characters.
+	convertToUnicodeCommand = "c64lsp.convertToUnicode"
+	// controlFlowGraphCommand returns the control-flow graph of a document in
+	// the Graphviz DOT language.
+	controlFlowGraphCommand = "c64lsp.controlFlowGraph"
+)
+
+var commands = []string{convertToMnemonicsCommand, convertToUnicodeCommand, controlFlowGraphCommand}
+
+func (h *lspHandler) handleWorkspaceExecuteCommand(ctx context.Context, conn *jsonrpc2.Conn, req *jsonrpc2.Request) (result any, err error) {
+	if req.Params == nil {
+		return nil, &jsonrpc2.Error{Code: jsonrpc2.CodeInvalidParams}
+	}
+
+	var params ExecuteCommandParams
+	if err := json.Unmarshal(*req.Params, &params); err != nil {
+		return nil, err
+	}
+
+	args := make([]string, len(params.Arguments))
+	for i, raw := range params.Arguments {
+		if err := json.Unmarshal(raw, &args[i]); err != nil {
+			return nil, &jsonrpc2.Error{Code: jsonrpc2.CodeInvalidParams, Message: fmt.Sprintf("argument %d: %v", i, err)}
+		}
+	}
+	if len(args) < 1 {
+		return nil, &jsonrpc2.Error{Code: jsonrpc2.CodeInvalidParams, Message: "missing document URI"}
+	}
+
+	w := h.beginWorkDone(ctx, params.WorkDoneToken, fmt.Sprintf("Running %s", params.Command), nil)
+	defer w.end(ctx, "")
+
+	return h.executeCommand(ctx, conn, params.Command, DocumentURI(args[0]), args[1:])
+}
+
+func (h *lspHandler) executeCommand(ctx context.Context, conn *jsonrpc2.Conn, command string, uri DocumentURI, args []string) (any, error) {
+	snap, ok := h.snapshot(uri)
+	if !ok {
+		return nil, fmt.Errorf("document not found: %v", uri)
+	}
+
+	switch command {
+	case convertToMnemonicsCommand:
+		h.applyEdit(ctx, conn, "Convert Unicode graphics to mnemonics", uri, convertToMnemonics(snap.Text))
+	case convertToUnicodeCommand:
+		set := reference.UnicodeBlocks
+		if len(args) > 0 && args[0] == "c64pro" {
+			set = reference.C64ProMono
+		}
+		h.applyEdit(ctx, conn, "Convert mnemonics to Unicode graphics", uri, convertToUnicode(snap.Text, set))
+	case controlFlowGraphCommand:
+		snap, err := h.parsedSnapshot(uri)
+		if err != nil {
+			return nil, err
+		}
+		return snap.analysis.CFG().DOT(), nil
+	default:
+		return nil, &jsonrpc2.Error{Code: jsonrpc2.CodeInvalidParams, Message: fmt.Sprintf("unknown command: %s", command)}
+	}
+
+	return nil, nil
+}
+
+// applyEdit asks the client to apply edits to a document.
+func (h *lspHandler) applyEdit(ctx context.Context, conn *jsonrpc2.Conn, label string, uri DocumentURI, edits []TextEdit) {
+	if len(edits) == 0 || conn == nil {
+		return
+	}
+
+	logger := zerolog.Ctx(ctx)
+	edit := ApplyWorkspaceEditParams{Label: label, Edit: WorkspaceEdit{Changes: map[DocumentURI][]TextEdit{uri: edits}}}
+
+	// the command is done once the edit is sent, so don't wait for the
+	// client to answer
+	go func() {
+		var result ApplyWorkspaceEditResult
+		if err := conn.Call(context.Background(), "workspace/applyEdit", edit, &result); err != nil {
+			logger.Error().Err(err).Msg("workspace/applyEdit")
+		} else if !result.Applied {
+			logger.Warn().Msgf("edit not applied: %s", result.FailureReason)
+		}
+	}()
+}
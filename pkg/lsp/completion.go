@@ -4,7 +4,11 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"sort"
+	"strconv"
+	"strings"
 
+	"github.com/miselin/c64lsp/pkg/reference"
 	"github.com/rs/zerolog"
 	"github.com/sourcegraph/jsonrpc2"
 )
@@ -27,9 +31,141 @@ func (h *lspHandler) completion(ctx context.Context, uri DocumentURI, params *Co
 
 	logger.Info().Msgf("completion request: %#v", params)
 
-	return nil, fmt.Errorf("completion not implemented")
+	snap, ok := h.snapshot(uri)
+	if !ok {
+		return nil, fmt.Errorf("completion but no file")
+	}
+
+	// use the text rather than the parse, which is likely broken mid-edit
+	lines := strings.Split(snap.Text, "\n")
+	if params.Position.Line >= len(lines) {
+		return nil, nil
+	}
+	text := strings.TrimRight(lines[params.Position.Line], "\r")
+	if params.Position.Character > utf16Len(text) {
+		return nil, nil
+	}
+	line := []rune(text)
+	cursor := runeColumn(text, params.Position.Character)
+
+	if start, ok := openMnemonic(line[:cursor]); ok {
+		closed := cursor < len(line) && strings.ContainsRune(string(line[cursor:]), '}')
+		return mnemonicCompletions(Range{
+			Start: Position{Line: params.Position.Line, Character: utf16Column(text, start)},
+			End:   params.Position,
+		}, closed), nil
+	}
+
+	if strings.Trim(text, " \t0123456789") == "" {
+		return lineNumberCompletions(lines, params.Position, h.currentSettings().LineNumbers.Increment), nil
+	}
+
+	return nil, nil
+}
+
+// lineNumberCompletions suggests a number for the empty line at the cursor,
+// the previous line's number plus the increment, if that still comes before
+// the next line.
+func lineNumberCompletions(lines []string, pos Position, increment int) []CompletionItem {
+	number := func(line string) (int, bool) {
+		digits := strings.TrimLeft(line, " \t")
+		end := strings.IndexFunc(digits, func(r rune) bool { return r < '0' || r > '9' })
+		if end < 0 {
+			end = len(digits)
+		}
+		n, err := strconv.Atoi(digits[:end])
+		return n, err == nil
+	}
+
+	next := 0
+	for i := pos.Line - 1; i >= 0; i-- {
+		if n, ok := number(lines[i]); ok {
+			next = n + increment
+			break
+		}
+	}
+	for i := pos.Line + 1; i < len(lines); i++ {
+		if n, ok := number(lines[i]); ok {
+			if next >= n {
+				return nil
+			}
+			break
+		}
+	}
+	if next == 0 || next > 63999 {
+		return nil
+	}
+
+	text := strings.TrimRight(lines[pos.Line], "\r")
+	label := strconv.Itoa(next)
+	return []CompletionItem{{
+		Label:  label,
+		Kind:   ValueCompletion,
+		Detail: fmt.Sprintf("next line number, in steps of %d", increment),
+		TextEdit: &TextEdit{
+			Range:   Range{Start: Position{Line: pos.Line}, End: Position{Line: pos.Line, Character: utf16Len(text)}},
+			NewText: label + " ",
+		},
+	}}
+}
+
+// openMnemonic checks if the text before the cursor ends inside a string, in
+// an unclosed brace, and returns the position just after the brace.
+func openMnemonic(prefix []rune) (int, bool) {
+	inString := false
+	brace := -1
+	for i, r := range prefix {
+		switch {
+		case r == '"':
+			inString = !inString
+			brace = -1
+		case !inString:
+			// everything after REM is a comment, not a string
+			if i >= 2 && strings.EqualFold(string(prefix[i-2:i+1]), "rem") {
+				return 0, false
+			}
+		case r == '{':
+			brace = i + 1
+		case r == '}':
+			brace = -1
+		}
+	}
+	return brace, inString && brace >= 0
 }
 
-/**
-completion request: &lsp.CompletionParams{TextDocumentPositionParams:lsp.TextDocumentPositionParams{TextDocument:lsp.TextDocumentIdentifier{URI:"file:///g%3A/Code/c64lsp/examples/maze.bas"}, Position:lsp.Position{Line:8, Character:4}}, CompletionContext:lsp.CompletionContext{TriggerKind:0, TriggerCharacter:(*string)(nil)}}
-**/
+// mnemonicCompletions lists every known PETSCII mnemonic, replacing the text
+// typed so far after the opening brace.
+func mnemonicCompletions(replace Range, closed bool) []CompletionItem {
+	codes := append(append([]reference.PetsciiCode{}, reference.PetsciiControlCodes...), reference.PetsciiGraphics...)
+	sort.SliceStable(codes, func(i, j int) bool { return codes[i].Code < codes[j].Code })
+
+	items := []CompletionItem{}
+	for i := range codes {
+		pc := &codes[i]
+
+		detail := fmt.Sprintf("$%02X (%d)", pc.Code, pc.Code)
+		if glyph, reversed := reference.QuoteModeGlyph(pc.Code); glyph != "" {
+			if reversed {
+				detail += fmt.Sprintf(" reversed %s", glyph)
+			} else {
+				detail += " " + glyph
+			}
+		}
+
+		text := pc.Mnemonic
+		if !closed {
+			text += "}"
+		}
+
+		items = append(items, CompletionItem{
+			Label:         pc.Mnemonic,
+			Kind:          ConstantCompletion,
+			Detail:        detail,
+			Documentation: MarkupContent{Kind: Markdown, Value: pc.Markdown()},
+			SortText:      fmt.Sprintf("%03d", pc.Code),
+			FilterText:    strings.Join(append([]string{pc.Mnemonic}, pc.Aliases...), " "),
+			TextEdit:      &TextEdit{Range: replace, NewText: text},
+		})
+	}
+	return items
+}
@@ -0,0 +1,285 @@
+package lsp
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/miselin/c64lsp/pkg/reference"
+	"github.com/rs/zerolog"
+	"github.com/sourcegraph/jsonrpc2"
+)
+
+// configFileName is the name of the settings file in a workspace.
+const configFileName = ".c64lsp.json"
+
+// settings are the user-configurable options for the server. They come from
+// the client, as initializationOptions and then the "c64lsp" section of its
+// configuration, and from a .c64lsp.json in the workspace, which takes
+// precedence.
+type settings struct {
+	// BASIC dialect of the programs. Only "v2", Commodore BASIC V2, so far.
+	Dialect string `json:"dialect"`
+	// Case of the keywords the server writes, e.g. in code actions:
+	// "preserve" to follow the statement being changed, "upper" or "lower".
+	KeywordCase string             `json:"keywordCase"`
+	Diagnostics diagnosticSettings `json:"diagnostics"`
+	InlayHints  inlayHintSettings  `json:"inlayHints"`
+	LineNumbers lineNumberSettings `json:"lineNumbers"`
+	Memory      memorySettings     `json:"memory"`
+}
+
+// diagnosticSettings turns diagnostics on and off and changes their severity.
+// Diagnostics about the document being out of sync are always reported.
+type diagnosticSettings struct {
+	Enabled bool `json:"enabled"`
+	// Severity by diagnostic code, e.g. "unreachable": "error", "warning",
+	// "information", "hint", or "off" to turn it off.
+	Severity map[string]string `json:"severity"`
+}
+
+// inlayHintSettings toggles each category of inlay hint.
+type inlayHintSettings struct {
+	// Register names for POKE addresses.
+	Poke bool `json:"poke"`
+	// Decoded values stored by POKE.
+	PokeValue bool `json:"pokeValue"`
+	// Register names for PEEK addresses.
+	Peek bool `json:"peek"`
+	// Routine names for SYS addresses.
+	Sys bool `json:"sys"`
+	// Characters produced by CHR$.
+	Chr bool `json:"chr"`
+}
+
+// lineNumberSettings are for numbering new lines, e.g. when completing one.
+type lineNumberSettings struct {
+	// Step from one line number to the next.
+	Increment int `json:"increment"`
+}
+
+// memorySettings describe how a program sets up the C64's memory.
+type memorySettings struct {
+	// Address of screen RAM, for programs that move it. A multiple of 1024.
+	Screen int `json:"screen"`
+}
+
+var severities = map[string]DiagnosticSeverity{
+	"error":       SeverityError,
+	"warning":     SeverityWarning,
+	"information": SeverityInformation,
+	"hint":        SeverityHint,
+}
+
+func defaultSettings() settings {
+	return settings{
+		Dialect:     "v2",
+		KeywordCase: "preserve",
+		Diagnostics: diagnosticSettings{
+			Enabled:  true,
+			Severity: map[string]string{},
+		},
+		InlayHints: inlayHintSettings{
+			Poke:      true,
+			PokeValue: true,
+			Peek:      true,
+			Sys:       true,
+			Chr:       true,
+		},
+		LineNumbers: lineNumberSettings{
+			Increment: 10,
+		},
+		Memory: memorySettings{
+			Screen: reference.DefaultScreen,
+		},
+	}
+}
+
+// check puts any invalid settings back to their defaults, and describes what
+// was wrong with them.
+func (s *settings) check() []string {
+	problems := []string{}
+	defaults := defaultSettings()
+
+	if s.Dialect != "v2" {
+		problems = append(problems, fmt.Sprintf("dialect %q isn't supported, only \"v2\"", s.Dialect))
+		s.Dialect = defaults.Dialect
+	}
+	switch s.KeywordCase {
+	case "preserve", "upper", "lower":
+	default:
+		problems = append(problems, fmt.Sprintf("keywordCase must be \"preserve\", \"upper\" or \"lower\", not %q", s.KeywordCase))
+		s.KeywordCase = defaults.KeywordCase
+	}
+	for code, severity := range s.Diagnostics.Severity {
+		if _, ok := severities[severity]; !ok && severity != "off" {
+			problems = append(problems, fmt.Sprintf("diagnostics.severity for %s must be \"error\", \"warning\", \"information\", \"hint\" or \"off\", not %q", code, severity))
+			delete(s.Diagnostics.Severity, code)
+		}
+	}
+	if s.LineNumbers.Increment < 1 {
+		problems = append(problems, fmt.Sprintf("lineNumbers.increment must be at least 1, not %d", s.LineNumbers.Increment))
+		s.LineNumbers.Increment = defaults.LineNumbers.Increment
+	}
+	if s.Memory.Screen < 0 || s.Memory.Screen > 0xFC00 || s.Memory.Screen%0x400 != 0 {
+		problems = append(problems, fmt.Sprintf("memory.screen must be a multiple of 1024 up to 64512, not %d", s.Memory.Screen))
+		s.Memory.Screen = defaults.Memory.Screen
+	}
+
+	return problems
+}
+
+// apply drops the diagnostics that are turned off, and changes the severity
+// of others.
+func (s diagnosticSettings) apply(diags []Diagnostic) []Diagnostic {
+	if !s.Enabled {
+		return []Diagnostic{}
+	}
+
+	kept := []Diagnostic{}
+	for _, diag := range diags {
+		severity, ok := s.Severity[diag.Code]
+		if severity == "off" {
+			continue
+		}
+		if ok {
+			diag.Severity = severities[severity]
+		}
+		kept = append(kept, diag)
+	}
+	return kept
+}
+
+func (h *lspHandler) handleWorkspaceDidChangeConfiguration(ctx context.Context, conn *jsonrpc2.Conn, req *jsonrpc2.Request) (result any, err error) {
+	if req.Params == nil {
+		return nil, &jsonrpc2.Error{Code: jsonrpc2.CodeInvalidParams}
+	}
+
+	var params DidChangeConfigurationParams
+	if err := json.Unmarshal(*req.Params, &params); err != nil {
+		return nil, err
+	}
+
+	// settings arrive as an arbitrary object, round-trip it into our section
+	raw, err := json.Marshal(params.Settings)
+	if err != nil {
+		return nil, err
+	}
+
+	var section struct {
+		C64LSP json.RawMessage `json:"c64lsp"`
+	}
+	if err := json.Unmarshal(raw, &section); err != nil {
+		return nil, err
+	}
+	if section.C64LSP == nil {
+		return nil, nil
+	}
+
+	h.clientSettings = section.C64LSP
+	h.reloadSettings(ctx)
+	return nil, nil
+}
+
+func (h *lspHandler) handleWorkspaceDidChangeWatchedFiles(ctx context.Context, conn *jsonrpc2.Conn, req *jsonrpc2.Request) (result any, err error) {
+	if req.Params == nil {
+		return nil, &jsonrpc2.Error{Code: jsonrpc2.CodeInvalidParams}
+	}
+
+	var params DidChangeWatchedFilesParams
+	if err := json.Unmarshal(*req.Params, &params); err != nil {
+		return nil, err
+	}
+
+	reload := false
+	for _, change := range params.Changes {
+		path, err := fromURI(change.URI)
+		if err != nil {
+			continue
+		}
+		switch {
+		case filepath.Base(path) == configFileName:
+			reload = true
+		case isBasicFile(path):
+			// an open document is indexed from its unsaved text instead
+			if _, ok := h.snapshot(change.URI); ok {
+				continue
+			}
+			if change.Type == FileDeleted {
+				h.index.remove(change.URI)
+			} else {
+				h.reindexFile(ctx, change.URI)
+			}
+		}
+	}
+	if reload {
+		h.reloadSettings(ctx)
+	}
+	return nil, nil
+}
+
+// reloadSettings starts from the defaults, then applies the client's
+// settings and then those in the workspace's .c64lsp.json. Invalid settings
+// keep their defaults, and the client is told what was wrong with them.
+// Diagnostics are sent again, as the settings may have changed them.
+func (h *lspHandler) reloadSettings(ctx context.Context) {
+	logger := zerolog.Ctx(ctx)
+
+	s := defaultSettings()
+	problems := []string{}
+	if len(h.clientSettings) > 0 && string(h.clientSettings) != "null" {
+		if err := json.Unmarshal(h.clientSettings, &s); err != nil {
+			problems = append(problems, fmt.Sprintf("client settings: %v", err))
+		}
+	}
+	if dir := matchRootPath(filepath.Join(h.rootPath, configFileName), []string{configFileName}); dir != "" {
+		path := filepath.Join(dir, configFileName)
+		data, err := os.ReadFile(path)
+		if err == nil {
+			err = json.Unmarshal(data, &s)
+		}
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("%s: %v", path, err))
+		}
+	}
+	problems = append(problems, s.check()...)
+
+	h.mu.Lock()
+	h.settings = s
+	snapshots := []*snapshot{}
+	for _, snap := range h.snapshots {
+		snapshots = append(snapshots, snap)
+	}
+	h.mu.Unlock()
+
+	logger.Debug().Msgf("configuration changed: %#v", s)
+	for _, problem := range problems {
+		logger.Warn().Msgf("settings: %s", problem)
+		h.showMessage(ctx, MessageWarning, "c64lsp settings: "+problem)
+	}
+
+	for _, snap := range snapshots {
+		h.startDiagnostics(ctx, snap.uri, snap)
+	}
+}
+
+// currentSettings returns the settings as they are now, for a request to use
+// throughout.
+func (h *lspHandler) currentSettings() settings {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	return h.settings
+}
+
+// showMessage shows a message to the user.
+func (h *lspHandler) showMessage(ctx context.Context, kind MessageType, message string) {
+	if h.conn == nil {
+		return
+	}
+	if err := h.conn.Notify(ctx, "window/showMessage", ShowMessageParams{Type: kind, Message: message}); err != nil {
+		zerolog.Ctx(ctx).Error().Err(err).Msg("window/showMessage")
+	}
+}
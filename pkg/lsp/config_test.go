@@ -0,0 +1,84 @@
+package lsp
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+func TestCheckSettings(t *testing.T) {
+	tests := []struct {
+		name     string
+		change   func(s *settings)
+		problems int
+	}{
+		{"defaults", func(s *settings) {}, 0},
+		{"unknown dialect", func(s *settings) { s.Dialect = "v7" }, 1},
+		{"zero increment", func(s *settings) { s.LineNumbers.Increment = 0 }, 1},
+		{"negative increment", func(s *settings) { s.LineNumbers.Increment = -10 }, 1},
+		{"keyword case", func(s *settings) { s.KeywordCase = "title" }, 1},
+		{"screen", func(s *settings) { s.Memory.Screen = 1000 }, 1},
+		{"several", func(s *settings) { s.Dialect = ""; s.LineNumbers.Increment = 0 }, 2},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s := defaultSettings()
+			test.change(&s)
+			if problems := s.check(); len(problems) != test.problems {
+				t.Errorf("problems = %q, want %d", problems, test.problems)
+			}
+			// whatever was wrong goes back to its default
+			if !reflect.DeepEqual(s, defaultSettings()) {
+				t.Errorf("settings = %#v, want the defaults", s)
+			}
+		})
+	}
+}
+
+func TestLineNumberCompletion(t *testing.T) {
+	tests := []struct {
+		name      string
+		code      string
+		line      int
+		increment int
+		want      []string
+	}{
+		{"after the last line", "10 PRINT\n20 GOTO 10\n", 2, 10, []string{"30"}},
+		{"own increment", "100 PRINT\n\n", 1, 5, []string{"105"}},
+		{"between lines", "10 PRINT\n\n40 END\n", 1, 10, []string{"20"}},
+		{"partly typed", "10 PRINT\n2\n", 1, 10, []string{"20"}},
+		{"no room", "10 PRINT\n\n15 END\n", 1, 10, nil},
+		{"first line", "\n10 PRINT\n", 0, 10, nil},
+		{"past the last line number", "63995 PRINT\n\n", 1, 10, nil},
+		{"line with a statement", "10 PRINT\n20 GOTO 10\n", 1, 10, nil},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			h := NewHandler().(*lspHandler)
+			h.settings.LineNumbers.Increment = test.increment
+			uri := DocumentURI("file:///tmp/test.bas")
+			if err := h.openFile(uri, "c64basic", 1); err != nil {
+				t.Fatal(err)
+			}
+			// the text needn't parse to be completed
+			_ = h.updateFile(context.Background(), uri, File{Text: test.code, Version: 1})
+
+			items, err := h.completion(context.Background(), uri, &CompletionParams{
+				TextDocumentPositionParams: TextDocumentPositionParams{
+					TextDocument: TextDocumentIdentifier{URI: uri},
+					Position:     Position{Line: test.line},
+				},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, item := range items {
+				got = append(got, item.Label)
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("completions = %q, want %q", got, test.want)
+			}
+		})
+	}
+}
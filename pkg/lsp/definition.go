@@ -19,6 +19,9 @@ func (h *lspHandler) handleTextDocumentDefinition(ctx context.Context, conn *jso
 		return nil, err
 	}
 
+	w := h.beginWorkDone(ctx, params.WorkDoneToken, "Finding the definition", nil)
+	defer w.end(ctx, "")
+
 	return h.definition(ctx, params.TextDocument.URI, &params)
 }
 
@@ -0,0 +1,273 @@
+package lsp
+
+import (
+	"context"
+	"errors"
+
+	"github.com/alecthomas/participle/v2"
+
+	"github.com/miselin/c64lsp/pkg/analysis"
+	"github.com/miselin/c64lsp/pkg/grammar"
+	"github.com/rs/zerolog"
+)
+
+// diagnosticSource identifies diagnostics from this server in the client.
+const diagnosticSource = "c64lsp"
+
+// startDiagnostics publishes the diagnostics for a snapshot of a document in
+// the background, cancelling any still being worked out for an earlier one.
+func (h *lspHandler) startDiagnostics(ctx context.Context, uri DocumentURI, snap *snapshot) {
+	ctx, cancel := context.WithCancel(ctx)
+
+	h.mu.Lock()
+	if prev, ok := h.diagnosing[uri]; ok {
+		prev()
+	}
+	h.diagnosing[uri] = cancel
+	h.mu.Unlock()
+
+	h.publishing.Add(1)
+	go func() {
+		defer h.publishing.Done()
+		defer cancel()
+		if err := h.publishDiagnostics(ctx, uri, snap); err != nil && ctx.Err() == nil {
+			zerolog.Ctx(ctx).Error().Err(err).Msg("publish diagnostics")
+		}
+	}()
+}
+
+// stopDiagnostics cancels any diagnostics still being worked out for a
+// document.
+func (h *lspHandler) stopDiagnostics(uri DocumentURI) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if cancel, ok := h.diagnosing[uri]; ok {
+		cancel()
+		delete(h.diagnosing, uri)
+	}
+}
+
+// publishDiagnostics runs every diagnostic pass over a snapshot of a document
+// and sends the results to the client, or clears them if snap is nil for a
+// closed document. If the document has changed by the time they're ready,
+// they are dropped in favour of those for the newer snapshot, and they are
+// dropped without finishing if ctx is cancelled.
+func (h *lspHandler) publishDiagnostics(ctx context.Context, uri DocumentURI, snap *snapshot) error {
+	if h.conn == nil {
+		return nil
+	}
+
+	params := PublishDiagnosticsParams{URI: uri, Diagnostics: []Diagnostic{}}
+	if snap != nil {
+		params.Version = &snap.Version
+		switch {
+		case snap.parseErr != nil:
+			params.Diagnostics = h.currentSettings().Diagnostics.apply([]Diagnostic{parseDiagnostic(snap.Text, snap.parseErr)})
+		case snap.analysis != nil:
+			diags, err := h.diagnostics(ctx, snap.analysis)
+			if err != nil {
+				return err
+			}
+			params.Diagnostics = h.currentSettings().Diagnostics.apply(diags)
+		}
+		if snap.SyncError != "" {
+			params.Diagnostics = append(params.Diagnostics, Diagnostic{
+				Severity: SeverityError,
+				Code:     "out-of-sync",
+				Source:   diagnosticSource,
+				Message:  snap.SyncError + "; close and reopen it to resynchronise",
+			})
+		}
+	}
+
+	h.publishMu.Lock()
+	defer h.publishMu.Unlock()
+
+	if latest, _ := h.snapshot(uri); latest != snap || ctx.Err() != nil {
+		return nil
+	}
+	return h.conn.Notify(ctx, "textDocument/publishDiagnostics", params)
+}
+
+// diagnostics runs every diagnostic pass over a program, stopping between
+// passes if ctx is cancelled.
+func (h *lspHandler) diagnostics(ctx context.Context, a *programAnalysis) ([]Diagnostic, error) {
+	passes := []func(a *programAnalysis) []Diagnostic{
+		func(a *programAnalysis) []Diagnostic { return stringDiagnostics(a.program) },
+		unreachableDiagnostics,
+		loopDiagnostics,
+		subroutineDiagnostics,
+	}
+
+	diags := []Diagnostic{}
+	for _, pass := range passes {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		diags = append(diags, pass(a)...)
+	}
+	return diags, nil
+}
+
+// parseDiagnostic reports why a document doesn't parse.
+func parseDiagnostic(text string, err error) Diagnostic {
+	var pos Position
+	message := err.Error()
+	var perr participle.Error
+	if errors.As(err, &perr) {
+		pos = textPosition(text, perr.Position())
+		message = perr.Message()
+	}
+	return Diagnostic{
+		Range:    Range{Start: pos, End: pos},
+		Severity: SeverityError,
+		Code:     "syntax-error",
+		Source:   diagnosticSource,
+		Message:  message,
+	}
+}
+
+// stringDiagnostics reports unknown {mnemonics} in string literals.
+func stringDiagnostics(parsed *grammar.Program) []Diagnostic {
+	diags := []Diagnostic{}
+	for _, lit := range analysis.CollectStringLiterals(parsed) {
+		contents, start := parsed.StringContents(lit.Token)
+		for _, part := range lit.Parts {
+			if part.Err == nil {
+				continue
+			}
+			diags = append(diags, Diagnostic{
+				Range:    segmentRange(parsed, contents, start, part.StringSegment),
+				Severity: SeverityError,
+				Code:     "unknown-mnemonic",
+				Source:   diagnosticSource,
+				Message:  part.Err.Error(),
+			})
+		}
+	}
+	return diags
+}
+
+// unreachableDiagnostics reports statements that can't be reached from the
+// start of the program. Lines that only a computed ON ... GOTO or ON ... GOSUB
+// reaches are reported without fading them out, as the analysis can't tell
+// which values ON will see. REM and DATA never run, so are left alone.
+func unreachableDiagnostics(a *programAnalysis) []Diagnostic {
+	parsed, g := a.program, a.CFG()
+	reach := g.Reach()
+
+	diags := []Diagnostic{}
+	add := func(r Range, kind analysis.Reach) {
+		diag := Diagnostic{
+			Range:    r,
+			Severity: SeverityHint,
+			Code:     "unreachable",
+			Source:   diagnosticSource,
+			Message:  "Unreachable code",
+			Tags:     []DiagnosticTag{Unnecessary},
+		}
+		if kind == analysis.ReachedComputed {
+			diag.Code = "computed-only"
+			diag.Message = "Only reachable through ON ... GOTO or ON ... GOSUB"
+			diag.Tags = nil
+		}
+		diags = append(diags, diag)
+	}
+
+	for _, line := range parsed.Lines {
+		nodes := []*analysis.Node{}
+		for _, n := range g.LineNodes(line) {
+			if n.Command.Keyword != "REM" && n.Command.Keyword != "DATA" {
+				nodes = append(nodes, n)
+			}
+		}
+		if len(nodes) == 0 {
+			continue
+		}
+
+		// report a whole line once if none of it runs normally
+		lo, hi := analysis.Reached, analysis.Unreached
+		for _, n := range nodes {
+			lo, hi = min(lo, reach[n]), max(hi, reach[n])
+		}
+		if lo == hi && lo != analysis.Reached {
+			add(lineRange(parsed, line), lo)
+			continue
+		}
+
+		for _, n := range nodes {
+			if reach[n] == analysis.Unreached {
+				start, end := parsed.StatementSpan(n.Command.Statement)
+				add(Range{Start: toPosition(parsed, start), End: toPosition(parsed, end)}, analysis.Unreached)
+			}
+		}
+	}
+
+	return diags
+}
+
+// loopDiagnostics reports FOR and NEXT statements that don't pair up.
+func loopDiagnostics(a *programAnalysis) []Diagnostic {
+	parsed := a.program
+	diags := []Diagnostic{}
+	for _, problem := range a.Loops().Problems {
+		diag := Diagnostic{
+			Range:    commandRange(parsed, problem.Node.Command),
+			Severity: SeverityWarning,
+			Source:   diagnosticSource,
+			Message:  problem.Message,
+		}
+		switch problem.Kind {
+		case analysis.NextWithoutFor:
+			diag.Code = "next-without-for"
+			if !problem.Maybe {
+				diag.Severity = SeverityError
+			} else {
+				diag.Message = "NEXT may run without a matching FOR on some paths"
+			}
+		case analysis.NextClosesInner:
+			diag.Code = "next-closes-inner"
+		case analysis.LoopLeak:
+			diag.Code = "for-goto-leak"
+		case analysis.CounterReused:
+			diag.Code = "for-counter-reused"
+		}
+		diags = append(diags, diag)
+	}
+	return diags
+}
+
+// subroutineDiagnostics reports subroutines that are called or return badly.
+func subroutineDiagnostics(a *programAnalysis) []Diagnostic {
+	parsed := a.program
+	diags := []Diagnostic{}
+	subs := a.Subroutines()
+	for _, problem := range subs.Problems {
+		diag := Diagnostic{
+			Range:    commandRange(parsed, problem.Node.Command),
+			Severity: SeverityWarning,
+			Source:   diagnosticSource,
+			Message:  problem.Message,
+		}
+		switch problem.Kind {
+		case analysis.FallsThrough:
+			diag.Code = "subroutine-falls-through"
+			if subs.Subroutine(problem.Target) != nil {
+				// sharing a tail with another subroutine is often deliberate
+				diag.Severity = SeverityInformation
+			}
+		case analysis.NoReturn:
+			diag.Code = "subroutine-no-return"
+		case analysis.ReturnWithoutGosub:
+			diag.Code = "return-without-gosub"
+			diag.Severity = SeverityError
+		case analysis.RecursiveCall:
+			diag.Code = "recursive-gosub"
+		case analysis.GotoSubroutine:
+			diag.Code = "goto-subroutine"
+		}
+		diags = append(diags, diag)
+	}
+	return diags
+}
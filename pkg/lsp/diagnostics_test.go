@@ -0,0 +1,86 @@
+package lsp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/miselin/c64lsp/pkg/grammar"
+)
+
+// TestDiagnosticsCancelled checks that a cancelled run stops without
+// working out any diagnostics.
+func TestDiagnosticsCancelled(t *testing.T) {
+	g := grammar.NewGrammar()
+	program, err := g.Parse("test.bas", "10 goto 30\n20 print \"{nope}\"\n30 next\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := &lspHandler{settings: defaultSettings()}
+
+	diags, err := h.diagnostics(context.Background(), newProgramAnalysis(program))
+	if err != nil || len(diags) == 0 {
+		t.Fatalf("diagnostics = %v, %v, want some", diags, err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if diags, err := h.diagnostics(ctx, newProgramAnalysis(program)); !errors.Is(err, context.Canceled) {
+		t.Errorf("cancelled diagnostics = %v, %v, want %v", diags, err, context.Canceled)
+	}
+}
+
+// analyse parses a program for a test and starts its analyses.
+func analyse(t *testing.T, code string) *programAnalysis {
+	t.Helper()
+
+	g := grammar.NewGrammar()
+	program, err := g.Parse("test.bas", code)
+	if err != nil {
+		t.Fatalf("parsing %q: %v", code, err)
+	}
+	return newProgramAnalysis(program)
+}
+
+// describeDiagnostics describes diagnostics by their range and code.
+func describeDiagnostics(diags []Diagnostic) []string {
+	found := []string{}
+	for _, d := range diags {
+		found = append(found, fmt.Sprintf("%d:%d-%d:%d %s", d.Range.Start.Line, d.Range.Start.Character, d.Range.End.Line, d.Range.End.Character, d.Code))
+	}
+	return found
+}
+
+func TestUnreachableDiagnostics(t *testing.T) {
+	tests := []struct {
+		name string
+		code string
+		want []string
+	}{
+		{"all reached", "10 PRINT\n20 GOTO 10\n", []string{}},
+		{"after GOTO", "10 GOTO 30\n20 PRINT \"NEVER\"\n30 END\n", []string{"1:0-1:16 unreachable"}},
+		{"rest of a line", "10 END:PRINT\n", []string{"0:7-0:12 unreachable"}},
+		{"after END, but not REMs", "10 END\n20 REM DONE\n30 PRINT\n", []string{"2:0-2:8 unreachable"}},
+		{"only through ON", "10 ON K GOTO 30\n20 END\n30 PRINT\n40 END\n", []string{
+			"2:0-2:8 computed-only",
+			"3:0-3:6 computed-only",
+		}},
+		{"subroutines", "10 GOSUB 100\n20 END\n100 RETURN\n", []string{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			diags := unreachableDiagnostics(analyse(t, tt.code))
+			if got := describeDiagnostics(diags); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("diagnostics = %q, want %q", got, tt.want)
+			}
+			for _, d := range diags {
+				if d.Code == "unreachable" && (len(d.Tags) != 1 || d.Tags[0] != Unnecessary) {
+					t.Errorf("unreachable code at %v isn't faded", d.Range)
+				}
+			}
+		})
+	}
+}
@@ -3,6 +3,8 @@ package lsp
 import (
 	"context"
 	"encoding/json"
+	"fmt"
+	"strings"
 
 	"github.com/sourcegraph/jsonrpc2"
 )
@@ -20,7 +22,12 @@ func (h *lspHandler) handleTextDocumentDidOpen(ctx context.Context, conn *jsonrp
 	if err := h.openFile(params.TextDocument.URI, params.TextDocument.LanguageID, params.TextDocument.Version); err != nil {
 		return nil, err
 	}
-	if err := h.updateFile(ctx, params.TextDocument.URI, params.TextDocument.Text, &params.TextDocument.Version); err != nil {
+	f := File{
+		LanguageID: params.TextDocument.LanguageID,
+		Text:       params.TextDocument.Text,
+		Version:    params.TextDocument.Version,
+	}
+	if err := h.updateFile(ctx, params.TextDocument.URI, f); err != nil {
 		return nil, err
 	}
 	return nil, nil
@@ -36,7 +43,7 @@ func (h *lspHandler) handleTextDocumentDidClose(ctx context.Context, conn *jsonr
 		return nil, err
 	}
 
-	if err := h.closeFile(params.TextDocument.URI); err != nil {
+	if err := h.closeFile(ctx, params.TextDocument.URI); err != nil {
 		return nil, err
 	}
 	return nil, nil
@@ -52,14 +59,86 @@ func (h *lspHandler) handleTextDocumentDidChange(ctx context.Context, conn *json
 		return nil, err
 	}
 
-	if len(params.ContentChanges) == 1 {
-		if err := h.updateFile(ctx, params.TextDocument.URI, params.ContentChanges[0].Text, &params.TextDocument.Version); err != nil {
-			return nil, err
+	uri, version := params.TextDocument.URI, params.TextDocument.Version
+	snap, ok := h.snapshot(uri)
+	if !ok {
+		return nil, fmt.Errorf("document not found: %v", uri)
+	}
+	f := snap.File
+	if version <= f.Version {
+		f.SyncError = fmt.Sprintf("Received version %d of the document after version %d", version, f.Version)
+	}
+
+	// changes apply in order, each to the result of the last
+	text := f.Text
+	for _, change := range params.ContentChanges {
+		if change.Range == nil {
+			// the whole document, which brings it back in sync
+			text = change.Text
+			f.SyncError = ""
+			continue
+		}
+		if text, err = applyChange(text, change); err != nil {
+			// keep the last text that is known to be right
+			text = f.Text
+			f.SyncError = fmt.Sprintf("Couldn't apply changes for version %d of the document: %v", version, err)
+			break
 		}
 	}
+
+	f.Text, f.Version = text, version
+	if err := h.updateFile(ctx, uri, f); err != nil {
+		return nil, err
+	}
 	return nil, nil
 }
 
+// applyChange applies a change with a range to the text of a document.
+func applyChange(text string, change TextDocumentContentChangeEvent) (string, error) {
+	start, err := positionOffset(text, change.Range.Start)
+	if err != nil {
+		return "", err
+	}
+	end, err := positionOffset(text, change.Range.End)
+	if err != nil {
+		return "", err
+	}
+	if end < start {
+		return "", fmt.Errorf("range ends before it starts")
+	}
+
+	return text[:start] + change.Text + text[end:], nil
+}
+
+// positionOffset converts an LSP position to a byte offset in text. The
+// client counts characters in UTF-16 code units, so characters outside the
+// Basic Multilingual Plane count twice. A character past the end of its line
+// means the end of the line, but a line past the end of the text is an error.
+func positionOffset(text string, pos Position) (int, error) {
+	if pos.Line < 0 || pos.Character < 0 {
+		return 0, fmt.Errorf("invalid position %d:%d", pos.Line, pos.Character)
+	}
+
+	offset := 0
+	for line := 0; line < pos.Line; line++ {
+		i := strings.IndexByte(text[offset:], '\n')
+		if i < 0 {
+			return 0, fmt.Errorf("line %d is past the end of the document", pos.Line)
+		}
+		offset += i + 1
+	}
+
+	units := 0
+	rest := text[offset:]
+	for i, r := range rest {
+		if units >= pos.Character || r == '\n' || (r == '\r' && strings.HasPrefix(rest[i:], "\r\n")) {
+			return offset + i, nil
+		}
+		units += utf16Units(r)
+	}
+	return len(text), nil
+}
+
 func (h *lspHandler) handleTextDocumentDidSave(ctx context.Context, conn *jsonrpc2.Conn, req *jsonrpc2.Request) (result any, err error) {
 	if req.Params == nil {
 		return nil, &jsonrpc2.Error{Code: jsonrpc2.CodeInvalidParams}
@@ -71,7 +150,13 @@ func (h *lspHandler) handleTextDocumentDidSave(ctx context.Context, conn *jsonrp
 	}
 
 	if params.Text != nil {
-		err = h.updateFile(ctx, params.TextDocument.URI, *params.Text, nil)
+		snap, ok := h.snapshot(params.TextDocument.URI)
+		if !ok {
+			return nil, fmt.Errorf("document not found: %v", params.TextDocument.URI)
+		}
+		f := snap.File
+		f.Text = *params.Text
+		err = h.updateFile(ctx, params.TextDocument.URI, f)
 	} else {
 		err = h.saveFile(params.TextDocument.URI)
 	}
@@ -2,11 +2,14 @@ package lsp
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"net/url"
 	"path/filepath"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"unicode"
 
 	"github.com/miselin/c64lsp/pkg/grammar"
@@ -14,25 +17,58 @@ import (
 	"github.com/sourcegraph/jsonrpc2"
 )
 
+// Notifications, which include every change to a document, are handled one at
+// a time in the order they arrive, and are the only thing that changes the
+// handler. Other requests each run on their own goroutine against the latest
+// snapshot of their document.
 type lspHandler struct {
-	files    map[DocumentURI]*File
-	parsed   map[DocumentURI]*grammar.Program
+	// mu guards snapshots, settings and the cancel functions of running
+	// requests and operations.
+	mu        sync.Mutex
+	snapshots map[DocumentURI]*snapshot
+	settings  settings
+	// The settings from the client, which .c64lsp.json overrides.
+	clientSettings json.RawMessage
+	requests       map[jsonrpc2.ID]context.CancelFunc
+	// Operations that reported their progress with a token the server
+	// created, by that token.
+	progress map[string]context.CancelFunc
+	// publishMu keeps diagnostics for an older snapshot from being sent
+	// after those for a newer one.
+	publishMu sync.Mutex
+	// publishing tracks diagnostics still being worked out, and diagnosing
+	// cancels them for each document, guarded by mu.
+	publishing sync.WaitGroup
+	diagnosing map[DocumentURI]context.CancelFunc
+	// Set once the client asks the server to shut down.
+	shutdown atomic.Bool
+
 	conn     *jsonrpc2.Conn
 	rootPath string
 	folders  []string
 	g        grammar.BasicGrammar
+	index    symbolIndex
+	reply    jsonrpc2.Handler
+
+	// Whether the client lets the server create progress tokens.
+	workDoneProgress bool
+	progressTokens   atomic.Int64
 }
 
 // NewHandler creates a new JSONRPC2 handler to handle LSP requests.
-func NewHandler() jsonrpc2.Handler {
+func NewHandler() Handler {
 	handler := &lspHandler{
-		files:  make(map[DocumentURI]*File),
-		parsed: make(map[DocumentURI]*grammar.Program),
-		conn:   nil,
-		g:      grammar.NewGrammar(),
+		snapshots:  make(map[DocumentURI]*snapshot),
+		requests:   make(map[jsonrpc2.ID]context.CancelFunc),
+		progress:   make(map[string]context.CancelFunc),
+		diagnosing: make(map[DocumentURI]context.CancelFunc),
+		conn:       nil,
+		g:          grammar.NewGrammar(),
+		settings:   defaultSettings(),
 	}
+	handler.reply = jsonrpc2.HandlerWithError(handler.handleRequest)
 
-	return jsonrpc2.HandlerWithError(handler.handle)
+	return handler
 }
 
 func isWindowsDrivePath(path string) bool {
@@ -79,6 +115,8 @@ func matchRootPath(fname string, markers []string) string {
 	for dir != prev {
 		files, err := ioutil.ReadDir(dir)
 		if err != nil {
+			prev = dir
+			dir = filepath.Dir(dir)
 			continue
 		}
 		for _, file := range files {
@@ -110,9 +148,13 @@ func matchRootPath(fname string, markers []string) string {
 	return ""
 }
 
-func (h *lspHandler) closeFile(uri DocumentURI) error {
-	delete(h.files, uri)
-	return nil
+func (h *lspHandler) closeFile(ctx context.Context, uri DocumentURI) error {
+	h.stopDiagnostics(uri)
+	h.removeSnapshot(uri)
+	// forget any unsaved changes
+	h.reindexFile(ctx, uri)
+	// clear any diagnostics the client is still showing
+	return h.publishDiagnostics(ctx, uri, nil)
 }
 
 func (h *lspHandler) saveFile(uri DocumentURI) error {
@@ -120,37 +162,57 @@ func (h *lspHandler) saveFile(uri DocumentURI) error {
 }
 
 func (h *lspHandler) openFile(uri DocumentURI, languageID string, version int) error {
-	f := &File{
-		Text:       "",
-		LanguageID: languageID,
-		Version:    version,
-	}
-	h.files[uri] = f
+	h.setSnapshot(&snapshot{
+		File: File{
+			Text:       "",
+			LanguageID: languageID,
+			Version:    version,
+		},
+		uri: uri,
+	})
 	return nil
 }
 
-func (h *lspHandler) updateFile(ctx context.Context, uri DocumentURI, text string, version *int) error {
-	f, ok := h.files[uri]
+// updateFile makes a new snapshot of a document from its new contents.
+func (h *lspHandler) updateFile(ctx context.Context, uri DocumentURI, f File) error {
+	prev, ok := h.snapshot(uri)
 	if !ok {
 		return fmt.Errorf("document not found: %v", uri)
 	}
 
-	f.Text = text
-	if version != nil {
-		f.Version = *version
-	}
-
 	fp, err := fromURI(uri)
 	if err != nil {
 		return fmt.Errorf("file path from URI: %w", err)
 	}
 
 	// TODO: should cache the grammar object??
-	h.parsed[uri], err = h.g.Parse(fp, text)
+
+	// only the lines changed since the last good parse are parsed again, and
+	// that parse is kept until the document parses again. The analyses of
+	// the new program reuse those of the last one where the edit can't have
+	// changed them.
+	snap := &snapshot{File: f, uri: uri, program: prev.program, analysis: prev.analysis}
+	parsed, err := h.g.Reparse(prev.program, fp, f.Text)
 	if err != nil {
-		return fmt.Errorf("parse: %w", err)
+		snap.parseErr = err
+	} else if parsed != prev.program {
+		snap.program = parsed
+		snap.analysis = nextProgramAnalysis(prev.analysis, parsed)
 	}
+	h.setSnapshot(snap)
+	if err == nil {
+		h.index.update(uri, snap.analysis)
+	}
+
+	// diagnostics need every analysis of the program, so don't hold up the
+	// next change waiting for them, and the next change cancels them. Those
+	// for a document that doesn't parse replace any for the last version
+	// that did, which no longer line up.
+	h.startDiagnostics(ctx, uri, snap)
 
+	if err != nil {
+		return fmt.Errorf("parse: %w", err)
+	}
 	return nil
 }
 
@@ -168,6 +230,53 @@ func (h *lspHandler) addFolder(folder string) {
 	}
 }
 
+// Handle implements jsonrpc2.Handler.
+func (h *lspHandler) Handle(ctx context.Context, conn *jsonrpc2.Conn, req *jsonrpc2.Request) {
+	if h.shutdown.Load() && req.Method != "exit" {
+		// nothing but exit is allowed after shutdown
+		if !req.Notif {
+			conn.ReplyWithError(ctx, req.ID, &jsonrpc2.Error{Code: jsonrpc2.CodeInvalidRequest, Message: "server is shutting down"})
+		}
+		return
+	}
+
+	// the server isn't set up until initialize is done, and must not take
+	// any more requests once shutdown is
+	if req.Notif || req.Method == "initialize" || req.Method == "shutdown" {
+		h.reply.Handle(ctx, conn, req)
+		return
+	}
+
+	// $/cancelRequest is a notification, so is handled in order and finds
+	// the request as long as it is still running
+	ctx, cancel := context.WithCancel(ctx)
+	h.startRequest(req.ID, cancel)
+	go func() {
+		defer h.finishRequest(req.ID)
+		h.reply.Handle(ctx, conn, req)
+	}()
+}
+
+// handleRequest handles a request and checks that it wasn't cancelled and that
+// its document didn't change while it ran. A result for an old version of a
+// document is dropped, and the client asks again.
+func (h *lspHandler) handleRequest(ctx context.Context, conn *jsonrpc2.Conn, req *jsonrpc2.Request) (result any, err error) {
+	if req.Notif {
+		return h.handle(ctx, conn, req)
+	}
+
+	uri := requestURI(req)
+	before, _ := h.snapshot(uri)
+	result, err = h.handle(ctx, conn, req)
+	if ctx.Err() != nil {
+		return nil, &jsonrpc2.Error{Code: CodeRequestCancelled, Message: "request cancelled"}
+	}
+	if after, _ := h.snapshot(uri); uri != "" && after != before {
+		return nil, &jsonrpc2.Error{Code: CodeContentModified, Message: "document changed during the request"}
+	}
+	return result, err
+}
+
 func (h *lspHandler) handle(ctx context.Context, conn *jsonrpc2.Conn, req *jsonrpc2.Request) (result any, err error) {
 	logger := zerolog.Ctx(ctx)
 
@@ -177,9 +286,15 @@ func (h *lspHandler) handle(ctx context.Context, conn *jsonrpc2.Conn, req *jsonr
 	case "initialize":
 		return h.handleInitialize(ctx, conn, req)
 	case "initialized":
-		return
+		return h.handleInitialized(ctx, conn, req)
+	case "$/cancelRequest":
+		return h.handleCancelRequest(ctx, conn, req)
+	case "window/workDoneProgress/cancel":
+		return h.handleWorkDoneProgressCancel(ctx, conn, req)
 	case "shutdown":
 		return h.handleShutdown(ctx, conn, req)
+	case "exit":
+		return h.handleExit(ctx, conn, req)
 	case "textDocument/didOpen":
 		return h.handleTextDocumentDidOpen(ctx, conn, req)
 	case "textDocument/didChange":
@@ -194,6 +309,32 @@ func (h *lspHandler) handle(ctx context.Context, conn *jsonrpc2.Conn, req *jsonr
 		return h.handleTextDocumentDefinition(ctx, conn, req)
 	case "textDocument/hover":
 		return h.handleTextDocumentHover(ctx, conn, req)
+	case "textDocument/inlayHint":
+		return h.handleTextDocumentInlayHint(ctx, conn, req)
+	case "textDocument/documentHighlight":
+		return h.handleTextDocumentDocumentHighlight(ctx, conn, req)
+	case "textDocument/documentSymbol":
+		return h.handleTextDocumentDocumentSymbol(ctx, conn, req)
+	case "textDocument/semanticTokens/full":
+		return h.handleTextDocumentSemanticTokensFull(ctx, conn, req)
+	case "textDocument/semanticTokens/range":
+		return h.handleTextDocumentSemanticTokensRange(ctx, conn, req)
+	case "textDocument/prepareCallHierarchy":
+		return h.handleTextDocumentPrepareCallHierarchy(ctx, conn, req)
+	case "callHierarchy/incomingCalls":
+		return h.handleCallHierarchyIncomingCalls(ctx, conn, req)
+	case "callHierarchy/outgoingCalls":
+		return h.handleCallHierarchyOutgoingCalls(ctx, conn, req)
+	case "textDocument/codeAction":
+		return h.handleTextDocumentCodeAction(ctx, conn, req)
+	case "workspace/symbol":
+		return h.handleWorkspaceSymbol(ctx, conn, req)
+	case "workspace/executeCommand":
+		return h.handleWorkspaceExecuteCommand(ctx, conn, req)
+	case "workspace/didChangeConfiguration":
+		return h.handleWorkspaceDidChangeConfiguration(ctx, conn, req)
+	case "workspace/didChangeWatchedFiles":
+		return h.handleWorkspaceDidChangeWatchedFiles(ctx, conn, req)
 	}
 
 	return nil, &jsonrpc2.Error{Code: jsonrpc2.CodeMethodNotFound, Message: fmt.Sprintf("method not supported: %s", req.Method)}
@@ -0,0 +1,59 @@
+package lsp
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/miselin/c64lsp/pkg/analysis"
+	"github.com/sourcegraph/jsonrpc2"
+)
+
+func (h *lspHandler) handleTextDocumentDocumentHighlight(ctx context.Context, conn *jsonrpc2.Conn, req *jsonrpc2.Request) (result any, err error) {
+	if req.Params == nil {
+		return nil, &jsonrpc2.Error{Code: jsonrpc2.CodeInvalidParams}
+	}
+
+	var params DocumentHighlightParams
+	if err := json.Unmarshal(*req.Params, &params); err != nil {
+		return nil, err
+	}
+
+	return h.documentHighlight(ctx, params.TextDocument.URI, &params)
+}
+
+func (h *lspHandler) documentHighlight(ctx context.Context, uri DocumentURI, params *DocumentHighlightParams) ([]DocumentHighlight, error) {
+	snap, err := h.parsedSnapshot(uri)
+	if err != nil {
+		return nil, err
+	}
+	parsed := snap.program
+
+	tok := tokenAt(parsed, params.Position)
+	if tok == nil || !(tok.IsKeyword("FOR") || tok.IsKeyword("NEXT")) {
+		return nil, nil
+	}
+
+	loops := snap.analysis.Loops()
+	g := loops.CFG
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	// link the FOR with every NEXT that can close it, or the NEXT with
+	// every FOR it can close
+	var linked []*analysis.Node
+	for _, n := range g.Nodes {
+		if n.Command == nil || n.Command.Token != tok {
+			continue
+		}
+		linked = append([]*analysis.Node{n}, loops.Nexts[n]...)
+		linked = append(linked, loops.Fors[n]...)
+		break
+	}
+
+	highlights := []DocumentHighlight{}
+	for _, n := range linked {
+		highlights = append(highlights, DocumentHighlight{Range: tokenRange(parsed, n.Command.Token), Kind: TextHighlight})
+	}
+	return highlights, nil
+}
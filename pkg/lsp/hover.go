@@ -5,7 +5,10 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"strings"
 
+	"github.com/miselin/c64lsp/pkg/analysis"
+	"github.com/miselin/c64lsp/pkg/grammar"
 	"github.com/miselin/c64lsp/pkg/reference"
 	"github.com/sourcegraph/jsonrpc2"
 )
@@ -20,31 +23,277 @@ func (h *lspHandler) handleTextDocumentHover(ctx context.Context, conn *jsonrpc2
 		return nil, err
 	}
 
-	return h.hover(params.TextDocument.URI, &params)
+	return h.hover(ctx, params.TextDocument.URI, &params)
 }
 
-func (h *lspHandler) hover(uri DocumentURI, params *HoverParams) (*Hover, error) {
-	parsed, ok := h.parsed[params.TextDocument.URI]
-	if !ok {
-		return nil, fmt.Errorf("hover but no parsed file")
+func (h *lspHandler) hover(ctx context.Context, uri DocumentURI, params *HoverParams) (*Hover, error) {
+	snap, err := h.parsedSnapshot(params.TextDocument.URI)
+	if err != nil {
+		return nil, err
 	}
+	parsed := snap.program
 
-	token := parsed.FindBasicTokenAt(params.Position.Line, params.Position.Character)
-	if token == nil {
+	tok := tokenAt(parsed, params.Position)
+	if tok == nil {
 		// nothing to show
 		return nil, nil
 	}
 
-	docs, err := reference.GetFunctionDocs(*token)
+	if tok.VariableName() != "" {
+		return h.hoverVariable(snap, tok)
+	}
+
+	if tok.Value != nil && tok.Value.String != nil {
+		return h.hoverMnemonic(parsed, tok, params.Position)
+	}
+
+	if tok.Value != nil && tok.Value.Number != nil {
+		if hover, err := h.hoverAddress(ctx, snap, tok); hover != nil || err != nil {
+			return hover, err
+		}
+		return h.hoverLineNumber(ctx, snap, tok)
+	}
+
+	if tok.BasicToken == nil {
+		return nil, nil
+	}
+
+	docs, err := reference.GetFunctionDocs(*tok.BasicToken)
 	if errors.Is(err, reference.FunctionNotFound) {
 		// we just don't know this function
+		return h.hoverAddress(ctx, snap, tok)
+	} else if err != nil {
+		return nil, fmt.Errorf("blah %v", tok)
+	}
+
+	r := tokenRange(parsed, tok)
+	return &Hover{
+		Contents: MarkupContent{Kind: Markdown, Value: docs.Markdown()},
+		Range:    &r,
+	}, nil
+}
+
+func (h *lspHandler) hoverVariable(snap *snapshot, tok *grammar.StatementToken) (*Hover, error) {
+	parsed := snap.program
+	v := snap.analysis.Variables().Lookup(tok)
+	if v == nil {
+		// e.g. a DEF FN name or parameter
+		return nil, nil
+	}
+
+	r := tokenRange(parsed, tok)
+	return &Hover{
+		Contents: MarkupContent{Kind: Markdown, Value: variableMarkdown(parsed, v)},
+		Range:    &r,
+	}, nil
+}
+
+func variableMarkdown(parsed *grammar.Program, v *analysis.Variable) string {
+	var sb strings.Builder
+
+	kind := "variable"
+	name := v.Name
+	if v.Array {
+		kind = "array"
+		name += "()"
+	}
+	sb.WriteString(fmt.Sprintf("**%s** — %s %s\n\n", name, v.Type, kind))
+
+	sb.WriteString(fmt.Sprintf("Effective name: `%s`", v.Name))
+	if len(v.Spellings) > 1 || v.Spellings[0] != v.Name {
+		sb.WriteString(fmt.Sprintf(" (written as `%s`)", strings.Join(v.Spellings, "`, `")))
+	}
+	sb.WriteString("\n\n")
+
+	if v.Array {
+		if len(v.Dimensions) == 0 {
+			sb.WriteString("Dimensions: not dimensioned, defaults to 11 elements (0-10) per index\n\n")
+		} else {
+			dims := []string{}
+			for _, dim := range v.Dimensions {
+				dims = append(dims, parsed.TokensText(dim))
+			}
+			sb.WriteString(fmt.Sprintf("Dimensions: `%s(%s)`\n\n", v.Name, strings.Join(dims, ",")))
+		}
+	}
+
+	for _, loop := range v.Loops {
+		bounds := fmt.Sprintf("%s TO %s", parsed.TokensText(loop.Start), parsed.TokensText(loop.End))
+		if len(loop.Step) > 0 {
+			bounds += " STEP " + parsed.TokensText(loop.Step)
+		}
+		sb.WriteString(fmt.Sprintf("Loop counter on line %d: `FOR %s = %s`\n\n", loop.Command.Line.Label, v.Name, bounds))
+	}
+
+	defs := v.Definitions()
+	if len(defs) == 0 {
+		sb.WriteString("Never assigned, always ")
+		if v.Type == analysis.StringVariable {
+			sb.WriteString("`\"\"`\n\n")
+		} else {
+			sb.WriteString("`0`\n\n")
+		}
+		return sb.String()
+	}
+
+	first := defs[0]
+	start, end := parsed.StatementSpan(first.Command.Statement)
+	sb.WriteString(fmt.Sprintf("First assigned on line %d:\n\n", first.Command.Line.Label))
+	sb.WriteString(fmt.Sprintf("```basic\n%d %s\n```\n\n", first.Command.Line.Label, parsed.Text(start, end)))
+
+	lines := []string{}
+	seen := map[int]bool{}
+	for _, def := range defs {
+		label := def.Command.Line.Label
+		if seen[label] {
+			continue
+		}
+		seen[label] = true
+		lines = append(lines, fmt.Sprintf("%d (%s)", label, def.Kind))
+	}
+	sb.WriteString(fmt.Sprintf("Written on lines: %s\n", strings.Join(lines, ", ")))
+
+	return sb.String()
+}
+
+func (h *lspHandler) hoverLineNumber(ctx context.Context, snap *snapshot, tok *grammar.StatementToken) (*Hover, error) {
+	parsed, g := snap.program, snap.analysis.CFG()
+
+	branch := g.BranchAt(tok)
+	if branch == nil {
+		return nil, nil
+	}
+
+	others := []string{}
+	for _, b := range g.BranchesTo(branch.Target) {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		if b != branch {
+			others = append(others, fmt.Sprintf("%d", b.Command.Line.Label))
+		}
+	}
+
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("**Line %d** — %s target\n\n", branch.Target, branch.Kind))
+
+	target := parsed.FindBasicLine(branch.Target)
+	if target == nil {
+		sb.WriteString("Line does not exist (`?UNDEF'D STATEMENT ERROR`)\n")
+	} else {
+		sb.WriteString("```basic\n")
+		for _, rem := range analysis.LeadingComments(parsed, target) {
+			sb.WriteString(parsed.LineText(rem) + "\n")
+		}
+		sb.WriteString(parsed.LineText(target) + "\n")
+		// a REM target falls through to the first line that does something
+		for i := parsed.LineIndex(target) + 1; target.Comment != nil && i < len(parsed.Lines); i++ {
+			target = parsed.Lines[i]
+			sb.WriteString(parsed.LineText(target) + "\n")
+		}
+		sb.WriteString("```\n\n")
+	}
+
+	switch len(others) {
+	case 0:
+		sb.WriteString("No other references to this line\n")
+	case 1:
+		sb.WriteString(fmt.Sprintf("1 other reference, from line %s\n", others[0]))
+	default:
+		sb.WriteString(fmt.Sprintf("%d other references, from lines %s\n", len(others), strings.Join(others, ", ")))
+	}
+
+	r := tokenRange(parsed, tok)
+	return &Hover{
+		Contents: MarkupContent{Kind: Markdown, Value: sb.String()},
+		Range:    &r,
+	}, nil
+}
+
+func (h *lspHandler) hoverAddress(ctx context.Context, snap *snapshot, tok *grammar.StatementToken) (*Hover, error) {
+	parsed := snap.program
+	var access *analysis.MemoryAccess
+	for _, a := range snap.analysis.MemoryAccesses() {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		if a.Contains(tok) || a.ValueContains(tok) || (tok.BasicToken != nil && tok.IsKeyword(a.Keyword) && a.Command.Statement.Tokens[0] == tok) {
+			access = a
+			break
+		}
+	}
+	if access == nil {
+		return nil, nil
+	}
+
+	addr, exact, ok := analysis.AddressBase(access.Address)
+	if !ok {
+		return nil, nil
+	}
+
+	loc, err := reference.LookupAddressWithScreen(addr, h.currentSettings().Memory.Screen)
+	if errors.Is(err, reference.AddressNotFound) {
 		return nil, nil
 	} else if err != nil {
-		return nil, fmt.Errorf("blah %v", token)
+		return nil, err
+	}
+
+	var sb strings.Builder
+	sb.WriteString(loc.Markdown(addr))
+	if !exact {
+		sb.WriteString(fmt.Sprintf("\nAddress `%s` is offset from this base by its non-constant terms.\n", parsed.TokensText(access.Address)))
+	}
+
+	if value, ok := analysis.ConstantByte(access.Value); ok && exact {
+		decoded, err := reference.DecodeValue(addr, value)
+		if err == nil {
+			sb.WriteString(fmt.Sprintf("\n**Value:** `%d` — %s\n", value, decoded))
+		} else if !errors.Is(err, reference.DecoderNotFound) {
+			return nil, err
+		}
 	}
 
-	// TODO: range
+	r := tokenRange(parsed, tok)
 	return &Hover{
-		Contents: MarkupContent{Kind: "markdown", Value: docs.Markdown()},
+		Contents: MarkupContent{Kind: Markdown, Value: sb.String()},
+		Range:    &r,
 	}, nil
 }
+
+func (h *lspHandler) hoverMnemonic(parsed *grammar.Program, tok *grammar.StatementToken, pos Position) (*Hover, error) {
+	contents, start := parsed.StringContents(tok)
+	offset := runeColumn(contents, pos.Character-toPosition(parsed, start).Character)
+
+	for _, seg := range grammar.StringSegments(contents) {
+		if !seg.IsMnemonic || offset < seg.Start || offset >= seg.End {
+			continue
+		}
+
+		code, err := reference.LookupMnemonic(seg.Mnemonic)
+		if errors.Is(err, reference.MnemonicNotFound) {
+			return nil, nil
+		} else if err != nil {
+			return nil, err
+		}
+
+		pc, err := reference.LookupCharacter(code)
+		if errors.Is(err, reference.PetsciiNotFound) {
+			pc = &reference.PetsciiCode{Code: code, Mnemonic: seg.Mnemonic}
+		} else if err != nil {
+			return nil, err
+		}
+
+		docs := pc.Markdown()
+		if seg.Count > 1 {
+			docs += fmt.Sprintf("\nRepeated %d times\n", seg.Count)
+		}
+
+		r := segmentRange(parsed, contents, start, seg)
+		return &Hover{
+			Contents: MarkupContent{Kind: Markdown, Value: docs},
+			Range:    &r,
+		}, nil
+	}
+
+	return nil, nil
+}
@@ -0,0 +1,29 @@
+package lsp
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+// TestHoverCancelled checks that a hover stops once it is cancelled.
+func TestHoverCancelled(t *testing.T) {
+	h, uri := openDocument(t, "10 POKE 53280,0\n20 GOTO 10\n")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	positions := map[string]Position{
+		"address":     {Line: 0, Character: 9},
+		"line number": {Line: 1, Character: 9},
+	}
+	for name, pos := range positions {
+		params := &HoverParams{TextDocumentPositionParams: TextDocumentPositionParams{TextDocument: TextDocumentIdentifier{URI: uri}, Position: pos}}
+		if hover, err := h.hover(ctx, uri, params); !errors.Is(err, context.Canceled) {
+			t.Errorf("%s: hover = %v, %v, want it cancelled", name, hover, err)
+		}
+		if hover, err := h.hover(context.Background(), uri, params); err != nil || hover == nil {
+			t.Errorf("%s: hover = %v, %v, want it shown", name, hover, err)
+		}
+	}
+}
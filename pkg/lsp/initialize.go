@@ -24,17 +24,48 @@ func (h *lspHandler) handleInitialize(ctx context.Context, conn *jsonrpc2.Conn,
 	if err != nil {
 		return nil, err
 	}
+	h.workDoneProgress = params.Capabilities.Window.WorkDoneProgress
 	h.rootPath = filepath.Clean(rootPath)
+	h.clientSettings = params.InitializationOptions
+	h.reloadSettings(ctx)
 	h.addFolder(rootPath)
+	for _, folder := range params.WorkspaceFolders {
+		if path, err := fromURI(folder.URI); err == nil {
+			h.addFolder(path)
+		}
+	}
 
 	return InitializeResult{
 		Capabilities: ServerCapabilities{
-			TextDocumentSync:   TDSKFull,
-			DefinitionProvider: true,
-			HoverProvider:      true,
+			TextDocumentSync:          TDSKIncremental,
+			DefinitionProvider:        true,
+			HoverProvider:             true,
+			InlayHintProvider:         true,
+			CodeActionProvider:        true,
+			DocumentHighlightProvider: true,
+			CallHierarchyProvider:     true,
+			DocumentSymbolProvider:    true,
+			WorkspaceSymbolProvider: &WorkspaceSymbolOptions{
+				WorkDoneProgressOptions: WorkDoneProgressOptions{WorkDoneProgress: true},
+			},
+			ExecuteCommandProvider: &ExecuteCommandOptions{
+				Commands: commands,
+			},
+			SemanticTokensProvider: &SemanticTokensOptions{
+				Legend: semanticTokensLegend,
+				Range:  true,
+				Full:   true,
+			},
 			CompletionProvider: &CompletionOptions{
-				TriggerCharacters: []string{},
+				TriggerCharacters: []string{"{"},
 			},
 		},
 	}, nil
 }
+
+func (h *lspHandler) handleInitialized(ctx context.Context, conn *jsonrpc2.Conn, req *jsonrpc2.Request) (result any, err error) {
+	// index in the background so that requests aren't held up; the client
+	// can only be asked to show its progress once it is initialized
+	go h.indexWorkspace(ctx, append([]string{}, h.folders...))
+	return nil, nil
+}
@@ -0,0 +1,116 @@
+package lsp
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/miselin/c64lsp/pkg/analysis"
+	"github.com/miselin/c64lsp/pkg/grammar"
+	"github.com/miselin/c64lsp/pkg/reference"
+	"github.com/sourcegraph/jsonrpc2"
+)
+
+func (h *lspHandler) handleTextDocumentInlayHint(ctx context.Context, conn *jsonrpc2.Conn, req *jsonrpc2.Request) (result any, err error) {
+	if req.Params == nil {
+		return nil, &jsonrpc2.Error{Code: jsonrpc2.CodeInvalidParams}
+	}
+
+	var params InlayHintParams
+	if err := json.Unmarshal(*req.Params, &params); err != nil {
+		return nil, err
+	}
+
+	w := h.beginWorkDone(ctx, params.WorkDoneToken, "Finding inlay hints", nil)
+	defer w.end(ctx, "")
+
+	return h.inlayHints(ctx, params.TextDocument.URI, &params)
+}
+
+func (h *lspHandler) inlayHints(ctx context.Context, uri DocumentURI, params *InlayHintParams) ([]InlayHint, error) {
+	snap, err := h.parsedSnapshot(uri)
+	if err != nil {
+		return nil, err
+	}
+	parsed := snap.program
+
+	s := h.currentSettings()
+	cfg := s.InlayHints
+	hints := []InlayHint{}
+
+	add := func(tok *grammar.StatementToken, label string, tooltip string) {
+		r := tokenRange(parsed, tok)
+		if !inRange(params.Range, r.End) {
+			return
+		}
+		hints = append(hints, InlayHint{
+			Position:    r.End,
+			Label:       label,
+			Tooltip:     tooltip,
+			PaddingLeft: true,
+		})
+	}
+
+	for _, access := range snap.analysis.MemoryAccesses() {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		addr, exact, ok := analysis.AddressBase(access.Address)
+		if !ok || !exact {
+			continue
+		}
+
+		loc, err := reference.LookupAddressWithScreen(addr, s.Memory.Screen)
+		if err != nil {
+			continue
+		}
+
+		switch access.Keyword {
+		case "POKE", "WAIT":
+			if cfg.Poke {
+				add(access.Address[len(access.Address)-1], loc.Summary(addr), loc.Purpose)
+			}
+			value, ok := analysis.ConstantByte(access.Value)
+			if cfg.PokeValue && ok && access.Keyword == "POKE" {
+				if decoded, err := reference.DecodeValue(addr, value); err == nil {
+					add(access.Value[len(access.Value)-1], decoded, "")
+				}
+			}
+		case "PEEK":
+			if cfg.Peek {
+				add(access.Paren, loc.Summary(addr), loc.Purpose)
+			}
+		case "SYS":
+			if cfg.Sys {
+				add(access.Address[len(access.Address)-1], loc.Summary(addr), loc.Purpose)
+			}
+		}
+	}
+
+	if cfg.Chr {
+		for _, call := range analysis.CollectFunctionCalls(parsed, "CHR$") {
+			code, ok := analysis.ConstantByte(call.Args())
+			if !ok {
+				continue
+			}
+			if label := reference.ChrLabel(code); label != "" {
+				add(call.Paren, label, "")
+			}
+		}
+	}
+
+	return hints, nil
+}
+
+// inRange checks if a position falls within a range, inclusive of its end.
+func inRange(r Range, pos Position) bool {
+	if pos.Line < r.Start.Line || pos.Line > r.End.Line {
+		return false
+	}
+	if pos.Line == r.Start.Line && pos.Character < r.Start.Character {
+		return false
+	}
+	if pos.Line == r.End.Line && pos.Character > r.End.Character {
+		return false
+	}
+	return true
+}
@@ -0,0 +1,115 @@
+package lsp
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/rs/zerolog"
+	"github.com/sourcegraph/jsonrpc2"
+)
+
+// workDone reports the progress of a long operation to the client, with a
+// token from the client's request or one the server creates. If there is
+// neither, because the client doesn't support creating them, it reports
+// nothing.
+type workDone struct {
+	h     *lspHandler
+	token ProgressToken
+	// The last percentage reported, so that each report moves it on.
+	percentage int
+	// Whether the client can cancel the operation with
+	// window/workDoneProgress/cancel.
+	cancellable bool
+}
+
+// beginWorkDone starts reporting the progress of an operation. A request uses
+// the token the client gave with it, if any, and the client cancels it like
+// any other request. An operation the server starts itself has no token, so
+// one is created, and the client can cancel it with cancel.
+func (h *lspHandler) beginWorkDone(ctx context.Context, token ProgressToken, title string, cancel context.CancelFunc) *workDone {
+	w := &workDone{h: h, token: token}
+	if h.conn == nil {
+		w.token = nil
+		return w
+	}
+
+	if w.token == nil && cancel != nil && h.workDoneProgress {
+		token := fmt.Sprintf("c64lsp/%d", h.progressTokens.Add(1))
+		if err := h.conn.Call(ctx, "window/workDoneProgress/create", WorkDoneProgressCreateParams{Token: token}, nil); err != nil {
+			zerolog.Ctx(ctx).Debug().Msgf("creating progress token: %v", err)
+			return w
+		}
+		w.token = token
+		w.cancellable = true
+
+		h.mu.Lock()
+		h.progress[token] = cancel
+		h.mu.Unlock()
+	}
+
+	percentage := 0
+	w.notify(ctx, WorkDoneProgressBegin{Kind: "begin", Title: title, Cancellable: w.cancellable, Percentage: &percentage})
+	return w
+}
+
+// report reports that done of total steps are complete.
+func (w *workDone) report(ctx context.Context, done, total int, message string) {
+	percentage := 100
+	if total > 0 {
+		percentage = done * 100 / total
+	}
+	if percentage == w.percentage {
+		return
+	}
+	w.percentage = percentage
+
+	w.notify(ctx, WorkDoneProgressReport{Kind: "report", Cancellable: w.cancellable, Message: message, Percentage: &percentage})
+}
+
+// end finishes reporting progress.
+func (w *workDone) end(ctx context.Context, message string) {
+	w.notify(ctx, WorkDoneProgressEnd{Kind: "end", Message: message})
+
+	if token, ok := w.token.(string); ok && w.cancellable {
+		w.h.mu.Lock()
+		delete(w.h.progress, token)
+		w.h.mu.Unlock()
+	}
+}
+
+func (w *workDone) notify(ctx context.Context, value any) {
+	if w.token == nil {
+		return
+	}
+	if err := w.h.conn.Notify(ctx, "$/progress", ProgressParams{Token: w.token, Value: value}); err != nil {
+		zerolog.Ctx(ctx).Debug().Msgf("reporting progress: %v", err)
+	}
+}
+
+// handleWorkDoneProgressCancel cancels an operation the server created a
+// progress token for.
+func (h *lspHandler) handleWorkDoneProgressCancel(ctx context.Context, conn *jsonrpc2.Conn, req *jsonrpc2.Request) (result any, err error) {
+	if req.Params == nil {
+		return nil, &jsonrpc2.Error{Code: jsonrpc2.CodeInvalidParams}
+	}
+
+	var params WorkDoneProgressCancelParams
+	if err := json.Unmarshal(*req.Params, &params); err != nil {
+		return nil, err
+	}
+
+	token, ok := params.Token.(string)
+	if !ok {
+		// not one of ours
+		return nil, nil
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if cancel, ok := h.progress[token]; ok {
+		cancel()
+	}
+	return nil, nil
+}
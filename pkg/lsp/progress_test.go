@@ -0,0 +1,77 @@
+package lsp
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/sourcegraph/jsonrpc2"
+)
+
+// TestClientWorkDoneToken checks that a request reports its progress with the
+// token the client sent with it.
+func TestClientWorkDoneToken(t *testing.T) {
+	ctx := context.Background()
+	server, client := net.Pipe()
+
+	h := NewHandler().(*lspHandler)
+	serverConn := jsonrpc2.NewConn(ctx, jsonrpc2.NewBufferedStream(server, jsonrpc2.VSCodeObjectCodec{}), h)
+	defer serverConn.Close()
+
+	var mu sync.Mutex
+	kinds := map[string][]string{}
+	conn := jsonrpc2.NewConn(ctx, jsonrpc2.NewBufferedStream(client, jsonrpc2.VSCodeObjectCodec{}), jsonrpc2.HandlerWithError(
+		func(ctx context.Context, conn *jsonrpc2.Conn, req *jsonrpc2.Request) (any, error) {
+			if req.Method != "$/progress" {
+				return nil, nil
+			}
+			var params struct {
+				Token string `json:"token"`
+				Value struct {
+					Kind string `json:"kind"`
+				} `json:"value"`
+			}
+			if err := json.Unmarshal(*req.Params, &params); err != nil {
+				t.Error(err)
+			}
+			mu.Lock()
+			kinds[params.Token] = append(kinds[params.Token], params.Value.Kind)
+			mu.Unlock()
+			return nil, nil
+		}))
+	defer conn.Close()
+
+	if err := conn.Call(ctx, "initialize", map[string]any{"rootUri": "file://" + filepath.ToSlash(t.TempDir())}, nil); err != nil {
+		t.Fatal(err)
+	}
+	uri := DocumentURI("file:///tmp/test.bas")
+	if err := conn.Notify(ctx, "textDocument/didOpen", DidOpenTextDocumentParams{TextDocument: TextDocumentItem{
+		URI: uri, LanguageID: "c64basic", Version: 1, Text: "10 REM MAIN\n20 GOSUB 100:GOTO 20\n100 RETURN\n",
+	}}); err != nil {
+		t.Fatal(err)
+	}
+
+	requests := []struct {
+		method string
+		params any
+	}{
+		{"textDocument/documentSymbol", map[string]any{"textDocument": map[string]any{"uri": uri}, "workDoneToken": "textDocument/documentSymbol"}},
+		{"textDocument/semanticTokens/full", map[string]any{"textDocument": map[string]any{"uri": uri}, "workDoneToken": "textDocument/semanticTokens/full"}},
+		{"textDocument/definition", map[string]any{"textDocument": map[string]any{"uri": uri}, "position": map[string]any{"line": 1, "character": 10}, "workDoneToken": "textDocument/definition"}},
+		{"workspace/symbol", map[string]any{"query": "main", "workDoneToken": "workspace/symbol"}},
+	}
+	for _, r := range requests {
+		// the result doesn't matter, only the progress
+		_ = conn.Call(ctx, r.method, r.params, nil)
+
+		mu.Lock()
+		got := kinds[r.method]
+		mu.Unlock()
+		if len(got) < 2 || got[0] != "begin" || got[len(got)-1] != "end" {
+			t.Errorf("%s: progress %q, want it to begin and end", r.method, got)
+		}
+	}
+}
@@ -0,0 +1,159 @@
+package lsp
+
+import (
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/alecthomas/participle/v2/lexer"
+	"github.com/miselin/c64lsp/pkg/analysis"
+	"github.com/miselin/c64lsp/pkg/grammar"
+)
+
+// The lexer counts columns in runes, but the client counts characters in
+// UTF-16 code units, where those outside the Basic Multilingual Plane count
+// twice. Positions are converted both ways here.
+
+// utf16Units counts the UTF-16 code units of a character.
+func utf16Units(r rune) int {
+	if r >= 0x10000 {
+		// a surrogate pair
+		return 2
+	}
+	return 1
+}
+
+// utf16Len counts the UTF-16 code units in text.
+func utf16Len(text string) int {
+	units := 0
+	for _, r := range text {
+		units += utf16Units(r)
+	}
+	return units
+}
+
+// utf16Column converts a column counted in runes from the start of text to
+// one counted in UTF-16 code units.
+func utf16Column(text string, column int) int {
+	units := 0
+	for _, r := range text {
+		if column <= 0 {
+			break
+		}
+		units += utf16Units(r)
+		column--
+	}
+	return units + column
+}
+
+// runeColumn converts a column counted in UTF-16 code units from the start of
+// text to one counted in runes.
+func runeColumn(text string, units int) int {
+	column := 0
+	for _, r := range text {
+		if units <= 0 {
+			break
+		}
+		units -= utf16Units(r)
+		column++
+	}
+	return column + max(units, 0)
+}
+
+// toPosition converts a 1-based lexer position in a program to a 0-based LSP
+// position.
+func toPosition(program *grammar.Program, pos lexer.Position) Position {
+	return textPosition(program.Source, pos)
+}
+
+// textPosition converts a 1-based lexer position in text to a 0-based LSP
+// position.
+func textPosition(text string, pos lexer.Position) Position {
+	offset := min(max(pos.Offset, 0), len(text))
+	start := strings.LastIndexByte(text[:offset], '\n') + 1
+	return Position{Line: pos.Line - 1, Character: utf16Len(text[start:offset])}
+}
+
+// tokenAt returns the innermost token at an LSP position, if any.
+func tokenAt(program *grammar.Program, pos Position) *grammar.StatementToken {
+	offset, err := positionOffset(program.Source, pos)
+	if err != nil {
+		return nil
+	}
+	start := strings.LastIndexByte(program.Source[:offset], '\n') + 1
+	if utf16Len(program.Source[start:offset]) < pos.Character {
+		// past the end of the line
+		return nil
+	}
+	return program.FindTokenAt(pos.Line, utf8.RuneCountInString(program.Source[start:offset]))
+}
+
+// tokenRange returns the LSP range covered by a token.
+func tokenRange(program *grammar.Program, tok *grammar.StatementToken) Range {
+	start, end := program.TokenSpan(tok)
+	return Range{Start: toPosition(program, start), End: toPosition(program, end)}
+}
+
+// segmentRange returns the LSP range of a segment of a string literal, whose
+// contents start at start.
+func segmentRange(program *grammar.Program, contents string, start lexer.Position, seg grammar.StringSegment) Range {
+	pos := toPosition(program, start)
+	return Range{
+		Start: Position{Line: pos.Line, Character: pos.Character + utf16Column(contents, seg.Start)},
+		End:   Position{Line: pos.Line, Character: pos.Character + utf16Column(contents, seg.End)},
+	}
+}
+
+// lineRange returns the LSP range of a whole BASIC line, including its label.
+func lineRange(program *grammar.Program, line *grammar.BasicLine) Range {
+	start := toPosition(program, line.Pos)
+	end := Position{Line: start.Line, Character: start.Character + utf16Len(program.LineText(line))}
+	return Range{Start: start, End: end}
+}
+
+// linesRange returns the LSP range from the start of one line to the end of
+// another, by their index in Program.Lines.
+func linesRange(parsed *grammar.Program, start, end int) Range {
+	return Range{
+		Start: lineRange(parsed, parsed.Lines[start]).Start,
+		End:   lineRange(parsed, parsed.Lines[end]).End,
+	}
+}
+
+// labelRange returns the LSP range of a line's number.
+func labelRange(program *grammar.Program, line *grammar.BasicLine) Range {
+	start := toPosition(program, line.Pos)
+	return Range{Start: start, End: Position{Line: start.Line, Character: start.Character + len(strconv.Itoa(line.Label))}}
+}
+
+// commandRange returns the LSP range of a command, from its keyword to the end
+// of its statement.
+func commandRange(program *grammar.Program, cmd *analysis.Command) Range {
+	if cmd.Statement == nil {
+		// e.g. a REM line
+		return lineRange(program, cmd.Line)
+	}
+	start, end := program.StatementSpan(cmd.Statement)
+	switch {
+	case cmd.Token != nil:
+		start, _ = program.TokenSpan(cmd.Token)
+	case len(cmd.Args) > 0:
+		start, _ = program.TokenSpan(cmd.Args[0])
+	}
+	return Range{Start: toPosition(program, start), End: toPosition(program, end)}
+}
+
+// before checks if one position comes strictly before another.
+func before(a, b Position) bool {
+	return a.Line < b.Line || (a.Line == b.Line && a.Character < b.Character)
+}
+
+// contains checks if one range lies entirely within another.
+func contains(outer, inner Range) bool {
+	return !before(inner.Start, outer.Start) && !before(outer.End, inner.End)
+}
+
+// overlaps checks if two ranges share any position, inclusive of their ends.
+func overlaps(a, b Range) bool {
+	return !before(a.End, b.Start) && !before(b.End, a.Start)
+}
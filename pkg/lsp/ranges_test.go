@@ -0,0 +1,47 @@
+package lsp
+
+import (
+	"testing"
+
+	"github.com/miselin/c64lsp/pkg/grammar"
+)
+
+// TestUTF16Positions checks that positions after a character outside the
+// Basic Multilingual Plane, which the client counts as two, line up.
+func TestUTF16Positions(t *testing.T) {
+	g := grammar.NewGrammar()
+	// the emoji takes columns 10-11, {red} 12-16 and A is at 19
+	program, err := g.Parse("test.bas", "10 print \"\U0001F600{red}\":a=1\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tok := tokenAt(program, Position{Line: 0, Character: 19})
+	if tok == nil || tok.VariableName() != "a" {
+		t.Fatalf("token at 0:19 = %v, want variable a", tok)
+	}
+	if got, want := tokenRange(program, tok), (Range{Start: Position{Line: 0, Character: 19}, End: Position{Line: 0, Character: 20}}); got != want {
+		t.Errorf("range of a = %v, want %v", got, want)
+	}
+
+	str := tokenAt(program, Position{Line: 0, Character: 13})
+	if str == nil || str.Value == nil || str.Value.String == nil {
+		t.Fatalf("token at 0:13 = %v, want the string", str)
+	}
+	contents, start := program.StringContents(str)
+	for _, seg := range grammar.StringSegments(contents) {
+		if !seg.IsMnemonic {
+			continue
+		}
+		if got, want := segmentRange(program, contents, start, seg), (Range{Start: Position{Line: 0, Character: 12}, End: Position{Line: 0, Character: 17}}); got != want {
+			t.Errorf("range of {red} = %v, want %v", got, want)
+		}
+	}
+
+	if got, want := lineRange(program, program.Lines[0]).End.Character, 22; got != want {
+		t.Errorf("line ends at %d, want %d", got, want)
+	}
+	if tok := tokenAt(program, Position{Line: 0, Character: 23}); tok != nil {
+		t.Errorf("token past the end of the line = %v, want none", tok)
+	}
+}
@@ -0,0 +1,346 @@
+package lsp
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"strings"
+
+	"github.com/miselin/c64lsp/pkg/analysis"
+	"github.com/miselin/c64lsp/pkg/grammar"
+	"github.com/sourcegraph/jsonrpc2"
+)
+
+// semanticTokenType is an index into the legend's token types.
+type semanticTokenType int
+
+const (
+	keywordToken semanticTokenType = iota
+	functionToken
+	operatorToken
+	numberToken
+	stringToken
+	// A {mnemonic} within a string.
+	mnemonicToken
+	commentToken
+	variableToken
+	// A DEF FN parameter.
+	parameterToken
+	// A line number, either labelling a line or as the target of a branch.
+	labelToken
+)
+
+// Semantic token modifiers, as bits in the order of the legend.
+const (
+	// Line labels, DIMs and DEF FN names and parameters.
+	declarationModifier = 1 << iota
+	// Variables being written. Reads have no modifier.
+	modificationModifier
+	// Variables that are never read, and DEF FNs that are never called.
+	unusedModifier
+	floatModifier
+	integerModifier
+	stringModifier
+)
+
+var semanticTokensLegend = SemanticTokensLegend{
+	TokenTypes: []string{
+		"keyword", "function", "operator", "number", "string", "mnemonic",
+		"comment", "variable", "parameter", "label",
+	},
+	TokenModifiers: []string{
+		"declaration", "modification", "unused", "float", "integer", "string",
+	},
+}
+
+// semanticToken is a single token before encoding.
+type semanticToken struct {
+	Range
+	kind      semanticTokenType
+	modifiers int
+}
+
+// semanticBuilder finds the semantic tokens of a program.
+type semanticBuilder struct {
+	parsed *grammar.Program
+	vars   *analysis.Variables
+	refs   map[*grammar.StatementToken]*analysis.Reference
+	// Line numbers that are branch targets.
+	targets map[*grammar.StatementToken]bool
+	// FN calls, by effective name.
+	calls  map[string]int
+	tokens []semanticToken
+}
+
+func (h *lspHandler) handleTextDocumentSemanticTokensFull(ctx context.Context, conn *jsonrpc2.Conn, req *jsonrpc2.Request) (result any, err error) {
+	if req.Params == nil {
+		return nil, &jsonrpc2.Error{Code: jsonrpc2.CodeInvalidParams}
+	}
+
+	var params SemanticTokensParams
+	if err := json.Unmarshal(*req.Params, &params); err != nil {
+		return nil, err
+	}
+
+	w := h.beginWorkDone(ctx, params.WorkDoneToken, "Highlighting the document", nil)
+	defer w.end(ctx, "")
+
+	return h.semanticTokens(ctx, params.TextDocument.URI, nil)
+}
+
+func (h *lspHandler) handleTextDocumentSemanticTokensRange(ctx context.Context, conn *jsonrpc2.Conn, req *jsonrpc2.Request) (result any, err error) {
+	if req.Params == nil {
+		return nil, &jsonrpc2.Error{Code: jsonrpc2.CodeInvalidParams}
+	}
+
+	var params SemanticTokensRangeParams
+	if err := json.Unmarshal(*req.Params, &params); err != nil {
+		return nil, err
+	}
+
+	w := h.beginWorkDone(ctx, params.WorkDoneToken, "Highlighting the range", nil)
+	defer w.end(ctx, "")
+
+	return h.semanticTokens(ctx, params.TextDocument.URI, &params.Range)
+}
+
+// semanticTokens encodes the semantic tokens of a document, or only those
+// overlapping a range.
+func (h *lspHandler) semanticTokens(ctx context.Context, uri DocumentURI, within *Range) (*SemanticTokens, error) {
+	snap, err := h.parsedSnapshot(uri)
+	if err != nil {
+		return nil, err
+	}
+	parsed := snap.program
+
+	b := &semanticBuilder{
+		parsed:  parsed,
+		vars:    snap.analysis.Variables(),
+		refs:    map[*grammar.StatementToken]*analysis.Reference{},
+		targets: map[*grammar.StatementToken]bool{},
+		calls:   map[string]int{},
+	}
+	for _, v := range b.vars.All {
+		for _, ref := range v.References {
+			b.refs[ref.Token] = ref
+		}
+	}
+	for _, branch := range analysis.CollectBranches(parsed) {
+		b.targets[branch.Token] = true
+	}
+	for _, line := range parsed.Lines {
+		for _, stmt := range line.Statements {
+			b.countCalls(stmt.Tokens, stmt.Tokens[0].IsKeyword("DEF"))
+		}
+	}
+
+	for _, line := range parsed.Lines {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		b.line(line)
+	}
+
+	sort.SliceStable(b.tokens, func(i, j int) bool {
+		return before(b.tokens[i].Start, b.tokens[j].Start)
+	})
+
+	// each token is relative to the start of the previous one
+	data := []uint32{}
+	prev := Position{}
+	for _, tok := range b.tokens {
+		if within != nil && !overlaps(*within, tok.Range) {
+			continue
+		}
+		start := tok.Start.Character
+		if tok.Start.Line == prev.Line {
+			start -= prev.Character
+		}
+		data = append(data,
+			uint32(tok.Start.Line-prev.Line),
+			uint32(start),
+			uint32(tok.End.Character-tok.Start.Character),
+			uint32(tok.kind),
+			uint32(tok.modifiers),
+		)
+		prev = tok.Start
+	}
+
+	return &SemanticTokens{Data: data}, nil
+}
+
+// countCalls counts the FN calls in a run of tokens, other than the function
+// a DEF statement declares.
+func (b *semanticBuilder) countCalls(toks []*grammar.StatementToken, def bool) {
+	for i, tok := range toks {
+		if tok.IsKeyword("FN") && i+1 < len(toks) && toks[i+1].VariableName() != "" && !(def && i == 1) {
+			b.calls[analysis.EffectiveName(toks[i+1].VariableName())]++
+		}
+		if tok.Value != nil && tok.Value.Subexpression != nil {
+			b.countCalls(tok.Value.Subexpression.Tokens, false)
+		}
+	}
+}
+
+func (b *semanticBuilder) add(r Range, kind semanticTokenType, modifiers int) {
+	if r.Start.Line != r.End.Line || r.End.Character <= r.Start.Character {
+		return
+	}
+	b.tokens = append(b.tokens, semanticToken{Range: r, kind: kind, modifiers: modifiers})
+}
+
+func (b *semanticBuilder) line(line *grammar.BasicLine) {
+	b.add(labelRange(b.parsed, line), labelToken, declarationModifier)
+
+	if line.Comment != nil {
+		// the comment runs from REM to the end of the line
+		text := b.parsed.LineText(line)
+		comment := strings.TrimRight(*line.Comment, " \t\r")
+		if i := strings.LastIndex(text, comment); i >= 0 {
+			start := toPosition(b.parsed, line.Pos)
+			start.Character += utf16Len(text[:i])
+			end := Position{Line: start.Line, Character: start.Character + utf16Len(comment)}
+			b.add(Range{Start: start, End: end}, commentToken, 0)
+		}
+	}
+
+	for _, stmt := range line.Statements {
+		if len(stmt.Tokens) > 2 && stmt.Tokens[0].IsKeyword("DEF") {
+			b.def(stmt.Tokens)
+		} else {
+			b.statement(stmt.Tokens, "")
+		}
+	}
+}
+
+// def adds the tokens of DEF FN name(param) = body.
+func (b *semanticBuilder) def(toks []*grammar.StatementToken) {
+	b.add(tokenRange(b.parsed, toks[0]), keywordToken, 0)
+
+	rest := toks[1:]
+	if !rest[0].IsKeyword("FN") || rest[1].VariableName() == "" {
+		b.statement(rest, "")
+		return
+	}
+
+	b.add(tokenRange(b.parsed, rest[0]), functionToken, 0)
+	modifiers := declarationModifier
+	if b.calls[analysis.EffectiveName(rest[1].VariableName())] == 0 {
+		modifiers |= unusedModifier
+	}
+	b.add(tokenRange(b.parsed, rest[1]), functionToken, modifiers)
+	rest = rest[2:]
+
+	param := ""
+	if len(rest) > 0 && rest[0].Value != nil && rest[0].Value.Subexpression != nil {
+		for _, tok := range rest[0].Value.Subexpression.Tokens {
+			if name := tok.VariableName(); name != "" {
+				param = analysis.EffectiveName(name)
+				b.add(tokenRange(b.parsed, tok), parameterToken, declarationModifier|typeModifier(name))
+			}
+		}
+		rest = rest[1:]
+	}
+
+	b.statement(rest, param)
+}
+
+// statement adds the tokens of a statement or expression. Variables named
+// param are the parameter of a DEF FN.
+func (b *semanticBuilder) statement(toks []*grammar.StatementToken, param string) {
+	for i, tok := range toks {
+		r := tokenRange(b.parsed, tok)
+
+		if keyword := tok.Keyword(); keyword != "" {
+			switch {
+			case isOperator(keyword):
+				b.add(r, operatorToken, 0)
+			case analysis.IsFunction(keyword):
+				b.add(r, functionToken, 0)
+			default:
+				b.add(r, keywordToken, 0)
+			}
+			continue
+		}
+
+		switch v := tok.Value; {
+		case v == nil:
+		case v.Subexpression != nil:
+			b.statement(v.Subexpression.Tokens, param)
+		case v.Number != nil:
+			if b.targets[tok] {
+				b.add(r, labelToken, 0)
+			} else {
+				b.add(r, numberToken, 0)
+			}
+		case v.String != nil:
+			b.string(tok)
+		case v.Variable != nil:
+			switch name := *v.Variable; {
+			case i > 0 && toks[i-1].IsKeyword("FN"):
+				b.add(r, functionToken, 0)
+			case param != "" && analysis.EffectiveName(name) == param:
+				b.add(r, parameterToken, typeModifier(name))
+			default:
+				b.add(r, variableToken, b.variableModifiers(tok))
+			}
+		}
+	}
+}
+
+// string adds a string literal, with each mnemonic as its own token.
+func (b *semanticBuilder) string(tok *grammar.StatementToken) {
+	r := tokenRange(b.parsed, tok)
+	contents, pos := b.parsed.StringContents(tok)
+
+	from := r.Start
+	for _, seg := range grammar.StringSegments(contents) {
+		if !seg.IsMnemonic {
+			continue
+		}
+		mnemonic := segmentRange(b.parsed, contents, pos, seg)
+		b.add(Range{Start: from, End: mnemonic.Start}, stringToken, 0)
+		b.add(mnemonic, mnemonicToken, 0)
+		from = mnemonic.End
+	}
+	b.add(Range{Start: from, End: r.End}, stringToken, 0)
+}
+
+func (b *semanticBuilder) variableModifiers(tok *grammar.StatementToken) int {
+	modifiers := typeModifier(tok.VariableName())
+
+	if ref := b.refs[tok]; ref != nil {
+		switch {
+		case ref.Kind == analysis.DimReference:
+			modifiers |= declarationModifier
+		case ref.Kind.IsWrite():
+			modifiers |= modificationModifier
+		}
+	}
+
+	// a loop counter is read by its NEXT
+	if v := b.vars.Lookup(tok); v != nil && len(v.Uses()) == 0 && len(v.Loops) == 0 {
+		modifiers |= unusedModifier
+	}
+
+	return modifiers
+}
+
+func isOperator(keyword string) bool {
+	switch keyword {
+	case "+", "-", "*", "/", "^", "<", "=", ">":
+		return true
+	}
+	return false
+}
+
+// typeModifier returns the modifier for the type of a variable.
+func typeModifier(name string) int {
+	switch analysis.TypeOf(name) {
+	case analysis.IntegerVariable:
+		return integerModifier
+	case analysis.StringVariable:
+		return stringModifier
+	}
+	return floatModifier
+}
@@ -0,0 +1,108 @@
+package lsp
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// decodeSemanticTokens describes encoded semantic tokens by their text, type
+// and modifiers.
+func decodeSemanticTokens(text string, data []uint32) []string {
+	lines := strings.Split(text, "\n")
+	found := []string{}
+	line, char := 0, 0
+	for i := 0; i+4 < len(data); i += 5 {
+		if data[i] > 0 {
+			line, char = line+int(data[i]), 0
+		}
+		char += int(data[i+1])
+		s := lines[line][char : char+int(data[i+2])]
+		s += " " + semanticTokensLegend.TokenTypes[data[i+3]]
+		for bit, modifier := range semanticTokensLegend.TokenModifiers {
+			if data[i+4]&(1<<bit) != 0 {
+				s += " " + modifier
+			}
+		}
+		found = append(found, s)
+	}
+	return found
+}
+
+func TestSemanticTokens(t *testing.T) {
+	tests := []struct {
+		name string
+		code string
+		want []string
+	}{
+		{"assignment", "10 A%=A%+1\n", []string{
+			"10 label declaration",
+			"A% variable modification integer",
+			"= operator",
+			"A% variable integer",
+			"+ operator",
+			"1 number",
+		}},
+		{"branch", "10 GOTO 10\n", []string{
+			"10 label declaration",
+			"GOTO keyword",
+			"10 label",
+		}},
+		{"string and function", "10 PRINT \"{clr}HI\";LEFT$(N$,2)\n", []string{
+			"10 label declaration",
+			"PRINT keyword",
+			// the string is split around its mnemonics
+			"\" string",
+			"{clr} mnemonic",
+			"HI\" string",
+			"LEFT$ function",
+			"N$ variable string",
+			"2 number",
+		}},
+		{"DEF FN", "10 DEF FN SQ(X)=X*X\n20 REM UNUSED\n", []string{
+			"10 label declaration",
+			"DEF keyword",
+			"FN function",
+			"SQ function declaration unused",
+			"X parameter declaration float",
+			"= operator",
+			"X parameter float",
+			"* operator",
+			"X parameter float",
+			"20 label declaration",
+			"REM UNUSED comment",
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h, uri := openDocument(t, tt.code)
+			tokens, err := h.semanticTokens(context.Background(), uri, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := decodeSemanticTokens(tt.code, tokens.Data); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("tokens =\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(tt.want, "\n"))
+			}
+		})
+	}
+}
+
+func TestSemanticTokensRange(t *testing.T) {
+	code := "10 A=1\n20 PRINT A\n30 END\n"
+	h, uri := openDocument(t, code)
+	tokens, err := h.semanticTokens(context.Background(), uri, &Range{Start: Position{Line: 1}, End: Position{Line: 1, Character: 10}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the first token is relative to the start of the document
+	want := []string{"20 label declaration", "PRINT keyword", "A variable float"}
+	if got := decodeSemanticTokens(code, tokens.Data); !reflect.DeepEqual(got, want) {
+		t.Errorf("tokens = %q, want %q", got, want)
+	}
+	if fmt.Sprint(tokens.Data[:2]) != "[1 0]" {
+		t.Errorf("first token at %v, want line 1", tokens.Data[:2])
+	}
+}
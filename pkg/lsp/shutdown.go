@@ -6,6 +6,39 @@ import (
 	"github.com/sourcegraph/jsonrpc2"
 )
 
+// Handler handles LSP requests on a JSONRPC2 connection.
+type Handler interface {
+	jsonrpc2.Handler
+	// ExitCode returns the code to exit with once the connection closes: 0
+	// if the client shut the server down first, as it should, or 1 if not.
+	ExitCode() int
+}
+
+func (h *lspHandler) ExitCode() int {
+	if h.shutdown.Load() {
+		return 0
+	}
+	return 1
+}
+
+// handleShutdown stops the server taking requests. Diagnostics still being
+// worked out are sent first, as the client expects nothing more after its
+// shutdown request is answered. The connection stays open until exit.
 func (h *lspHandler) handleShutdown(ctx context.Context, conn *jsonrpc2.Conn, req *jsonrpc2.Request) (result any, err error) {
+	h.shutdown.Store(true)
+
+	// stop any background work reporting progress, e.g. indexing
+	h.mu.Lock()
+	for _, cancel := range h.progress {
+		cancel()
+	}
+	h.mu.Unlock()
+
+	h.publishing.Wait()
+	return nil, nil
+}
+
+// handleExit closes the connection, which ends the server with ExitCode.
+func (h *lspHandler) handleExit(ctx context.Context, conn *jsonrpc2.Conn, req *jsonrpc2.Request) (result any, err error) {
 	return nil, conn.Close()
 }
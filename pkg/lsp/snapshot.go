@@ -0,0 +1,89 @@
+package lsp
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/miselin/c64lsp/pkg/grammar"
+	"github.com/sourcegraph/jsonrpc2"
+)
+
+// snapshot is a document as of one version. Nothing in a snapshot changes
+// once it is made: each change to the document makes a new one, so requests
+// can read a snapshot while later changes come in.
+type snapshot struct {
+	File
+	uri DocumentURI
+
+	// The last program that parsed, which is of an earlier version when the
+	// text doesn't parse, and nil if it has never parsed. Only the next parse
+	// and diagnostics look at an earlier version's: requests go through
+	// parsedSnapshot.
+	program  *grammar.Program
+	analysis *programAnalysis
+	// Why the snapshot's own text didn't parse, if it didn't.
+	parseErr error
+}
+
+// snapshot returns the latest snapshot of an open document.
+func (h *lspHandler) snapshot(uri DocumentURI) (*snapshot, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	snap, ok := h.snapshots[uri]
+	return snap, ok
+}
+
+// parsedSnapshot returns the latest snapshot of an open document if its text
+// parsed. When it didn't, the snapshot's program is of an earlier version,
+// and positions in it point into text the client no longer has, so the
+// request fails with ContentModified and the client asks again later.
+func (h *lspHandler) parsedSnapshot(uri DocumentURI) (*snapshot, error) {
+	snap, ok := h.snapshot(uri)
+	if !ok || snap.program == nil {
+		return nil, fmt.Errorf("no parsed document: %v", uri)
+	}
+	if snap.parseErr != nil {
+		return nil, &jsonrpc2.Error{Code: CodeContentModified, Message: "document doesn't parse"}
+	}
+	return snap, nil
+}
+
+// setSnapshot makes a snapshot the latest version of its document.
+func (h *lspHandler) setSnapshot(snap *snapshot) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.snapshots[snap.uri] = snap
+}
+
+// removeSnapshot forgets a closed document.
+func (h *lspHandler) removeSnapshot(uri DocumentURI) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	delete(h.snapshots, uri)
+}
+
+// requestURI returns the document a request is about, or "" if it isn't
+// about one.
+func requestURI(req *jsonrpc2.Request) DocumentURI {
+	if req.Params == nil {
+		return ""
+	}
+
+	var params struct {
+		TextDocument TextDocumentIdentifier `json:"textDocument"`
+		// call hierarchy requests
+		Item struct {
+			URI DocumentURI `json:"uri"`
+		} `json:"item"`
+	}
+	if err := json.Unmarshal(*req.Params, &params); err != nil {
+		return ""
+	}
+	if params.TextDocument.URI != "" {
+		return params.TextDocument.URI
+	}
+	return params.Item.URI
+}
@@ -0,0 +1,91 @@
+package lsp
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/sourcegraph/jsonrpc2"
+)
+
+// openDocument starts a handler with a document open, failing the test if
+// the document doesn't parse.
+func openDocument(t *testing.T, code string) (*lspHandler, DocumentURI) {
+	t.Helper()
+
+	h := NewHandler().(*lspHandler)
+	uri := DocumentURI("file:///tmp/test.bas")
+	if err := h.openFile(uri, "c64basic", 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.updateFile(context.Background(), uri, File{Text: code, Version: 1}); err != nil {
+		t.Fatal(err)
+	}
+	return h, uri
+}
+
+// TestUnparsableSnapshot checks that once an edit stops a document parsing,
+// nothing found from the last parse, whose positions no longer line up, is
+// sent back.
+func TestUnparsableSnapshot(t *testing.T) {
+	ctx := context.Background()
+	h, uri := openDocument(t, "10 print chr$(147)\n20 p=p-1\n")
+
+	whole := Range{End: Position{Line: 2}}
+	actions, err := h.codeActions(ctx, uri, &CodeActionParams{TextDocument: TextDocumentIdentifier{URI: uri}, Range: whole})
+	if err != nil || len(actions) == 0 {
+		t.Fatalf("code actions = %v, %v, want the CHR$() rewrite", actions, err)
+	}
+
+	if err := h.updateFile(ctx, uri, File{Text: "5 print \"unterminated\n10 print chr$(147)\n20 p=p-1\n", Version: 2}); err == nil {
+		t.Fatal("broken line parsed")
+	}
+
+	actions, err = h.codeActions(ctx, uri, &CodeActionParams{TextDocument: TextDocumentIdentifier{URI: uri}, Range: whole})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, action := range actions {
+		if action.Kind != SourceAction {
+			t.Errorf("code action %q offered for the last parse", action.Title)
+		}
+	}
+
+	position := TextDocumentPositionParams{TextDocument: TextDocumentIdentifier{URI: uri}, Position: Position{Line: 1, Character: 3}}
+	requests := map[string]func() error{
+		"semantic tokens": func() error {
+			_, err := h.semanticTokens(ctx, uri, nil)
+			return err
+		},
+		"hover": func() error {
+			_, err := h.hover(ctx, uri, &HoverParams{TextDocumentPositionParams: position})
+			return err
+		},
+		"document highlight": func() error {
+			_, err := h.documentHighlight(ctx, uri, &DocumentHighlightParams{TextDocumentPositionParams: position})
+			return err
+		},
+		"inlay hints": func() error {
+			_, err := h.inlayHints(ctx, uri, &InlayHintParams{TextDocument: TextDocumentIdentifier{URI: uri}, Range: whole})
+			return err
+		},
+		"document symbols": func() error {
+			_, err := h.documentSymbols(ctx, uri)
+			return err
+		},
+		"control-flow graph": func() error {
+			_, err := h.executeCommand(ctx, nil, controlFlowGraphCommand, uri, nil)
+			return err
+		},
+		"call hierarchy": func() error {
+			_, err := h.prepareCallHierarchy(ctx, uri, &CallHierarchyPrepareParams{TextDocumentPositionParams: position})
+			return err
+		},
+	}
+	for name, request := range requests {
+		var rpcErr *jsonrpc2.Error
+		if err := request(); !errors.As(err, &rpcErr) || rpcErr.Code != CodeContentModified {
+			t.Errorf("%s error = %v, want content modified", name, err)
+		}
+	}
+}
@@ -0,0 +1,329 @@
+package lsp
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/miselin/c64lsp/pkg/analysis"
+	"github.com/miselin/c64lsp/pkg/grammar"
+	"github.com/sourcegraph/jsonrpc2"
+)
+
+// The outline is built from the shape of the program: each block of REM lines
+// starts a section running to the next block, and subroutines, DEF FNs, DIMs
+// and DATA are placed in the innermost section or subroutine they fall in. A
+// subroutine runs from its first line to its last RETURN, and blocks of REM
+// lines inside it are headings within it rather than new sections.
+
+// section is a block of REM header lines and the lines up to the next block.
+type section struct {
+	name string
+	// Indexes into Program.Lines: the first header line, the first line
+	// after the header block, and the last line of the section.
+	start, body, end int
+	// Set for a heading inside a subroutine.
+	nested bool
+}
+
+func (h *lspHandler) handleTextDocumentDocumentSymbol(ctx context.Context, conn *jsonrpc2.Conn, req *jsonrpc2.Request) (result any, err error) {
+	if req.Params == nil {
+		return nil, &jsonrpc2.Error{Code: jsonrpc2.CodeInvalidParams}
+	}
+
+	var params DocumentSymbolParams
+	if err := json.Unmarshal(*req.Params, &params); err != nil {
+		return nil, err
+	}
+
+	w := h.beginWorkDone(ctx, params.WorkDoneToken, "Outlining the document", nil)
+	defer w.end(ctx, "")
+
+	return h.documentSymbols(ctx, params.TextDocument.URI)
+}
+
+func (h *lspHandler) documentSymbols(ctx context.Context, uri DocumentURI) ([]DocumentSymbol, error) {
+	snap, err := h.parsedSnapshot(uri)
+	if err != nil {
+		return nil, err
+	}
+	return programSymbols(ctx, snap.analysis)
+}
+
+// programSymbols returns the outline of a program, or stops if ctx is
+// cancelled.
+func programSymbols(ctx context.Context, a *programAnalysis) ([]DocumentSymbol, error) {
+	parsed := a.program
+	symbols := []DocumentSymbol{}
+	if len(parsed.Lines) == 0 {
+		return symbols, nil
+	}
+
+	g, subs := a.CFG(), a.Subroutines()
+	ends := map[*analysis.Subroutine]int{}
+	for _, sub := range subs.All {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		ends[sub] = subroutineEnd(parsed, g, sub)
+	}
+	// enclosing finds the last line of the innermost subroutine around a
+	// line, other than one starting on it
+	enclosing := func(i int) (int, bool) {
+		inner, end := -1, 0
+		for sub, last := range ends {
+			if start := parsed.LineIndex(sub.Line); start < i && i <= last && start > inner {
+				inner, end = start, last
+			}
+		}
+		return end, inner >= 0
+	}
+
+	// a subroutine starting a section names it
+	starts := map[*analysis.Subroutine]bool{}
+	candidates := []DocumentSymbol{}
+	for _, sec := range findSections(parsed, enclosing) {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		sym := DocumentSymbol{
+			Name:           sec.name,
+			Detail:         labelSpan(parsed, sec.start, sec.end),
+			Kind:           NamespaceSymbol,
+			Range:          linesRange(parsed, sec.start, sec.end),
+			SelectionRange: lineRange(parsed, parsed.Lines[sec.start]),
+		}
+		for _, sub := range subs.All {
+			if i := parsed.LineIndex(sub.Line); !sec.nested && i >= sec.start && i <= sec.body && !starts[sub] {
+				starts[sub] = true
+				sym.Kind = FunctionSymbol
+				_, sym.Detail = subroutineName(parsed, sub)
+				sym.Range = linesRange(parsed, sec.start, max(ends[sub], sec.body-1))
+				sym.SelectionRange = labelRange(parsed, sub.Line)
+				break
+			}
+		}
+		candidates = append(candidates, sym)
+	}
+
+	for _, sub := range subs.All {
+		if !starts[sub] {
+			candidates = append(candidates, subroutineSymbol(parsed, sub, ends[sub]))
+		}
+	}
+	candidates = append(candidates, lineSymbols(parsed)...)
+
+	// place each symbol after anything containing it
+	sort.SliceStable(candidates, func(i, j int) bool {
+		a, b := candidates[i].Range, candidates[j].Range
+		if a.Start != b.Start {
+			return before(a.Start, b.Start)
+		}
+		return before(b.End, a.End)
+	})
+	for _, sym := range candidates {
+		symbols = nestSymbol(symbols, sym)
+	}
+
+	sortSymbols(symbols)
+	return symbols, nil
+}
+
+// findSections splits a program into sections at each block of REM lines with
+// some text in them. A block inside a subroutine, as found by enclosing, is a
+// heading running to the next block or the end of the subroutine.
+func findSections(parsed *grammar.Program, enclosing func(i int) (int, bool)) []section {
+	sections := []section{}
+	last := -1
+	for i := 0; i < len(parsed.Lines); i++ {
+		if parsed.Lines[i].Comment == nil {
+			continue
+		}
+
+		sec := section{start: i}
+		for sec.body = i; sec.body < len(parsed.Lines) && parsed.Lines[sec.body].Comment != nil; sec.body++ {
+			if sec.name == "" {
+				sec.name = analysis.RemText(parsed.Lines[sec.body])
+			}
+		}
+		i = sec.body - 1
+		if sec.name == "" {
+			// only decoration
+			continue
+		}
+
+		if len(sections) > 0 && sections[len(sections)-1].nested {
+			prev := &sections[len(sections)-1]
+			prev.end = min(prev.end, sec.start-1)
+		}
+		if end, ok := enclosing(sec.start); ok {
+			sec.nested = true
+			sec.end = end
+		} else {
+			if last >= 0 {
+				sections[last].end = sec.start - 1
+			}
+			last = len(sections)
+			sec.end = len(parsed.Lines) - 1
+		}
+		sections = append(sections, sec)
+	}
+	return sections
+}
+
+// subroutineEnd finds the last line of a subroutine: the line of its last
+// RETURN or, without one, the last of the lines following its first that it
+// runs.
+func subroutineEnd(parsed *grammar.Program, g *analysis.CFG, sub *analysis.Subroutine) int {
+	start := parsed.LineIndex(sub.Line)
+	end := -1
+	for _, ret := range sub.Returns {
+		if i := parsed.LineIndex(ret.Command.Line); i >= start && i > end {
+			end = i
+		}
+	}
+	if end >= 0 {
+		return end
+	}
+
+	end = start
+	for i := start + 1; i < len(parsed.Lines); i++ {
+		nodes := g.LineNodes(parsed.Lines[i])
+		if len(nodes) == 0 || !sub.Body[nodes[0]] {
+			break
+		}
+		end = i
+	}
+	return end
+}
+
+// subroutineSymbol describes a subroutine that doesn't start a section,
+// running from its first line to its last.
+func subroutineSymbol(parsed *grammar.Program, sub *analysis.Subroutine, end int) DocumentSymbol {
+	name, detail := subroutineName(parsed, sub)
+	return DocumentSymbol{
+		Name:           name,
+		Detail:         detail,
+		Kind:           FunctionSymbol,
+		Range:          linesRange(parsed, parsed.LineIndex(sub.Line), end),
+		SelectionRange: labelRange(parsed, sub.Line),
+	}
+}
+
+// lineSymbols returns the DEF FN functions, DIM arrays and DATA blocks in a
+// program, in source order.
+func lineSymbols(parsed *grammar.Program) []DocumentSymbol {
+	symbols := []DocumentSymbol{}
+
+	// consecutive lines with DATA are a single block
+	data, values := -1, 0
+	endData := func(end int) {
+		if data < 0 {
+			return
+		}
+		symbols = append(symbols, DocumentSymbol{
+			Name:           "DATA",
+			Detail:         fmt.Sprintf("%d values, %s", values, labelSpan(parsed, data, end)),
+			Kind:           ConstantSymbol,
+			Range:          linesRange(parsed, data, end),
+			SelectionRange: labelRange(parsed, parsed.Lines[data]),
+		})
+		data, values = -1, 0
+	}
+
+	for i, line := range parsed.Lines {
+		hasData := false
+		for _, cmd := range analysis.LineCommands(line) {
+			switch cmd.Keyword {
+			case "DATA":
+				hasData = true
+				values += len(analysis.SplitArgs(cmd.Args))
+			case "DEF":
+				if sym, ok := defSymbol(parsed, cmd); ok {
+					symbols = append(symbols, sym)
+				}
+			case "DIM":
+				symbols = append(symbols, dimSymbols(parsed, cmd)...)
+			}
+		}
+		if hasData && data < 0 {
+			data = i
+		} else if !hasData {
+			endData(i - 1)
+		}
+	}
+	endData(len(parsed.Lines) - 1)
+
+	return symbols
+}
+
+// defSymbol describes a DEF FN name(param) = body statement.
+func defSymbol(parsed *grammar.Program, cmd *analysis.Command) (DocumentSymbol, bool) {
+	args := cmd.Args
+	if len(args) < 2 || !args[0].IsKeyword("FN") || args[1].VariableName() == "" {
+		return DocumentSymbol{}, false
+	}
+
+	return DocumentSymbol{
+		Name:           "FN " + strings.ToUpper(args[1].VariableName()),
+		Detail:         "DEF " + parsed.TokensText(args),
+		Kind:           FunctionSymbol,
+		Range:          commandRange(parsed, cmd),
+		SelectionRange: tokenRange(parsed, args[1]),
+	}, true
+}
+
+// dimSymbols describes each variable declared by a DIM statement.
+func dimSymbols(parsed *grammar.Program, cmd *analysis.Command) []DocumentSymbol {
+	symbols := []DocumentSymbol{}
+	for _, arg := range analysis.SplitArgs(cmd.Args) {
+		if arg[0].VariableName() == "" {
+			continue
+		}
+		kind := VariableSymbol
+		if len(arg) > 1 && arg[1].Value != nil && arg[1].Value.Subexpression != nil {
+			kind = ArraySymbol
+		}
+		symbols = append(symbols, DocumentSymbol{
+			Name:           strings.ToUpper(arg[0].VariableName()),
+			Detail:         "DIM " + parsed.TokensText(arg),
+			Kind:           kind,
+			Range:          Range{Start: tokenRange(parsed, arg[0]).Start, End: tokenRange(parsed, arg[len(arg)-1]).End},
+			SelectionRange: tokenRange(parsed, arg[0]),
+		})
+	}
+	return symbols
+}
+
+// nestSymbol adds a symbol to the innermost symbol containing it, or to the
+// top level.
+func nestSymbol(symbols []DocumentSymbol, sym DocumentSymbol) []DocumentSymbol {
+	for i := range symbols {
+		if contains(symbols[i].Range, sym.Range) {
+			symbols[i].Children = nestSymbol(symbols[i].Children, sym)
+			return symbols
+		}
+	}
+	return append(symbols, sym)
+}
+
+// sortSymbols puts symbols and their children in source order.
+func sortSymbols(symbols []DocumentSymbol) {
+	sort.SliceStable(symbols, func(i, j int) bool {
+		return before(symbols[i].Range.Start, symbols[j].Range.Start)
+	})
+	for _, sym := range symbols {
+		sortSymbols(sym.Children)
+	}
+}
+
+// labelSpan describes the line numbers of a run of lines, e.g. "lines
+// 200-290".
+func labelSpan(parsed *grammar.Program, start, end int) string {
+	if start == end {
+		return fmt.Sprintf("line %d", parsed.Lines[start].Label)
+	}
+	return fmt.Sprintf("lines %d-%d", parsed.Lines[start].Label, parsed.Lines[end].Label)
+}
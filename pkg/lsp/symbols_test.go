@@ -0,0 +1,60 @@
+package lsp
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// outline describes symbols as indented lines of their name, detail and the
+// lines they cover.
+func outline(symbols []DocumentSymbol, depth int) []string {
+	found := []string{}
+	for _, sym := range symbols {
+		found = append(found, fmt.Sprintf("%s%s [%s] %d-%d", strings.Repeat("  ", depth), sym.Name, sym.Detail, sym.Range.Start.Line, sym.Range.End.Line))
+		found = append(found, outline(sym.Children, depth+1)...)
+	}
+	return found
+}
+
+func TestProgramSymbols(t *testing.T) {
+	tests := []struct {
+		name string
+		code string
+		want []string
+	}{
+		{"no REMs", "10 PRINT\n20 END\n", []string{}},
+		{"sections", "10 REM SETUP\n20 DIM A(10)\n30 REM MAIN LOOP\n40 GOTO 40\n", []string{
+			"SETUP [lines 10-20] 0-1",
+			"  A [DIM A(10)] 1-1",
+			"MAIN LOOP [lines 30-40] 2-3",
+		}},
+		{"subroutines", "10 GOSUB 100:GOSUB 200:END\n90 REM DRAW\n100 PRINT\n110 RETURN\n200 IF A THEN RETURN\n210 PRINT:RETURN\n", []string{
+			"DRAW [GOSUB 100] 1-3",
+			"GOSUB 200 [] 4-5",
+		}},
+		{"heading in a subroutine", "10 GOSUB 100:END\n100 REM INPUT\n110 GET K$\n120 REM CHECK KEY\n130 IF K$=\"\" THEN 110\n140 RETURN\n", []string{
+			"INPUT [GOSUB 100] 1-5",
+			"  CHECK KEY [lines 120-140] 3-5",
+		}},
+		{"DEF FN and DATA", "10 REM TABLES\n20 DEF FN SQ(X)=X*X\n30 DATA 1,2,3\n", []string{
+			"TABLES [lines 10-30] 0-2",
+			"  FN SQ [DEF FN SQ(X)=X*X] 1-1",
+			"  DATA [3 values, line 30] 2-2",
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			symbols, err := programSymbols(context.Background(), analyse(t, tt.code))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := outline(symbols, 0); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("outline =\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(tt.want, "\n"))
+			}
+		})
+	}
+}
@@ -1,5 +1,11 @@
 package lsp
 
+import (
+	"encoding/json"
+
+	"github.com/sourcegraph/jsonrpc2"
+)
+
 // Golang structs and definitions for types defined by the Lanaguage Server Protocol
 // https://microsoft.github.io/language-server-protocol/specifications/lsp/3.17/specification/
 
@@ -7,8 +13,20 @@ type File struct {
 	LanguageID string
 	Text       string
 	Version    int
+	// Set when changes from the client couldn't be applied, so Text may not
+	// match the editor.
+	SyncError string
 }
 
+// LSP error codes, beyond those of JSON-RPC.
+const (
+	// CodeContentModified means the document changed while a request ran,
+	// so its result would be out of date.
+	CodeContentModified = -32801
+	// CodeRequestCancelled means the client cancelled a request.
+	CodeRequestCancelled = -32800
+)
+
 // DocumentURI specifies the URI for a document.
 type DocumentURI string
 
@@ -18,11 +36,30 @@ type InitializeParams struct {
 	RootURI      DocumentURI        `json:"rootUri,omitempty"`
 	Capabilities ClientCapabilities `json:"capabilities,omitempty"`
 	Trace        string             `json:"trace,omitempty"`
+	// The server's settings, as in the "c64lsp" configuration section.
+	InitializationOptions json.RawMessage `json:"initializationOptions,omitempty"`
+
+	WorkspaceFolders []WorkspaceFolder `json:"workspaceFolders,omitempty"`
+}
+
+// WorkspaceFolder is a folder open in the client.
+type WorkspaceFolder struct {
+	URI  DocumentURI `json:"uri"`
+	Name string      `json:"name"`
 }
 
 // ClientCapabilities outlines the capabilities that a language server client supports.
 // Largely ignored by this language server implementation.
-type ClientCapabilities struct{}
+type ClientCapabilities struct {
+	Window WindowClientCapabilities `json:"window,omitempty"`
+}
+
+// WindowClientCapabilities outlines the window features a client supports.
+type WindowClientCapabilities struct {
+	// Whether the server may create progress tokens with
+	// window/workDoneProgress/create.
+	WorkDoneProgress bool `json:"workDoneProgress,omitempty"`
+}
 
 // InitializeResult is sent by the langauge server when it has finished initializing.
 type InitializeResult struct {
@@ -49,10 +86,34 @@ type CompletionOptions struct {
 
 // ServerCapabilities defines the capabilities of the language server.
 type ServerCapabilities struct {
-	TextDocumentSync   TextDocumentSyncKind `json:"textDocumentSync,omitempty"`
-	CompletionProvider *CompletionOptions   `json:"completionProvider,omitempty"`
-	DefinitionProvider bool                 `json:"definitionProvider,omitempty"`
-	HoverProvider      bool                 `json:"hoverProvider,omitempty"`
+	TextDocumentSync          TextDocumentSyncKind    `json:"textDocumentSync,omitempty"`
+	CompletionProvider        *CompletionOptions      `json:"completionProvider,omitempty"`
+	DefinitionProvider        bool                    `json:"definitionProvider,omitempty"`
+	HoverProvider             bool                    `json:"hoverProvider,omitempty"`
+	InlayHintProvider         bool                    `json:"inlayHintProvider,omitempty"`
+	CodeActionProvider        bool                    `json:"codeActionProvider,omitempty"`
+	DocumentHighlightProvider bool                    `json:"documentHighlightProvider,omitempty"`
+	CallHierarchyProvider     bool                    `json:"callHierarchyProvider,omitempty"`
+	DocumentSymbolProvider    bool                    `json:"documentSymbolProvider,omitempty"`
+	WorkspaceSymbolProvider   *WorkspaceSymbolOptions `json:"workspaceSymbolProvider,omitempty"`
+	SemanticTokensProvider    *SemanticTokensOptions  `json:"semanticTokensProvider,omitempty"`
+	ExecuteCommandProvider    *ExecuteCommandOptions  `json:"executeCommandProvider,omitempty"`
+}
+
+// WorkDoneProgressOptions says whether the server reports the progress of a
+// request with the token the client gives it.
+type WorkDoneProgressOptions struct {
+	WorkDoneProgress bool `json:"workDoneProgress,omitempty"`
+}
+
+// WorkspaceSymbolOptions configures workspace symbol search.
+type WorkspaceSymbolOptions struct {
+	WorkDoneProgressOptions
+}
+
+// ExecuteCommandOptions lists the commands the server can execute.
+type ExecuteCommandOptions struct {
+	Commands []string `json:"commands"`
 }
 
 // TextDocumentItem is an item to transfer a text document from the client to the server.
@@ -85,9 +146,10 @@ type DidCloseTextDocumentParams struct {
 }
 
 // TextDocumentContentChangeEvent defines a change to a text document.
+// The change replaces the whole document if it has no range.
 type TextDocumentContentChangeEvent struct {
-	Range       Range  `json:"range"`
-	RangeLength int    `json:"rangeLength"`
+	Range       *Range `json:"range,omitempty"`
+	RangeLength int    `json:"rangeLength,omitempty"`
 	Text        string `json:"text"`
 }
 
@@ -113,6 +175,7 @@ type TextDocumentPositionParams struct {
 type CompletionParams struct {
 	TextDocumentPositionParams
 	CompletionContext CompletionContext `json:"contentChanges"`
+	WorkDoneProgressParams
 }
 
 // CompletionContext defines the context for a completion request.
@@ -124,6 +187,7 @@ type CompletionContext struct {
 // HoverParams defines parameters to be sent when requesting hover information.
 type HoverParams struct {
 	TextDocumentPositionParams
+	WorkDoneProgressParams
 }
 
 // Location defines a location in a document.
@@ -201,7 +265,7 @@ type CompletionItem struct {
 	Kind                CompletionItemKind  `json:"kind,omitempty"`
 	Tags                []CompletionItemTag `json:"tags,omitempty"`
 	Detail              string              `json:"detail,omitempty"`
-	Documentation       string              `json:"documentation,omitempty"` // string | MarkupContent
+	Documentation       any                 `json:"documentation,omitempty"` // string | MarkupContent
 	Deprecated          bool                `json:"deprecated,omitempty"`
 	Preselect           bool                `json:"preselect,omitempty"`
 	SortText            string              `json:"sortText,omitempty"`
@@ -247,7 +311,400 @@ type DidChangeConfigurationParams struct {
 	Settings any `json:"settings"`
 }
 
+// FileChangeType defines how a watched file changed.
+type FileChangeType int
+
+const (
+	// FileCreated is a new file.
+	FileCreated FileChangeType = 1
+	// FileChanged is a file whose contents changed.
+	FileChanged FileChangeType = 2
+	// FileDeleted is a file that was deleted.
+	FileDeleted FileChangeType = 3
+)
+
+// FileEvent describes a change to a watched file.
+type FileEvent struct {
+	URI  DocumentURI    `json:"uri"`
+	Type FileChangeType `json:"type"`
+}
+
+// DidChangeWatchedFilesParams defines parameters sent from the client when watched files change.
+type DidChangeWatchedFilesParams struct {
+	Changes []FileEvent `json:"changes"`
+}
+
+// MessageType defines the kind of a message shown to the user.
+type MessageType int
+
+const (
+	// MessageError is an error message.
+	MessageError MessageType = 1
+	// MessageWarning is a warning message.
+	MessageWarning MessageType = 2
+	// MessageInfo is an information message.
+	MessageInfo MessageType = 3
+	// MessageLog is a log message.
+	MessageLog MessageType = 4
+)
+
+// ShowMessageParams defines parameters sent from the server to show a message to the user.
+type ShowMessageParams struct {
+	Type    MessageType `json:"type"`
+	Message string      `json:"message"`
+}
+
 // DocumentDefinitionParams defines parameters sent from the client when requesting a document definition.
 type DocumentDefinitionParams struct {
 	TextDocumentPositionParams
+	WorkDoneProgressParams
+}
+
+// DocumentHighlightParams defines parameters sent from the client when requesting document highlights.
+type DocumentHighlightParams struct {
+	TextDocumentPositionParams
+	WorkDoneProgressParams
+}
+
+// DocumentHighlightKind defines the kind of a document highlight.
+type DocumentHighlightKind int
+
+const (
+	// TextHighlight is a textual occurrence.
+	TextHighlight DocumentHighlightKind = 1
+	// ReadHighlight is a read of a symbol.
+	ReadHighlight DocumentHighlightKind = 2
+	// WriteHighlight is a write to a symbol.
+	WriteHighlight DocumentHighlightKind = 3
+)
+
+// DocumentHighlight is a range in a document related to the symbol at a position.
+type DocumentHighlight struct {
+	Range Range                 `json:"range"`
+	Kind  DocumentHighlightKind `json:"kind,omitempty"`
+}
+
+// InlayHintParams defines parameters sent from the client when requesting inlay hints.
+type InlayHintParams struct {
+	TextDocument TextDocumentIdentifier `json:"textDocument"`
+	Range        Range                  `json:"range"`
+	WorkDoneProgressParams
+}
+
+// InlayHintKind defines the kind of an inlay hint.
+type InlayHintKind int
+
+const (
+	// TypeHint annotates a type.
+	TypeHint InlayHintKind = 1
+	// ParameterHint annotates a parameter.
+	ParameterHint InlayHintKind = 2
+)
+
+// InlayHint is a label shown inline in the document.
+type InlayHint struct {
+	Position     Position      `json:"position"`
+	Label        string        `json:"label"`
+	Kind         InlayHintKind `json:"kind,omitempty"`
+	Tooltip      string        `json:"tooltip,omitempty"`
+	PaddingLeft  bool          `json:"paddingLeft,omitempty"`
+	PaddingRight bool          `json:"paddingRight,omitempty"`
+}
+
+// DiagnosticSeverity defines how serious a diagnostic is.
+type DiagnosticSeverity int
+
+const (
+	// SeverityError reports an error.
+	SeverityError DiagnosticSeverity = 1
+	// SeverityWarning reports a warning.
+	SeverityWarning DiagnosticSeverity = 2
+	// SeverityInformation reports information.
+	SeverityInformation DiagnosticSeverity = 3
+	// SeverityHint reports a hint.
+	SeverityHint DiagnosticSeverity = 4
+)
+
+// DiagnosticTag adds metadata to a diagnostic, which clients may use to style it.
+type DiagnosticTag int
+
+const (
+	// Unnecessary marks unused or unreachable code, which clients may fade out.
+	Unnecessary DiagnosticTag = 1
+	// Deprecated marks deprecated or obsolete code, which clients may strike through.
+	Deprecated DiagnosticTag = 2
+)
+
+// Diagnostic is a problem found in a document, such as a compiler error or warning.
+type Diagnostic struct {
+	Range    Range              `json:"range"`
+	Severity DiagnosticSeverity `json:"severity,omitempty"`
+	Code     string             `json:"code,omitempty"`
+	Source   string             `json:"source,omitempty"`
+	Message  string             `json:"message"`
+	Tags     []DiagnosticTag    `json:"tags,omitempty"`
+}
+
+// PublishDiagnosticsParams defines parameters sent from the server to report diagnostics for a document.
+type PublishDiagnosticsParams struct {
+	URI         DocumentURI  `json:"uri"`
+	Version     *int         `json:"version,omitempty"`
+	Diagnostics []Diagnostic `json:"diagnostics"`
+}
+
+// CodeActionKind defines the kind of a code action, as a dotted hierarchy.
+type CodeActionKind string
+
+const (
+	// QuickFix is a fix for a problem.
+	QuickFix CodeActionKind = "quickfix"
+	// Refactor is any refactoring.
+	Refactor CodeActionKind = "refactor"
+	// RefactorRewrite rewrites code without changing its meaning.
+	RefactorRewrite CodeActionKind = "refactor.rewrite"
+	// SourceAction applies to a whole document.
+	SourceAction CodeActionKind = "source"
+)
+
+// CodeActionContext carries additional information about a code action request.
+type CodeActionContext struct {
+	Diagnostics []Diagnostic     `json:"diagnostics"`
+	Only        []CodeActionKind `json:"only,omitempty"`
+}
+
+// CodeActionParams defines parameters sent from the client when requesting code actions.
+type CodeActionParams struct {
+	TextDocument TextDocumentIdentifier `json:"textDocument"`
+	Range        Range                  `json:"range"`
+	Context      CodeActionContext      `json:"context"`
+	WorkDoneProgressParams
+}
+
+// WorkspaceEdit defines changes to be made to documents in the workspace.
+type WorkspaceEdit struct {
+	Changes map[DocumentURI][]TextEdit `json:"changes,omitempty"`
+}
+
+// CodeAction is a change that can be applied to a document.
+type CodeAction struct {
+	Title       string         `json:"title"`
+	Kind        CodeActionKind `json:"kind,omitempty"`
+	Diagnostics []Diagnostic   `json:"diagnostics,omitempty"`
+	IsPreferred bool           `json:"isPreferred,omitempty"`
+	Edit        *WorkspaceEdit `json:"edit,omitempty"`
+	Command     *Command       `json:"command,omitempty"`
+}
+
+// Command is a reference to a command the client can ask the server to execute.
+type Command struct {
+	Title     string `json:"title"`
+	Command   string `json:"command"`
+	Arguments []any  `json:"arguments,omitempty"`
+}
+
+// ExecuteCommandParams defines parameters sent from the client to execute a command.
+type ExecuteCommandParams struct {
+	Command   string            `json:"command"`
+	Arguments []json.RawMessage `json:"arguments,omitempty"`
+	WorkDoneProgressParams
+}
+
+// ApplyWorkspaceEditParams defines parameters sent from the server to ask the client to apply an edit.
+type ApplyWorkspaceEditParams struct {
+	Label string        `json:"label,omitempty"`
+	Edit  WorkspaceEdit `json:"edit"`
+}
+
+// ApplyWorkspaceEditResult is the client's response to a workspace/applyEdit request.
+type ApplyWorkspaceEditResult struct {
+	Applied       bool   `json:"applied"`
+	FailureReason string `json:"failureReason,omitempty"`
+}
+
+// SymbolKind defines the kind of a symbol.
+type SymbolKind int
+
+// See https://microsoft.github.io/language-server-protocol/specifications/lsp/3.17/specification/#symbolKind
+const (
+	FileSymbol          SymbolKind = 1
+	ModuleSymbol        SymbolKind = 2
+	NamespaceSymbol     SymbolKind = 3
+	PackageSymbol       SymbolKind = 4
+	ClassSymbol         SymbolKind = 5
+	MethodSymbol        SymbolKind = 6
+	PropertySymbol      SymbolKind = 7
+	FieldSymbol         SymbolKind = 8
+	ConstructorSymbol   SymbolKind = 9
+	EnumSymbol          SymbolKind = 10
+	InterfaceSymbol     SymbolKind = 11
+	FunctionSymbol      SymbolKind = 12
+	VariableSymbol      SymbolKind = 13
+	ConstantSymbol      SymbolKind = 14
+	StringSymbol        SymbolKind = 15
+	NumberSymbol        SymbolKind = 16
+	BooleanSymbol       SymbolKind = 17
+	ArraySymbol         SymbolKind = 18
+	ObjectSymbol        SymbolKind = 19
+	KeySymbol           SymbolKind = 20
+	NullSymbol          SymbolKind = 21
+	EnumMemberSymbol    SymbolKind = 22
+	StructSymbol        SymbolKind = 23
+	EventSymbol         SymbolKind = 24
+	OperatorSymbol      SymbolKind = 25
+	TypeParameterSymbol SymbolKind = 26
+)
+
+// CallHierarchyPrepareParams defines parameters sent from the client to find call hierarchy items at a position.
+type CallHierarchyPrepareParams struct {
+	TextDocumentPositionParams
+	WorkDoneProgressParams
+}
+
+// CallHierarchyItem is a function-like item in a call hierarchy.
+type CallHierarchyItem struct {
+	Name           string      `json:"name"`
+	Kind           SymbolKind  `json:"kind"`
+	Detail         string      `json:"detail,omitempty"`
+	URI            DocumentURI `json:"uri"`
+	Range          Range       `json:"range"`
+	SelectionRange Range       `json:"selectionRange"`
+	Data           any         `json:"data,omitempty"`
+}
+
+// CallHierarchyIncomingCallsParams defines parameters sent from the client to find calls to an item.
+type CallHierarchyIncomingCallsParams struct {
+	Item CallHierarchyItem `json:"item"`
+	WorkDoneProgressParams
+}
+
+// CallHierarchyIncomingCall is a call to an item.
+type CallHierarchyIncomingCall struct {
+	From       CallHierarchyItem `json:"from"`
+	FromRanges []Range           `json:"fromRanges"`
+}
+
+// CallHierarchyOutgoingCallsParams defines parameters sent from the client to find calls made by an item.
+type CallHierarchyOutgoingCallsParams struct {
+	Item CallHierarchyItem `json:"item"`
+	WorkDoneProgressParams
+}
+
+// CallHierarchyOutgoingCall is a call made by an item.
+type CallHierarchyOutgoingCall struct {
+	To         CallHierarchyItem `json:"to"`
+	FromRanges []Range           `json:"fromRanges"`
+}
+
+// DocumentSymbolParams defines parameters sent from the client to find the symbols in a document.
+type DocumentSymbolParams struct {
+	TextDocument TextDocumentIdentifier `json:"textDocument"`
+	WorkDoneProgressParams
+}
+
+// DocumentSymbol is a symbol in a document, with the symbols it contains.
+type DocumentSymbol struct {
+	Name           string           `json:"name"`
+	Detail         string           `json:"detail,omitempty"`
+	Kind           SymbolKind       `json:"kind"`
+	Range          Range            `json:"range"`
+	SelectionRange Range            `json:"selectionRange"`
+	Children       []DocumentSymbol `json:"children,omitempty"`
+}
+
+// WorkspaceSymbolParams defines parameters sent from the client to search for symbols across the workspace.
+type WorkspaceSymbolParams struct {
+	Query string `json:"query"`
+	WorkDoneProgressParams
+}
+
+// SymbolInformation is a symbol found by a workspace symbol search.
+type SymbolInformation struct {
+	Name          string     `json:"name"`
+	Kind          SymbolKind `json:"kind"`
+	Location      Location   `json:"location"`
+	ContainerName string     `json:"containerName,omitempty"`
+}
+
+// SemanticTokensLegend names the token types and modifiers used in semantic tokens, by their index.
+type SemanticTokensLegend struct {
+	TokenTypes     []string `json:"tokenTypes"`
+	TokenModifiers []string `json:"tokenModifiers"`
+}
+
+// SemanticTokensOptions defines the semantic tokens the server provides.
+type SemanticTokensOptions struct {
+	Legend SemanticTokensLegend `json:"legend"`
+	Range  bool                 `json:"range,omitempty"`
+	Full   bool                 `json:"full,omitempty"`
+}
+
+// SemanticTokensParams defines parameters sent from the client to get the semantic tokens of a document.
+type SemanticTokensParams struct {
+	TextDocument TextDocumentIdentifier `json:"textDocument"`
+	WorkDoneProgressParams
+}
+
+// SemanticTokensRangeParams defines parameters sent from the client to get the semantic tokens in part of a document.
+type SemanticTokensRangeParams struct {
+	TextDocument TextDocumentIdentifier `json:"textDocument"`
+	Range        Range                  `json:"range"`
+	WorkDoneProgressParams
+}
+
+// SemanticTokens holds semantic tokens, each encoded as five integers relative to the previous token.
+type SemanticTokens struct {
+	Data []uint32 `json:"data"`
+}
+
+// CancelParams defines parameters sent from the client to cancel a request.
+type CancelParams struct {
+	ID jsonrpc2.ID `json:"id"`
+}
+
+// ProgressToken identifies a stream of progress notifications. It is either
+// an integer or a string.
+type ProgressToken any
+
+// ProgressParams defines parameters sent from the server to report progress.
+type ProgressParams struct {
+	Token ProgressToken `json:"token"`
+	Value any           `json:"value"`
+}
+
+// WorkDoneProgressParams carries a token the client made for the server to report the progress of a request with.
+type WorkDoneProgressParams struct {
+	WorkDoneToken ProgressToken `json:"workDoneToken,omitempty"`
+}
+
+// WorkDoneProgressCreateParams defines parameters sent from the server to create a progress token.
+type WorkDoneProgressCreateParams struct {
+	Token ProgressToken `json:"token"`
+}
+
+// WorkDoneProgressCancelParams defines parameters sent from the client to cancel an operation reporting progress.
+type WorkDoneProgressCancelParams struct {
+	Token ProgressToken `json:"token"`
+}
+
+// WorkDoneProgressBegin starts reporting the progress of an operation.
+type WorkDoneProgressBegin struct {
+	Kind        string `json:"kind"`
+	Title       string `json:"title"`
+	Cancellable bool   `json:"cancellable,omitempty"`
+	Message     string `json:"message,omitempty"`
+	Percentage  *int   `json:"percentage,omitempty"`
+}
+
+// WorkDoneProgressReport reports the progress of an operation.
+type WorkDoneProgressReport struct {
+	Kind        string `json:"kind"`
+	Cancellable bool   `json:"cancellable,omitempty"`
+	Message     string `json:"message,omitempty"`
+	Percentage  *int   `json:"percentage,omitempty"`
+}
+
+// WorkDoneProgressEnd finishes reporting the progress of an operation.
+type WorkDoneProgressEnd struct {
+	Kind    string `json:"kind"`
+	Message string `json:"message,omitempty"`
 }
@@ -0,0 +1,91 @@
+package lsp
+
+import (
+	"strings"
+
+	"github.com/miselin/c64lsp/pkg/analysis"
+	"github.com/miselin/c64lsp/pkg/reference"
+)
+
+// convertToMnemonics rewrites every Unicode graphic in strings and REMs as a
+// mnemonic.
+func convertToMnemonics(text string) []TextEdit {
+	return convertSource(text, func(contents string) string {
+		return analysis.GlyphsToMnemonics(contents, reference.PetcatStyle)
+	})
+}
+
+// convertToUnicode rewrites every mnemonic with a glyph in strings and REMs as
+// a Unicode character.
+func convertToUnicode(text string, set reference.GlyphSet) []TextEdit {
+	return convertSource(text, func(contents string) string {
+		return analysis.MnemonicsToGlyphs(contents, set)
+	})
+}
+
+// convertSource applies a conversion to the contents of every string and REM
+// in a document, returning an edit for each line that changes. It works from
+// the text so that documents which don't parse can still be converted.
+func convertSource(text string, convert func(string) string) []TextEdit {
+	edits := []TextEdit{}
+	for i, line := range strings.Split(text, "\n") {
+		line = strings.TrimSuffix(line, "\r")
+		converted := convertLine(line, convert)
+		if converted == line {
+			continue
+		}
+		edits = append(edits, TextEdit{
+			Range: Range{
+				Start: Position{Line: i, Character: 0},
+				End:   Position{Line: i, Character: utf16Len(line)},
+			},
+			NewText: converted,
+		})
+	}
+	return edits
+}
+
+// convertLine applies a conversion to the strings and REM of a single line.
+func convertLine(line string, convert func(string) string) string {
+	var sb strings.Builder
+	for len(line) > 0 {
+		quote := strings.IndexByte(line, '"')
+		rem := indexFold(line, "rem")
+		if rem >= 0 && (quote < 0 || rem < quote) {
+			// everything after REM is a comment
+			sb.WriteString(line[:rem+3])
+			sb.WriteString(convert(line[rem+3:]))
+			break
+		}
+		if quote < 0 {
+			sb.WriteString(line)
+			break
+		}
+
+		sb.WriteString(line[:quote+1])
+		line = line[quote+1:]
+
+		// strings may be left open at the end of a line
+		end := strings.IndexByte(line, '"')
+		if end < 0 {
+			end = len(line)
+		}
+		sb.WriteString(convert(line[:end]))
+		line = line[end:]
+		if len(line) > 0 {
+			sb.WriteByte('"')
+			line = line[1:]
+		}
+	}
+	return sb.String()
+}
+
+// indexFold is strings.Index ignoring ASCII case.
+func indexFold(s, substr string) int {
+	for i := 0; i+len(substr) <= len(s); i++ {
+		if strings.EqualFold(s[i:i+len(substr)], substr) {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,306 @@
+package lsp
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/miselin/c64lsp/pkg/grammar"
+	"github.com/rs/zerolog"
+	"github.com/sourcegraph/jsonrpc2"
+)
+
+// symbolIndex holds the symbols of every BASIC program in the workspace. It is
+// filled in the background, so unlike the rest of the handler it has its own
+// lock.
+type symbolIndex struct {
+	mu      sync.Mutex
+	symbols map[DocumentURI][]SymbolInformation
+	// Files changed since their symbols were last found, which are found
+	// again on the next search rather than on every change.
+	stale map[DocumentURI]*programAnalysis
+}
+
+// update replaces the symbols of a file with those of a new version.
+func (idx *symbolIndex) update(uri DocumentURI, a *programAnalysis) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	if idx.symbols == nil {
+		idx.symbols = map[DocumentURI][]SymbolInformation{}
+		idx.stale = map[DocumentURI]*programAnalysis{}
+	}
+	idx.symbols[uri] = nil
+	idx.stale[uri] = a
+}
+
+// add sets the symbols of a file unless it has already been indexed, e.g.
+// because it was opened while the workspace was being indexed.
+func (idx *symbolIndex) add(uri DocumentURI, symbols []SymbolInformation) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	if idx.symbols == nil {
+		idx.symbols = map[DocumentURI][]SymbolInformation{}
+		idx.stale = map[DocumentURI]*programAnalysis{}
+	}
+	if _, ok := idx.symbols[uri]; !ok {
+		idx.symbols[uri] = symbols
+	}
+}
+
+func (idx *symbolIndex) remove(uri DocumentURI) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	delete(idx.symbols, uri)
+	delete(idx.stale, uri)
+}
+
+// search returns the symbols matching a query, ordered by file. Most of the
+// time goes on finding the symbols of files changed since the last search,
+// which is what progress is reported for. That is done without holding the
+// lock, so that changes to open documents aren't held up by a search.
+func (idx *symbolIndex) search(ctx context.Context, query string, w *workDone) ([]SymbolInformation, error) {
+	idx.mu.Lock()
+	symbols := make(map[DocumentURI][]SymbolInformation, len(idx.symbols))
+	for uri, syms := range idx.symbols {
+		symbols[uri] = syms
+	}
+	stale := make(map[DocumentURI]*programAnalysis, len(idx.stale))
+	for uri, a := range idx.stale {
+		stale[uri] = a
+	}
+	idx.mu.Unlock()
+
+	total, done := len(stale), 0
+	for uri, a := range stale {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		syms, err := workspaceSymbols(ctx, uri, a)
+		if err != nil {
+			return nil, err
+		}
+		symbols[uri] = syms
+
+		// keep them unless the file changed again or went away meanwhile
+		idx.mu.Lock()
+		if idx.stale[uri] == a {
+			idx.symbols[uri] = syms
+			delete(idx.stale, uri)
+		}
+		idx.mu.Unlock()
+
+		done++
+		w.report(ctx, done, total, fmt.Sprintf("%d/%d changed files", done, total))
+	}
+
+	uris := []DocumentURI{}
+	for uri := range symbols {
+		uris = append(uris, uri)
+	}
+	sort.Slice(uris, func(i, j int) bool { return uris[i] < uris[j] })
+
+	found := []SymbolInformation{}
+	for _, uri := range uris {
+		for _, sym := range symbols[uri] {
+			if fuzzyMatch(sym.Name, query) {
+				found = append(found, sym)
+			}
+		}
+	}
+	return found, nil
+}
+
+// fuzzyMatch checks if the characters of a query appear in order in a name,
+// ignoring case.
+func fuzzyMatch(name, query string) bool {
+	name = strings.ToLower(name)
+	for _, r := range strings.ToLower(query) {
+		if unicode.IsSpace(r) {
+			continue
+		}
+		i := strings.IndexRune(name, r)
+		if i < 0 {
+			return false
+		}
+		name = name[i+utf8.RuneLen(r):]
+	}
+	return true
+}
+
+func (h *lspHandler) handleWorkspaceSymbol(ctx context.Context, conn *jsonrpc2.Conn, req *jsonrpc2.Request) (result any, err error) {
+	if req.Params == nil {
+		return nil, &jsonrpc2.Error{Code: jsonrpc2.CodeInvalidParams}
+	}
+
+	var params WorkspaceSymbolParams
+	if err := json.Unmarshal(*req.Params, &params); err != nil {
+		return nil, err
+	}
+
+	w := h.beginWorkDone(ctx, params.WorkDoneToken, "Searching workspace symbols", nil)
+	found, err := h.index.search(ctx, params.Query, w)
+	w.end(ctx, "")
+	return found, err
+}
+
+// isBasicFile checks if a path is a BASIC listing or a tokenised program.
+func isBasicFile(path string) bool {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".bas", ".prg":
+		return true
+	}
+	return false
+}
+
+// parseFile reads and parses a BASIC program from disk, listing it first if
+// it is tokenised.
+func parseFile(g *grammar.BasicGrammar, path string) (*grammar.Program, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	text := string(data)
+	if strings.EqualFold(filepath.Ext(path), ".prg") {
+		if text, err = grammar.Detokenize(data); err != nil {
+			return nil, err
+		}
+	}
+
+	return g.Parse(path, text)
+}
+
+// indexWorkspace adds the symbols of every BASIC program under the workspace
+// folders to the index. Hidden directories such as .git are skipped. The
+// client is shown its progress, and can cancel it.
+func (h *lspHandler) indexWorkspace(ctx context.Context, folders []string) {
+	logger := zerolog.Ctx(ctx)
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	paths := []string{}
+	for _, folder := range folders {
+		filepath.WalkDir(folder, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				logger.Debug().Msgf("indexing %s: %v", path, err)
+				return nil
+			}
+			if d.IsDir() {
+				if path != folder && strings.HasPrefix(d.Name(), ".") {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if isBasicFile(path) {
+				paths = append(paths, path)
+			}
+			return nil
+		})
+	}
+
+	w := h.beginWorkDone(ctx, nil, "Indexing BASIC programs", cancel)
+
+	// the handler's grammar isn't safe to share with this goroutine
+	g := grammar.NewGrammar()
+	count := 0
+	for i, path := range paths {
+		if ctx.Err() != nil {
+			logger.Debug().Msgf("indexing cancelled after %d of %d BASIC programs", i, len(paths))
+			w.end(ctx, "Cancelled")
+			return
+		}
+
+		w.report(ctx, i, len(paths), filepath.Base(path))
+		program, err := parseFile(&g, path)
+		if err != nil {
+			logger.Debug().Msgf("indexing %s: %v", path, err)
+			continue
+		}
+		uri := toURI(path)
+		symbols, err := workspaceSymbols(ctx, uri, newProgramAnalysis(program))
+		if err != nil {
+			logger.Debug().Msgf("indexing cancelled after %d of %d BASIC programs", i, len(paths))
+			w.end(ctx, "Cancelled")
+			return
+		}
+		h.index.add(uri, symbols)
+		count++
+	}
+
+	w.end(ctx, fmt.Sprintf("Indexed %d BASIC programs", count))
+	logger.Debug().Msgf("indexed %d BASIC programs in %v", count, folders)
+}
+
+// reindexFile indexes a file from disk again, e.g. once it is closed without
+// saving or is changed by another program.
+func (h *lspHandler) reindexFile(ctx context.Context, uri DocumentURI) {
+	path, err := fromURI(uri)
+	if err != nil {
+		return
+	}
+
+	program, err := parseFile(&h.g, path)
+	if err != nil {
+		zerolog.Ctx(ctx).Debug().Msgf("indexing %s: %v", path, err)
+		h.index.remove(uri)
+		return
+	}
+	h.index.update(uri, newProgramAnalysis(program))
+}
+
+// workspaceSymbols returns the symbols of a program to search for across the
+// workspace: its sections, subroutines, DEF FN functions and variables.
+func workspaceSymbols(ctx context.Context, uri DocumentURI, a *programAnalysis) ([]SymbolInformation, error) {
+	parsed := a.program
+	symbols := []SymbolInformation{}
+
+	var flatten func(outline []DocumentSymbol, container string)
+	flatten = func(outline []DocumentSymbol, container string) {
+		for _, sym := range outline {
+			// DIMs are found as variables, and DATA has no name
+			if sym.Kind == NamespaceSymbol || sym.Kind == FunctionSymbol {
+				symbols = append(symbols, SymbolInformation{
+					Name:          sym.Name,
+					Kind:          sym.Kind,
+					Location:      Location{URI: uri, Range: sym.SelectionRange},
+					ContainerName: container,
+				})
+			}
+			flatten(sym.Children, sym.Name)
+		}
+	}
+	outline, err := programSymbols(ctx, a)
+	if err != nil {
+		return nil, err
+	}
+	flatten(outline, "")
+
+	for _, v := range a.Variables().All {
+		// prefer where the variable is set over where it is used
+		refs := append(v.Definitions(), v.References...)
+		sym := SymbolInformation{
+			Name:     v.Name,
+			Kind:     VariableSymbol,
+			Location: Location{URI: uri, Range: tokenRange(parsed, refs[0].Token)},
+		}
+		if v.Array {
+			sym.Name += "()"
+			sym.Kind = ArraySymbol
+		}
+		symbols = append(symbols, sym)
+	}
+
+	return symbols, nil
+}
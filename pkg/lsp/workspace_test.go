@@ -0,0 +1,193 @@
+package lsp
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/miselin/c64lsp/pkg/grammar"
+	"github.com/sourcegraph/jsonrpc2"
+)
+
+func TestWorkspaceSymbols(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	files := map[string]string{
+		"game.bas":       "10 REM MAIN LOOP\n20 GOSUB 100:GOTO 20\n90 REM DRAW SCREEN\n100 SCORE=0\n110 RETURN\n",
+		"lib/util.bas":   "10 REM UTILITIES\n20 DEF FN SQ(X)=X*X\n30 DIM MAP(10)\n",
+		".hidden/x.bas":  "10 REM HIDDEN\n",
+		"notes.txt":      "10 REM NOT BASIC\n",
+		"broken/bad.bas": "10 PRINT \"\n",
+	}
+	for name, code := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(code), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	h := NewHandler().(*lspHandler)
+	h.indexWorkspace(ctx, []string{dir})
+
+	// an open document's unsaved changes replace what is on disk
+	util := toURI(filepath.Join(dir, "lib/util.bas"))
+	if err := h.openFile(util, "c64basic", 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.updateFile(ctx, util, File{Text: "10 REM HELPERS\n20 DEF FN SQ(X)=X*X\n", Version: 1}); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		query string
+		want  []string
+	}{
+		{"main", []string{"MAIN LOOP game.bas:0"}},
+		{"drw scr", []string{"DRAW SCREEN game.bas:3"}},
+		{"sq", []string{"FN SQ (HELPERS) util.bas:1"}},
+		{"util", []string{}},
+		{"hidden", []string{}},
+		{"sc", []string{
+			"DRAW SCREEN game.bas:3",
+			"SC game.bas:3",
+		}},
+		// the DIM of MAP() was taken out
+		{"ma(", []string{}},
+	}
+
+	for _, tt := range tests {
+		symbols, err := h.index.search(ctx, tt.query, h.beginWorkDone(ctx, nil, "", nil))
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := []string{}
+		for _, sym := range symbols {
+			s := sym.Name
+			if sym.ContainerName != "" {
+				s += " (" + sym.ContainerName + ")"
+			}
+			got = append(got, fmt.Sprintf("%s %s:%d", s, filepath.Base(string(sym.Location.URI)), sym.Location.Range.Start.Line))
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%q: symbols =\n%s\nwant\n%s", tt.query, strings.Join(got, "\n"), strings.Join(tt.want, "\n"))
+		}
+	}
+}
+
+// TestSearchWhileEditing checks that documents can change while the index is
+// searched, and that the last version of each is what is found in the end.
+func TestSearchWhileEditing(t *testing.T) {
+	ctx := context.Background()
+	g := grammar.NewGrammar()
+	analyse := func(code string) *programAnalysis {
+		program, err := g.Parse("test.bas", code)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return newProgramAnalysis(program)
+	}
+
+	var idx symbolIndex
+	uris := []DocumentURI{"file:///tmp/a.bas", "file:///tmp/b.bas", "file:///tmp/c.bas"}
+	versions := []*programAnalysis{}
+	for i := 0; i < 20; i++ {
+		versions = append(versions, analyse(fmt.Sprintf("10 REM VERSION %d\n20 V%d=1\n", i, i)))
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for _, a := range versions {
+			for _, uri := range uris {
+				idx.update(uri, a)
+			}
+		}
+	}()
+	for i := 0; i < 20; i++ {
+		if _, err := idx.search(ctx, "", &workDone{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	<-done
+
+	symbols, err := idx.search(ctx, "version", &workDone{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(symbols) != len(uris) {
+		t.Fatalf("found %d symbols, want one for each of %d files", len(symbols), len(uris))
+	}
+	for _, sym := range symbols {
+		if sym.Name != "VERSION 19" {
+			t.Errorf("%s: symbol %q, want the last version's", sym.Location.URI, sym.Name)
+		}
+	}
+}
+
+// TestWatchedFiles checks that BASIC programs created, changed and deleted on
+// disk are found, or no longer found, by a search.
+func TestWatchedFiles(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	write := func(name, code string) DocumentURI {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(code), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return toURI(path)
+	}
+	old := write("old.bas", "10 REM OLD GAME\n")
+	changed := write("changed.bas", "10 REM FIRST TRY\n")
+	open := write("open.bas", "10 REM ON DISK\n")
+
+	h := NewHandler().(*lspHandler)
+	h.indexWorkspace(ctx, []string{dir})
+	if err := h.openFile(open, "c64basic", 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.updateFile(ctx, open, File{Text: "10 REM UNSAVED\n", Version: 1}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.Remove(filepath.Join(dir, "old.bas")); err != nil {
+		t.Fatal(err)
+	}
+	created := write("new.bas", "10 REM NEW GAME\n")
+	write("changed.bas", "10 REM SECOND TRY\n")
+	write("open.bas", "10 REM SAVED ELSEWHERE\n")
+
+	params, err := json.Marshal(DidChangeWatchedFilesParams{Changes: []FileEvent{
+		{URI: old, Type: FileDeleted},
+		{URI: created, Type: FileCreated},
+		{URI: changed, Type: FileChanged},
+		{URI: open, Type: FileChanged},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw := json.RawMessage(params)
+	if _, err := h.handleWorkspaceDidChangeWatchedFiles(ctx, nil, &jsonrpc2.Request{Method: "workspace/didChangeWatchedFiles", Params: &raw, Notif: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	symbols, err := h.index.search(ctx, "", h.beginWorkDone(ctx, nil, "", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := []string{}
+	for _, sym := range symbols {
+		got = append(got, fmt.Sprintf("%s %s", sym.Name, filepath.Base(string(sym.Location.URI))))
+	}
+	want := []string{"SECOND TRY changed.bas", "NEW GAME new.bas", "UNSAVED open.bas"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("symbols = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,246 @@
+package reference
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Colours are the names of the 16 C64 colours, by colour code.
+var Colours = []string{
+	"black", "white", "red", "cyan", "purple", "green", "blue", "yellow",
+	"orange", "brown", "light red", "dark grey", "grey", "light green", "light blue", "light grey",
+}
+
+// FieldFormat defines how a bit field of a register value is presented.
+type FieldFormat int
+
+const (
+	// NumberFormat shows the field as a plain number.
+	NumberFormat FieldFormat = iota
+	// ColourFormat shows the field as a colour name.
+	ColourFormat
+	// FlagFormat shows the field label when the bit is set.
+	FlagFormat
+	// SpriteFormat shows which sprites have their bit set.
+	SpriteFormat
+	// AddressFormat shows the field multiplied by Scale as an address.
+	AddressFormat
+	// EnumFormat shows the entry of Names selected by the field.
+	EnumFormat
+	// ScreenCodeFormat shows the character a screen code displays.
+	ScreenCodeFormat
+)
+
+// ValueField describes how to decode one bit field of a value written to a register.
+type ValueField struct {
+	High, Low int
+	Label     string
+	Format    FieldFormat
+	// Multiplier for AddressFormat.
+	Scale int
+	// Values for EnumFormat, indexed by the field value.
+	Names []string
+}
+
+// RegisterDecoder describes how to decode values written to a range of addresses.
+type RegisterDecoder struct {
+	Start, End int
+	// Fields of the value, most significant first.
+	Fields []ValueField
+}
+
+var DecoderNotFound = errors.New("no decoder for address")
+
+var (
+	attackTimes  = durations(2, 8, 16, 24, 38, 56, 68, 80, 100, 250, 500, 800, 1000, 3000, 5000, 8000)
+	releaseTimes = durations(6, 24, 48, 72, 114, 168, 204, 240, 300, 750, 1500, 2400, 3000, 9000, 15000, 24000)
+)
+
+func durations(ms ...int) []string {
+	names := []string{}
+	for _, d := range ms {
+		if d >= 1000 {
+			names = append(names, fmt.Sprintf("%gs", float64(d)/1000))
+		} else {
+			names = append(names, fmt.Sprintf("%dms", d))
+		}
+	}
+	return names
+}
+
+// RegisterDecoders are the registers with known value layouts.
+var RegisterDecoders = concatDecoders(
+	[]RegisterDecoder{
+		{Start: 0x0400, End: 0x07E7, Fields: []ValueField{{7, 0, "", ScreenCodeFormat, 0, nil}}},
+		{Start: 0x07F8, End: 0x07FF, Fields: []ValueField{{7, 0, "sprite data at", AddressFormat, 64, nil}}},
+		{Start: 0xD800, End: 0xDBE7, Fields: []ValueField{{3, 0, "", ColourFormat, 0, nil}}},
+		{Start: 0x0286, End: 0x0286, Fields: []ValueField{{3, 0, "", ColourFormat, 0, nil}}},
+		{Start: 0xD010, End: 0xD010, Fields: []ValueField{{7, 0, "X bit 8 set", SpriteFormat, 0, nil}}},
+		{Start: 0xD011, End: 0xD011, Fields: []ValueField{
+			{7, 7, "raster bit 8", FlagFormat, 0, nil},
+			{6, 6, "extended colour", FlagFormat, 0, nil},
+			{5, 5, "bitmap mode", FlagFormat, 0, nil},
+			{4, 4, "", EnumFormat, 0, []string{"screen blanked", "screen on"}},
+			{3, 3, "", EnumFormat, 0, []string{"24 rows", "25 rows"}},
+			{2, 0, "Y scroll", NumberFormat, 0, nil},
+		}},
+		{Start: 0xD015, End: 0xD015, Fields: []ValueField{{7, 0, "enabled", SpriteFormat, 0, nil}}},
+		{Start: 0xD016, End: 0xD016, Fields: []ValueField{
+			{5, 5, "VIC reset", FlagFormat, 0, nil},
+			{4, 4, "multicolour", FlagFormat, 0, nil},
+			{3, 3, "", EnumFormat, 0, []string{"38 columns", "40 columns"}},
+			{2, 0, "X scroll", NumberFormat, 0, nil},
+		}},
+		{Start: 0xD017, End: 0xD017, Fields: []ValueField{{7, 0, "double height", SpriteFormat, 0, nil}}},
+		{Start: 0xD018, End: 0xD018, Fields: []ValueField{
+			{7, 4, "screen at", AddressFormat, 1024, nil},
+			{3, 1, "characters at", AddressFormat, 2048, nil},
+		}},
+		{Start: 0xD019, End: 0xD01A, Fields: []ValueField{
+			{3, 3, "light pen", FlagFormat, 0, nil},
+			{2, 2, "sprite-sprite", FlagFormat, 0, nil},
+			{1, 1, "sprite-background", FlagFormat, 0, nil},
+			{0, 0, "raster", FlagFormat, 0, nil},
+		}},
+		{Start: 0xD01B, End: 0xD01B, Fields: []ValueField{{7, 0, "behind background", SpriteFormat, 0, nil}}},
+		{Start: 0xD01C, End: 0xD01C, Fields: []ValueField{{7, 0, "multicolour", SpriteFormat, 0, nil}}},
+		{Start: 0xD01D, End: 0xD01D, Fields: []ValueField{{7, 0, "double width", SpriteFormat, 0, nil}}},
+		{Start: 0xD020, End: 0xD02E, Fields: []ValueField{{3, 0, "", ColourFormat, 0, nil}}},
+		{Start: 0xD417, End: 0xD417, Fields: []ValueField{
+			{7, 4, "resonance", NumberFormat, 0, nil},
+			{3, 3, "filter external", FlagFormat, 0, nil},
+			{2, 2, "filter voice 3", FlagFormat, 0, nil},
+			{1, 1, "filter voice 2", FlagFormat, 0, nil},
+			{0, 0, "filter voice 1", FlagFormat, 0, nil},
+		}},
+		{Start: 0xD418, End: 0xD418, Fields: []ValueField{
+			{7, 7, "voice 3 off", FlagFormat, 0, nil},
+			{6, 6, "high-pass", FlagFormat, 0, nil},
+			{5, 5, "band-pass", FlagFormat, 0, nil},
+			{4, 4, "low-pass", FlagFormat, 0, nil},
+			{3, 0, "volume", NumberFormat, 0, nil},
+		}},
+		{Start: 0xDD00, End: 0xDD00, Fields: []ValueField{
+			{1, 0, "VIC bank", EnumFormat, 0, []string{"$C000", "$8000", "$4000", "$0000"}},
+		}},
+	},
+	sidVoiceDecoders(),
+)
+
+func concatDecoders(lists ...[]RegisterDecoder) []RegisterDecoder {
+	all := []RegisterDecoder{}
+	for _, l := range lists {
+		all = append(all, l...)
+	}
+	return all
+}
+
+func sidVoiceDecoders() []RegisterDecoder {
+	decoders := []RegisterDecoder{}
+	for v := 0; v < 3; v++ {
+		base := 0xD400 + v*7
+		decoders = append(decoders,
+			RegisterDecoder{Start: base + 4, End: base + 4, Fields: []ValueField{
+				{7, 7, "noise", FlagFormat, 0, nil},
+				{6, 6, "pulse", FlagFormat, 0, nil},
+				{5, 5, "sawtooth", FlagFormat, 0, nil},
+				{4, 4, "triangle", FlagFormat, 0, nil},
+				{3, 3, "test", FlagFormat, 0, nil},
+				{2, 2, "ring mod", FlagFormat, 0, nil},
+				{1, 1, "sync", FlagFormat, 0, nil},
+				{0, 0, "", EnumFormat, 0, []string{"gate off", "gate on"}},
+			}},
+			RegisterDecoder{Start: base + 5, End: base + 5, Fields: []ValueField{
+				{7, 4, "attack", EnumFormat, 0, attackTimes},
+				{3, 0, "decay", EnumFormat, 0, releaseTimes},
+			}},
+			RegisterDecoder{Start: base + 6, End: base + 6, Fields: []ValueField{
+				{7, 4, "sustain", NumberFormat, 0, nil},
+				{3, 0, "release", EnumFormat, 0, releaseTimes},
+			}},
+		)
+	}
+	return decoders
+}
+
+// LookupDecoder returns the decoder for values written to an address,
+// following I/O chip mirrors.
+func LookupDecoder(addr int) (*RegisterDecoder, error) {
+	addr = canonicalAddress(addr)
+	for i := range RegisterDecoders {
+		d := &RegisterDecoders[i]
+		if d.Start <= addr && addr <= d.End {
+			return d, nil
+		}
+	}
+	return nil, DecoderNotFound
+}
+
+// DecodeValue describes the effect of writing a value to an address.
+func DecodeValue(addr, value int) (string, error) {
+	d, err := LookupDecoder(addr)
+	if err != nil {
+		return "", err
+	}
+	return d.Decode(value), nil
+}
+
+// Decode describes each field of a value, skipping flags that are clear.
+func (d *RegisterDecoder) Decode(value int) string {
+	parts := []string{}
+	for _, f := range d.Fields {
+		if part := f.decode(value); part != "" {
+			parts = append(parts, part)
+		}
+	}
+	if len(parts) == 0 {
+		return "none set"
+	}
+	return strings.Join(parts, ", ")
+}
+
+func (f *ValueField) decode(value int) string {
+	width := f.High - f.Low + 1
+	v := (value >> f.Low) & (1<<width - 1)
+
+	label := func(s string) string {
+		if f.Label == "" {
+			return s
+		}
+		return f.Label + " " + s
+	}
+
+	switch f.Format {
+	case ColourFormat:
+		return label(Colours[v&0xF])
+	case FlagFormat:
+		if v == 0 {
+			return ""
+		}
+		return f.Label
+	case SpriteFormat:
+		sprites := []string{}
+		for i := 0; i < 8; i++ {
+			if v&(1<<i) != 0 {
+				sprites = append(sprites, fmt.Sprintf("%d", i))
+			}
+		}
+		switch len(sprites) {
+		case 0:
+			return "no sprites " + f.Label
+		case 1:
+			return "sprite " + sprites[0] + " " + f.Label
+		}
+		return "sprites " + strings.Join(sprites, ",") + " " + f.Label
+	case AddressFormat:
+		return label(fmt.Sprintf("$%04X", v*f.Scale))
+	case EnumFormat:
+		if v < len(f.Names) {
+			return label(f.Names[v])
+		}
+	case ScreenCodeFormat:
+		return label(DescribeScreenCode(v))
+	}
+	return label(fmt.Sprintf("%d", v))
+}
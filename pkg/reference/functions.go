@@ -6,26 +6,19 @@ import (
 	"strings"
 )
 
-var functionsMap = map[string]*BasicFunction{}
-var functionsMapBuilt = false
+var functionsMap = buildFunctionsMap()
 
 var FunctionNotFound = errors.New("function does not exist")
 
-func buildFunctionsMap() {
-	if functionsMapBuilt {
-		return
-	}
-
-	functionsMapBuilt = true
-
-	for _, fn := range BasicFunctions {
-		functionsMap[fn.Name] = &fn
+func buildFunctionsMap() map[string]*BasicFunction {
+	functions := map[string]*BasicFunction{}
+	for i := range BasicFunctions {
+		functions[BasicFunctions[i].Name] = &BasicFunctions[i]
 	}
+	return functions
 }
 
 func GetFunctionDocs(fn string) (*BasicFunction, error) {
-	buildFunctionsMap()
-
 	ref, ok := functionsMap[fn]
 	if !ok {
 		return nil, FunctionNotFound
@@ -0,0 +1,202 @@
+package reference
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// BitField describes one or more bits of a hardware register.
+type BitField struct {
+	// Highest and lowest bit numbers covered by the field.
+	High, Low int
+	Meaning   string
+}
+
+// MemoryLocation describes a named address, or range of addresses, in the C64
+// memory map.
+type MemoryLocation struct {
+	Start int
+	End   int
+	// Label as used in the Programmers Reference Guide, e.g. "EXTCOL".
+	Name string
+	// Chip or region the location belongs to, e.g. "VIC-II".
+	Area    string
+	Purpose string
+	// Bit layout for registers, most significant field first.
+	Bits []BitField
+	// Size in bytes of each element of a grid-shaped region (e.g. screen RAM),
+	// with Columns elements per row. Zero for everything else.
+	Columns int
+}
+
+var AddressNotFound = errors.New("address is not in the memory map")
+
+var memoryIndex = buildMemoryIndex()
+
+func buildMemoryIndex() []*MemoryLocation {
+	index := []*MemoryLocation{}
+	for i := range MemoryMap {
+		index = append(index, &MemoryMap[i])
+	}
+	for _, chip := range mirroredChips {
+		index = append(index, chip.mirrors()...)
+	}
+
+	// most specific location first, so lookups find registers before regions
+	sort.SliceStable(index, func(i, j int) bool {
+		return index[i].End-index[i].Start < index[j].End-index[j].Start
+	})
+	return index
+}
+
+// LookupAddress returns the most specific memory location containing an address.
+func LookupAddress(addr int) (*MemoryLocation, error) {
+	if addr < 0 || addr > 0xFFFF {
+		return nil, AddressNotFound
+	}
+
+	for _, loc := range memoryIndex {
+		if loc.Start <= addr && addr <= loc.End {
+			return loc, nil
+		}
+	}
+
+	return nil, AddressNotFound
+}
+
+// DefaultScreen is the address of screen RAM when the C64 is switched on.
+const DefaultScreen = 0x0400
+
+// LookupAddressWithScreen is LookupAddress for a program that has moved screen
+// RAM, and the sprite pointers at its end, to start at screen. The default
+// screen is then ordinary RAM.
+func LookupAddressWithScreen(addr, screen int) (*MemoryLocation, error) {
+	if screen == DefaultScreen {
+		return LookupAddress(addr)
+	}
+
+	if addr < 0 || addr > 0xFFFF {
+		return nil, AddressNotFound
+	}
+
+	for _, loc := range memoryIndex {
+		if !loc.followsScreen() {
+			continue
+		}
+		moved := *loc
+		moved.Start += screen - DefaultScreen
+		moved.End += screen - DefaultScreen
+		if moved.Start <= addr && addr <= moved.End {
+			return &moved, nil
+		}
+	}
+	for _, loc := range memoryIndex {
+		if !loc.followsScreen() && loc.Start <= addr && addr <= loc.End {
+			return loc, nil
+		}
+	}
+
+	return nil, AddressNotFound
+}
+
+// followsScreen checks if a location moves along with screen RAM.
+func (loc *MemoryLocation) followsScreen() bool {
+	return loc.Area == "RAM" && loc.Start >= DefaultScreen && loc.End < DefaultScreen+0x400
+}
+
+// Position describes where an address falls within a location, e.g. the row
+// and column of a screen RAM address.
+func (loc *MemoryLocation) Position(addr int) string {
+	offset := addr - loc.Start
+	if loc.Columns > 0 {
+		return fmt.Sprintf("row %d col %d", offset/loc.Columns, offset%loc.Columns)
+	}
+	if loc.Start != loc.End && offset > 0 {
+		return fmt.Sprintf("byte %d", offset)
+	}
+	return ""
+}
+
+// Summary returns a one-line description of an address, e.g. "colour RAM, row 15 col 12".
+func (loc *MemoryLocation) Summary(addr int) string {
+	summary := loc.Name
+	if pos := loc.Position(addr); pos != "" {
+		summary += ", " + pos
+	}
+	return summary
+}
+
+// Markdown describes an address within the location.
+func (loc *MemoryLocation) Markdown(addr int) string {
+	var sb strings.Builder
+
+	sb.WriteString(fmt.Sprintf("## $%04X (%d) — %s\n\n", addr, addr, loc.Summary(addr)))
+	if loc.Area != "" {
+		sb.WriteString(fmt.Sprintf("### AREA: %s\n", loc.Area))
+	}
+	if loc.Start != loc.End {
+		sb.WriteString(fmt.Sprintf("### RANGE: $%04X-$%04X (%d-%d)\n", loc.Start, loc.End, loc.Start, loc.End))
+	}
+	sb.WriteString("\n")
+	sb.WriteString(fmt.Sprintf("**Purpose:** %s\n\n", loc.Purpose))
+
+	if len(loc.Bits) > 0 {
+		sb.WriteString("| Bits | Meaning |\n|---|---|\n")
+		for _, b := range loc.Bits {
+			bits := fmt.Sprintf("%d", b.High)
+			if b.High != b.Low {
+				bits = fmt.Sprintf("%d-%d", b.High, b.Low)
+			}
+			sb.WriteString(fmt.Sprintf("| %s | %s |\n", bits, b.Meaning))
+		}
+	}
+
+	return sb.String()
+}
+
+// mirroredChip is an I/O chip whose registers repeat through a larger window
+// because it does not decode every address line.
+type mirroredChip struct {
+	Name   string
+	Start  int
+	Size   int
+	Window int
+}
+
+var mirroredChips = []mirroredChip{
+	{Name: "VIC-II", Start: 0xD000, Size: 0x40, Window: 0x400},
+	{Name: "SID", Start: 0xD400, Size: 0x20, Window: 0x400},
+	{Name: "CIA 1", Start: 0xDC00, Size: 0x10, Window: 0x100},
+	{Name: "CIA 2", Start: 0xDD00, Size: 0x10, Window: 0x100},
+}
+
+func (chip mirroredChip) mirrors() []*MemoryLocation {
+	mirrors := []*MemoryLocation{}
+	for i := range MemoryMap {
+		loc := &MemoryMap[i]
+		if loc.Area != chip.Name || loc.Start < chip.Start || loc.End >= chip.Start+chip.Size {
+			continue
+		}
+		for base := chip.Start + chip.Size; base < chip.Start+chip.Window; base += chip.Size {
+			mirror := *loc
+			mirror.Start += base - chip.Start
+			mirror.End += base - chip.Start
+			mirror.Purpose = fmt.Sprintf("Mirror of $%04X. %s", loc.Start, loc.Purpose)
+			mirrors = append(mirrors, &mirror)
+		}
+	}
+	return mirrors
+}
+
+// canonicalAddress maps an address in a mirrored I/O chip window back to the
+// chip's base registers.
+func canonicalAddress(addr int) int {
+	for _, chip := range mirroredChips {
+		if chip.Start <= addr && addr < chip.Start+chip.Window {
+			return chip.Start + (addr-chip.Start)%chip.Size
+		}
+	}
+	return addr
+}
@@ -0,0 +1,391 @@
+package reference
+
+import "fmt"
+
+// MemoryMap is the C64 memory map, from the Programmers Reference Guide
+// (Appendix O) and "Mapping the Commodore 64".
+var MemoryMap = concatLocations(
+	regions,
+	zeroPage,
+	vectors,
+	vicRegisters(),
+	sidRegisters(),
+	ciaRegisters("CIA 1", 0xDC00, cia1Ports),
+	ciaRegisters("CIA 2", 0xDD00, cia2Ports),
+	kernalJumpTable,
+)
+
+func concatLocations(lists ...[]MemoryLocation) []MemoryLocation {
+	all := []MemoryLocation{}
+	for _, l := range lists {
+		all = append(all, l...)
+	}
+	return all
+}
+
+var regions = []MemoryLocation{
+	{Start: 0x0000, End: 0x00FF, Name: "zero page", Area: "RAM", Purpose: "Zero page, used heavily by BASIC and the KERNAL for pointers and work areas."},
+	{Start: 0x0100, End: 0x01FF, Name: "stack", Area: "RAM", Purpose: "6510 processor stack. Also used by BASIC for FOR/NEXT and GOSUB records."},
+	{Start: 0x0200, End: 0x03FF, Name: "OS work area", Area: "RAM", Purpose: "BASIC and KERNAL working storage: input buffer, file tables, keyboard buffer and vectors."},
+	{Start: 0x0200, End: 0x0258, Name: "BUF", Area: "RAM", Purpose: "BASIC line input buffer (89 bytes)."},
+	{Start: 0x0277, End: 0x0280, Name: "KEYD", Area: "RAM", Purpose: "Keyboard buffer queue (10 bytes). POKE characters here and set NDX (198) to type them."},
+	{Start: 0x033C, End: 0x03FB, Name: "TBUFFR", Area: "RAM", Purpose: "Cassette I/O buffer (192 bytes). Often used for small machine language routines."},
+	{Start: 0x0400, End: 0x07E7, Name: "screen RAM", Area: "RAM", Columns: 40, Purpose: "Default screen memory: 25 rows of 40 screen codes."},
+	{Start: 0x07F8, End: 0x07FF, Name: "sprite pointers", Area: "RAM", Purpose: "Sprite data pointers for the default screen. Sprite data is at 64 times the value."},
+	{Start: 0x0800, End: 0x9FFF, Name: "BASIC program area", Area: "RAM", Purpose: "BASIC program text, variables, arrays and strings."},
+	{Start: 0xA000, End: 0xBFFF, Name: "BASIC ROM", Area: "ROM", Purpose: "BASIC interpreter ROM. RAM underneath can be written but not read while the ROM is banked in."},
+	{Start: 0xC000, End: 0xCFFF, Name: "free RAM", Area: "RAM", Purpose: "4K of RAM not used by BASIC or the KERNAL, popular for machine language and sprite data."},
+	{Start: 0xD000, End: 0xDFFF, Name: "I/O area", Area: "I/O", Purpose: "Memory-mapped I/O chips, or character ROM when banked in."},
+	{Start: 0xD000, End: 0xD3FF, Name: "VIC-II", Area: "VIC-II", Purpose: "VIC-II video chip registers, repeated every 64 bytes."},
+	{Start: 0xD400, End: 0xD7FF, Name: "SID", Area: "SID", Purpose: "SID sound chip registers, repeated every 32 bytes."},
+	{Start: 0xD800, End: 0xDBE7, Name: "colour RAM", Area: "I/O", Columns: 40, Purpose: "Colour memory: the foreground colour (low nybble) of each screen position, 25 rows of 40."},
+	{Start: 0xDC00, End: 0xDCFF, Name: "CIA 1", Area: "CIA 1", Purpose: "CIA 1: keyboard, joysticks, paddles and the IRQ timer. Repeated every 16 bytes."},
+	{Start: 0xDD00, End: 0xDDFF, Name: "CIA 2", Area: "CIA 2", Purpose: "CIA 2: serial bus, RS-232, VIC-II bank selection and the NMI timer. Repeated every 16 bytes."},
+	{Start: 0xDE00, End: 0xDEFF, Name: "I/O 1", Area: "I/O", Purpose: "Expansion port I/O area 1."},
+	{Start: 0xDF00, End: 0xDFFF, Name: "I/O 2", Area: "I/O", Purpose: "Expansion port I/O area 2."},
+	{Start: 0xE000, End: 0xFFFF, Name: "KERNAL ROM", Area: "ROM", Purpose: "KERNAL operating system ROM."},
+	{Start: 0xFCE2, End: 0xFCE2, Name: "RESET", Area: "KERNAL", Purpose: "Power-on reset routine. SYS 64738 restarts the machine."},
+	{Start: 0xFFFA, End: 0xFFFB, Name: "NMI vector", Area: "KERNAL", Purpose: "6510 hardware NMI vector."},
+	{Start: 0xFFFC, End: 0xFFFD, Name: "RESET vector", Area: "KERNAL", Purpose: "6510 hardware reset vector."},
+	{Start: 0xFFFE, End: 0xFFFF, Name: "IRQ vector", Area: "KERNAL", Purpose: "6510 hardware IRQ/BRK vector."},
+}
+
+var zeroPage = []MemoryLocation{
+	{Start: 0x00, End: 0x00, Name: "D6510", Area: "6510", Purpose: "6510 on-chip I/O port data direction register.", Bits: []BitField{
+		{7, 0, "1 = bit of R6510 is an output, 0 = input"},
+	}},
+	{Start: 0x01, End: 0x01, Name: "R6510", Area: "6510", Purpose: "6510 on-chip I/O port: memory banking and cassette control.", Bits: []BitField{
+		{5, 5, "cassette motor (0 = on)"},
+		{4, 4, "cassette switch sense (0 = button pressed)"},
+		{3, 3, "cassette write line"},
+		{2, 2, "CHAREN: 0 = character ROM at $D000, 1 = I/O"},
+		{1, 1, "HIRAM: 0 = RAM at $E000 instead of KERNAL ROM"},
+		{0, 0, "LORAM: 0 = RAM at $A000 instead of BASIC ROM"},
+	}},
+	{Start: 0x2B, End: 0x2C, Name: "TXTTAB", Area: "BASIC", Purpose: "Pointer to the start of BASIC program text."},
+	{Start: 0x2D, End: 0x2E, Name: "VARTAB", Area: "BASIC", Purpose: "Pointer to the start of BASIC variables (end of program)."},
+	{Start: 0x2F, End: 0x30, Name: "ARYTAB", Area: "BASIC", Purpose: "Pointer to the start of BASIC arrays."},
+	{Start: 0x31, End: 0x32, Name: "STREND", Area: "BASIC", Purpose: "Pointer to the end of BASIC arrays (start of free memory)."},
+	{Start: 0x33, End: 0x34, Name: "FRETOP", Area: "BASIC", Purpose: "Pointer to the bottom of string storage."},
+	{Start: 0x37, End: 0x38, Name: "MEMSIZ", Area: "BASIC", Purpose: "Pointer to the highest address used by BASIC."},
+	{Start: 0x39, End: 0x3A, Name: "CURLIN", Area: "BASIC", Purpose: "Current BASIC line number."},
+	{Start: 0x90, End: 0x90, Name: "STATUS", Area: "KERNAL", Purpose: "KERNAL I/O status word, read by BASIC as ST."},
+	{Start: 0x91, End: 0x91, Name: "STKEY", Area: "KERNAL", Purpose: "Flag for the STOP key (127 when pressed)."},
+	{Start: 0x9D, End: 0x9D, Name: "MSGFLG", Area: "KERNAL", Purpose: "KERNAL message control: bit 7 = control messages, bit 6 = error messages."},
+	{Start: 0xA0, End: 0xA2, Name: "TIME", Area: "KERNAL", Purpose: "Software jiffy clock, read by BASIC as TI."},
+	{Start: 0xBA, End: 0xBA, Name: "FA", Area: "KERNAL", Purpose: "Current device number."},
+	{Start: 0xC5, End: 0xC5, Name: "LSTX", Area: "KERNAL", Purpose: "Matrix code of the key currently pressed (64 = no key)."},
+	{Start: 0xC6, End: 0xC6, Name: "NDX", Area: "KERNAL", Purpose: "Number of characters in the keyboard buffer. POKE 198,0 clears it."},
+	{Start: 0xC7, End: 0xC7, Name: "RVS", Area: "KERNAL", Purpose: "Reverse character flag (18 = on, 0 = off)."},
+	{Start: 0xCB, End: 0xCB, Name: "SFDX", Area: "KERNAL", Purpose: "Matrix code of the current key pressed, used by the keyboard scan."},
+	{Start: 0xCC, End: 0xCC, Name: "BLNSW", Area: "KERNAL", Purpose: "Cursor blink enable (0 = flash cursor)."},
+	{Start: 0xD1, End: 0xD2, Name: "PNT", Area: "KERNAL", Purpose: "Pointer to the start of the current screen line."},
+	{Start: 0xD3, End: 0xD3, Name: "PNTR", Area: "KERNAL", Purpose: "Cursor column on the current logical line."},
+	{Start: 0xD4, End: 0xD4, Name: "QTSW", Area: "KERNAL", Purpose: "Quote mode flag (0 = off)."},
+	{Start: 0xD6, End: 0xD6, Name: "TBLX", Area: "KERNAL", Purpose: "Cursor row."},
+	{Start: 0xD8, End: 0xD8, Name: "INSRT", Area: "KERNAL", Purpose: "Number of pending inserts (insert mode)."},
+	{Start: 0xF3, End: 0xF4, Name: "USER", Area: "KERNAL", Purpose: "Pointer to the current colour RAM location."},
+	{Start: 0x0286, End: 0x0286, Name: "COLOR", Area: "KERNAL", Purpose: "Current text foreground colour."},
+	{Start: 0x0287, End: 0x0287, Name: "GDCOL", Area: "KERNAL", Purpose: "Colour of the character under the cursor."},
+	{Start: 0x0288, End: 0x0288, Name: "HIBASE", Area: "KERNAL", Purpose: "High byte of the screen memory address used by the KERNAL editor."},
+	{Start: 0x0289, End: 0x0289, Name: "XMAX", Area: "KERNAL", Purpose: "Maximum size of the keyboard buffer."},
+	{Start: 0x028A, End: 0x028A, Name: "RPTFLG", Area: "KERNAL", Purpose: "Key repeat: 128 = all keys, 64 = none, 0 = cursor and space only."},
+	{Start: 0x028D, End: 0x028D, Name: "SHFLAG", Area: "KERNAL", Purpose: "SHIFT/CTRL/Commodore key flags.", Bits: []BitField{
+		{2, 2, "CTRL pressed"},
+		{1, 1, "Commodore key pressed"},
+		{0, 0, "SHIFT pressed"},
+	}},
+	{Start: 0x0291, End: 0x0291, Name: "MODE", Area: "KERNAL", Purpose: "SHIFT+Commodore case switch (128 = disabled)."},
+}
+
+var vectors = []MemoryLocation{
+	{Start: 0x0300, End: 0x0301, Name: "IERROR", Area: "BASIC vectors", Purpose: "Vector to the BASIC error message routine."},
+	{Start: 0x0302, End: 0x0303, Name: "IMAIN", Area: "BASIC vectors", Purpose: "Vector to the BASIC warm start (main loop)."},
+	{Start: 0x0304, End: 0x0305, Name: "ICRNCH", Area: "BASIC vectors", Purpose: "Vector to the routine that tokenizes BASIC text."},
+	{Start: 0x0306, End: 0x0307, Name: "IQPLOP", Area: "BASIC vectors", Purpose: "Vector to the routine that lists BASIC tokens as text."},
+	{Start: 0x0308, End: 0x0309, Name: "IGONE", Area: "BASIC vectors", Purpose: "Vector to the routine that executes the next BASIC token."},
+	{Start: 0x030A, End: 0x030B, Name: "IEVAL", Area: "BASIC vectors", Purpose: "Vector to the routine that evaluates a single expression term."},
+	{Start: 0x030C, End: 0x030C, Name: "SAREG", Area: "BASIC vectors", Purpose: "Accumulator is loaded from here by SYS, and stored back afterwards."},
+	{Start: 0x030D, End: 0x030D, Name: "SXREG", Area: "BASIC vectors", Purpose: "X register is loaded from here by SYS, and stored back afterwards."},
+	{Start: 0x030E, End: 0x030E, Name: "SYREG", Area: "BASIC vectors", Purpose: "Y register is loaded from here by SYS, and stored back afterwards."},
+	{Start: 0x030F, End: 0x030F, Name: "SPREG", Area: "BASIC vectors", Purpose: "Status register is loaded from here by SYS, and stored back afterwards."},
+	{Start: 0x0310, End: 0x0312, Name: "USRPOK", Area: "BASIC vectors", Purpose: "JMP instruction and address called by the USR function."},
+	{Start: 0x0314, End: 0x0315, Name: "CINV", Area: "KERNAL vectors", Purpose: "Vector to the IRQ interrupt handler."},
+	{Start: 0x0316, End: 0x0317, Name: "CBINV", Area: "KERNAL vectors", Purpose: "Vector to the BRK instruction handler."},
+	{Start: 0x0318, End: 0x0319, Name: "NMINV", Area: "KERNAL vectors", Purpose: "Vector to the NMI interrupt handler."},
+	{Start: 0x031A, End: 0x031B, Name: "IOPEN", Area: "KERNAL vectors", Purpose: "Vector to the KERNAL OPEN routine."},
+	{Start: 0x031C, End: 0x031D, Name: "ICLOSE", Area: "KERNAL vectors", Purpose: "Vector to the KERNAL CLOSE routine."},
+	{Start: 0x031E, End: 0x031F, Name: "ICHKIN", Area: "KERNAL vectors", Purpose: "Vector to the KERNAL CHKIN routine."},
+	{Start: 0x0320, End: 0x0321, Name: "ICKOUT", Area: "KERNAL vectors", Purpose: "Vector to the KERNAL CHKOUT routine."},
+	{Start: 0x0322, End: 0x0323, Name: "ICLRCH", Area: "KERNAL vectors", Purpose: "Vector to the KERNAL CLRCHN routine."},
+	{Start: 0x0324, End: 0x0325, Name: "IBASIN", Area: "KERNAL vectors", Purpose: "Vector to the KERNAL CHRIN routine."},
+	{Start: 0x0326, End: 0x0327, Name: "IBSOUT", Area: "KERNAL vectors", Purpose: "Vector to the KERNAL CHROUT routine."},
+	{Start: 0x0328, End: 0x0329, Name: "ISTOP", Area: "KERNAL vectors", Purpose: "Vector to the KERNAL STOP routine. Redirecting it can disable RUN/STOP."},
+	{Start: 0x032A, End: 0x032B, Name: "IGETIN", Area: "KERNAL vectors", Purpose: "Vector to the KERNAL GETIN routine."},
+	{Start: 0x032C, End: 0x032D, Name: "ICLALL", Area: "KERNAL vectors", Purpose: "Vector to the KERNAL CLALL routine."},
+	{Start: 0x0330, End: 0x0331, Name: "ILOAD", Area: "KERNAL vectors", Purpose: "Vector to the KERNAL LOAD routine."},
+	{Start: 0x0332, End: 0x0333, Name: "ISAVE", Area: "KERNAL vectors", Purpose: "Vector to the KERNAL SAVE routine."},
+}
+
+func vicRegisters() []MemoryLocation {
+	regs := []MemoryLocation{}
+	for i := 0; i < 8; i++ {
+		regs = append(regs,
+			MemoryLocation{Start: 0xD000 + i*2, End: 0xD000 + i*2, Name: fmt.Sprintf("SP%dX", i), Area: "VIC-II", Purpose: fmt.Sprintf("Sprite %d horizontal position (low 8 bits; bit 8 is in MSIGX).", i)},
+			MemoryLocation{Start: 0xD001 + i*2, End: 0xD001 + i*2, Name: fmt.Sprintf("SP%dY", i), Area: "VIC-II", Purpose: fmt.Sprintf("Sprite %d vertical position.", i)},
+			MemoryLocation{Start: 0xD027 + i, End: 0xD027 + i, Name: fmt.Sprintf("SP%dCOL", i), Area: "VIC-II", Purpose: fmt.Sprintf("Sprite %d colour.", i), Bits: []BitField{{3, 0, "colour"}}},
+		)
+	}
+
+	return append(regs, []MemoryLocation{
+		{Start: 0xD010, End: 0xD010, Name: "MSIGX", Area: "VIC-II", Purpose: "Most significant bit of each sprite's horizontal position.", Bits: spriteBits("X position bit 8")},
+		{Start: 0xD011, End: 0xD011, Name: "SCROLY", Area: "VIC-II", Purpose: "Vertical fine scroll and screen control.", Bits: []BitField{
+			{7, 7, "raster compare bit 8"},
+			{6, 6, "extended colour text mode"},
+			{5, 5, "bitmap mode"},
+			{4, 4, "screen enable (0 = blank)"},
+			{3, 3, "rows: 1 = 25, 0 = 24"},
+			{2, 0, "vertical fine scroll"},
+		}},
+		{Start: 0xD012, End: 0xD012, Name: "RASTER", Area: "VIC-II", Purpose: "Read: current raster line (low 8 bits). Write: raster line that triggers an interrupt."},
+		{Start: 0xD013, End: 0xD013, Name: "LPENX", Area: "VIC-II", Purpose: "Light pen horizontal position."},
+		{Start: 0xD014, End: 0xD014, Name: "LPENY", Area: "VIC-II", Purpose: "Light pen vertical position."},
+		{Start: 0xD015, End: 0xD015, Name: "SPENA", Area: "VIC-II", Purpose: "Sprite enable.", Bits: spriteBits("enabled")},
+		{Start: 0xD016, End: 0xD016, Name: "SCROLX", Area: "VIC-II", Purpose: "Horizontal fine scroll and control.", Bits: []BitField{
+			{5, 5, "VIC reset (always 0)"},
+			{4, 4, "multicolour mode"},
+			{3, 3, "columns: 1 = 40, 0 = 38"},
+			{2, 0, "horizontal fine scroll"},
+		}},
+		{Start: 0xD017, End: 0xD017, Name: "YXPAND", Area: "VIC-II", Purpose: "Sprite vertical expansion.", Bits: spriteBits("double height")},
+		{Start: 0xD018, End: 0xD018, Name: "VMCSB", Area: "VIC-II", Purpose: "VIC-II memory setup, relative to the VIC bank selected in CI2PRA.", Bits: []BitField{
+			{7, 4, "screen memory at 1024 * value"},
+			{3, 1, "character memory at 2048 * value"},
+		}},
+		{Start: 0xD019, End: 0xD019, Name: "VICIRQ", Area: "VIC-II", Purpose: "Interrupt flags. Write 1 to a bit to acknowledge it.", Bits: []BitField{
+			{7, 7, "any enabled interrupt occurred"},
+			{3, 3, "light pen"},
+			{2, 2, "sprite-sprite collision"},
+			{1, 1, "sprite-background collision"},
+			{0, 0, "raster compare"},
+		}},
+		{Start: 0xD01A, End: 0xD01A, Name: "IRQMSK", Area: "VIC-II", Purpose: "Interrupt enable.", Bits: []BitField{
+			{3, 3, "light pen"},
+			{2, 2, "sprite-sprite collision"},
+			{1, 1, "sprite-background collision"},
+			{0, 0, "raster compare"},
+		}},
+		{Start: 0xD01B, End: 0xD01B, Name: "SPBGPR", Area: "VIC-II", Purpose: "Sprite to background priority.", Bits: spriteBits("behind background")},
+		{Start: 0xD01C, End: 0xD01C, Name: "SPMC", Area: "VIC-II", Purpose: "Sprite multicolour mode.", Bits: spriteBits("multicolour")},
+		{Start: 0xD01D, End: 0xD01D, Name: "XXPAND", Area: "VIC-II", Purpose: "Sprite horizontal expansion.", Bits: spriteBits("double width")},
+		{Start: 0xD01E, End: 0xD01E, Name: "SPSPCL", Area: "VIC-II", Purpose: "Sprite to sprite collisions, cleared on read.", Bits: spriteBits("collided")},
+		{Start: 0xD01F, End: 0xD01F, Name: "SPBGCL", Area: "VIC-II", Purpose: "Sprite to background collisions, cleared on read.", Bits: spriteBits("collided")},
+		{Start: 0xD020, End: 0xD020, Name: "EXTCOL", Area: "VIC-II", Purpose: "Border colour.", Bits: []BitField{{3, 0, "colour"}}},
+		{Start: 0xD021, End: 0xD021, Name: "BGCOL0", Area: "VIC-II", Purpose: "Background colour 0.", Bits: []BitField{{3, 0, "colour"}}},
+		{Start: 0xD022, End: 0xD022, Name: "BGCOL1", Area: "VIC-II", Purpose: "Background colour 1 (multicolour and extended colour modes).", Bits: []BitField{{3, 0, "colour"}}},
+		{Start: 0xD023, End: 0xD023, Name: "BGCOL2", Area: "VIC-II", Purpose: "Background colour 2 (multicolour and extended colour modes).", Bits: []BitField{{3, 0, "colour"}}},
+		{Start: 0xD024, End: 0xD024, Name: "BGCOL3", Area: "VIC-II", Purpose: "Background colour 3 (extended colour mode).", Bits: []BitField{{3, 0, "colour"}}},
+		{Start: 0xD025, End: 0xD025, Name: "SPMC0", Area: "VIC-II", Purpose: "Sprite multicolour 0.", Bits: []BitField{{3, 0, "colour"}}},
+		{Start: 0xD026, End: 0xD026, Name: "SPMC1", Area: "VIC-II", Purpose: "Sprite multicolour 1.", Bits: []BitField{{3, 0, "colour"}}},
+	}...)
+}
+
+func spriteBits(meaning string) []BitField {
+	bits := []BitField{}
+	for i := 7; i >= 0; i-- {
+		bits = append(bits, BitField{i, i, fmt.Sprintf("sprite %d %s", i, meaning)})
+	}
+	return bits
+}
+
+func sidRegisters() []MemoryLocation {
+	regs := []MemoryLocation{}
+	for v := 0; v < 3; v++ {
+		base := 0xD400 + v*7
+		voice := fmt.Sprintf("Voice %d", v+1)
+		regs = append(regs, []MemoryLocation{
+			{Start: base, End: base, Name: fmt.Sprintf("FRELO%d", v+1), Area: "SID", Purpose: voice + " frequency, low byte."},
+			{Start: base + 1, End: base + 1, Name: fmt.Sprintf("FREHI%d", v+1), Area: "SID", Purpose: voice + " frequency, high byte."},
+			{Start: base + 2, End: base + 2, Name: fmt.Sprintf("PWLO%d", v+1), Area: "SID", Purpose: voice + " pulse waveform width, low byte."},
+			{Start: base + 3, End: base + 3, Name: fmt.Sprintf("PWHI%d", v+1), Area: "SID", Purpose: voice + " pulse waveform width, high nybble.", Bits: []BitField{{3, 0, "pulse width bits 8-11"}}},
+			{Start: base + 4, End: base + 4, Name: fmt.Sprintf("VCREG%d", v+1), Area: "SID", Purpose: voice + " control register.", Bits: []BitField{
+				{7, 7, "noise waveform"},
+				{6, 6, "pulse waveform"},
+				{5, 5, "sawtooth waveform"},
+				{4, 4, "triangle waveform"},
+				{3, 3, "test bit (silences the oscillator)"},
+				{2, 2, "ring modulation"},
+				{1, 1, "synchronisation"},
+				{0, 0, "gate: 1 = start attack/decay/sustain, 0 = start release"},
+			}},
+			{Start: base + 5, End: base + 5, Name: fmt.Sprintf("ATDCY%d", v+1), Area: "SID", Purpose: voice + " attack and decay.", Bits: []BitField{
+				{7, 4, "attack duration"},
+				{3, 0, "decay duration"},
+			}},
+			{Start: base + 6, End: base + 6, Name: fmt.Sprintf("SUREL%d", v+1), Area: "SID", Purpose: voice + " sustain and release.", Bits: []BitField{
+				{7, 4, "sustain volume"},
+				{3, 0, "release duration"},
+			}},
+		}...)
+	}
+
+	return append(regs, []MemoryLocation{
+		{Start: 0xD415, End: 0xD415, Name: "CUTLO", Area: "SID", Purpose: "Filter cutoff frequency, low 3 bits.", Bits: []BitField{{2, 0, "cutoff bits 0-2"}}},
+		{Start: 0xD416, End: 0xD416, Name: "CUTHI", Area: "SID", Purpose: "Filter cutoff frequency, high byte."},
+		{Start: 0xD417, End: 0xD417, Name: "RESON", Area: "SID", Purpose: "Filter resonance and voice routing.", Bits: []BitField{
+			{7, 4, "filter resonance"},
+			{3, 3, "filter external input"},
+			{2, 2, "filter voice 3"},
+			{1, 1, "filter voice 2"},
+			{0, 0, "filter voice 1"},
+		}},
+		{Start: 0xD418, End: 0xD418, Name: "SIGVOL", Area: "SID", Purpose: "Filter mode and master volume.", Bits: []BitField{
+			{7, 7, "voice 3 off"},
+			{6, 6, "high-pass filter"},
+			{5, 5, "band-pass filter"},
+			{4, 4, "low-pass filter"},
+			{3, 0, "master volume"},
+		}},
+		{Start: 0xD419, End: 0xD419, Name: "POTX", Area: "SID", Purpose: "Paddle X value."},
+		{Start: 0xD41A, End: 0xD41A, Name: "POTY", Area: "SID", Purpose: "Paddle Y value."},
+		{Start: 0xD41B, End: 0xD41B, Name: "RANDOM", Area: "SID", Purpose: "Voice 3 oscillator output. With the noise waveform this is a random number generator."},
+		{Start: 0xD41C, End: 0xD41C, Name: "ENV3", Area: "SID", Purpose: "Voice 3 envelope output."},
+	}...)
+}
+
+var cia1Ports = []MemoryLocation{
+	{Name: "CIAPRA", Purpose: "Port A: keyboard column select (write), joystick 2 (read).", Bits: []BitField{
+		{4, 4, "joystick 2 fire (0 = pressed)"},
+		{3, 3, "joystick 2 right"},
+		{2, 2, "joystick 2 left"},
+		{1, 1, "joystick 2 down"},
+		{0, 0, "joystick 2 up"},
+	}},
+	{Name: "CIAPRB", Purpose: "Port B: keyboard row read, joystick 1.", Bits: []BitField{
+		{4, 4, "joystick 1 fire (0 = pressed)"},
+		{3, 3, "joystick 1 right"},
+		{2, 2, "joystick 1 left"},
+		{1, 1, "joystick 1 down"},
+		{0, 0, "joystick 1 up"},
+	}},
+}
+
+var cia2Ports = []MemoryLocation{
+	{Name: "CI2PRA", Purpose: "Port A: serial bus, RS-232 and VIC-II bank selection.", Bits: []BitField{
+		{7, 7, "serial bus data input"},
+		{6, 6, "serial bus clock input"},
+		{5, 5, "serial bus data output"},
+		{4, 4, "serial bus clock output"},
+		{3, 3, "serial bus ATN output"},
+		{2, 2, "RS-232 data output"},
+		{1, 0, "VIC-II bank: 3 = $0000, 2 = $4000, 1 = $8000, 0 = $C000"},
+	}},
+	{Name: "CI2PRB", Purpose: "Port B: user port and RS-232 lines."},
+}
+
+var ciaNames = map[string][]string{
+	"CIA 1": {"CIDDRA", "CIDDRB", "TIMALO", "TIMAHI", "TIMBLO", "TIMBHI", "TODTEN", "TODSEC", "TODMIN", "TODHRS", "CIASDR", "CIAICR", "CIACRA", "CIACRB"},
+	"CIA 2": {"C2DDRA", "C2DDRB", "TI2ALO", "TI2AHI", "TI2BLO", "TI2BHI", "TO2TEN", "TO2SEC", "TO2MIN", "TO2HRS", "CI2SDR", "CI2ICR", "CI2CRA", "CI2CRB"},
+}
+
+func ciaRegisters(area string, base int, ports []MemoryLocation) []MemoryLocation {
+	regs := []MemoryLocation{}
+	for i, port := range ports {
+		port.Start, port.End, port.Area = base+i, base+i, area
+		regs = append(regs, port)
+	}
+
+	common := []MemoryLocation{
+		{Purpose: "Port A data direction (1 = output)."},
+		{Purpose: "Port B data direction (1 = output)."},
+		{Purpose: "Timer A, low byte."},
+		{Purpose: "Timer A, high byte."},
+		{Purpose: "Timer B, low byte."},
+		{Purpose: "Timer B, high byte."},
+		{Purpose: "Time of day clock tenths of a second (BCD)."},
+		{Purpose: "Time of day clock seconds (BCD)."},
+		{Purpose: "Time of day clock minutes (BCD)."},
+		{Purpose: "Time of day clock hours (BCD), bit 7 = PM."},
+		{Purpose: "Serial data register."},
+		{Purpose: "Interrupt control and status.", Bits: []BitField{
+			{7, 7, "read: interrupt occurred; write: 1 = set, 0 = clear the given bits"},
+			{4, 4, "FLAG line"},
+			{3, 3, "serial port"},
+			{2, 2, "time of day alarm"},
+			{1, 1, "timer B"},
+			{0, 0, "timer A"},
+		}},
+		{Purpose: "Control register A.", Bits: []BitField{
+			{7, 7, "time of day frequency: 1 = 50Hz, 0 = 60Hz"},
+			{6, 6, "serial port direction: 1 = output"},
+			{5, 5, "timer A counts: 1 = CNT pulses, 0 = system clock"},
+			{4, 4, "force load timer A"},
+			{3, 3, "timer A one-shot"},
+			{0, 0, "start timer A"},
+		}},
+		{Purpose: "Control register B.", Bits: []BitField{
+			{7, 7, "write time of day: 1 = alarm, 0 = clock"},
+			{6, 5, "timer B counts: 0 = system clock, 1 = CNT, 2 = timer A, 3 = timer A with CNT"},
+			{4, 4, "force load timer B"},
+			{3, 3, "timer B one-shot"},
+			{0, 0, "start timer B"},
+		}},
+	}
+	for i, reg := range common {
+		reg.Start, reg.End, reg.Area = base+len(ports)+i, base+len(ports)+i, area
+		reg.Name = ciaNames[area][i]
+		regs = append(regs, reg)
+	}
+	return regs
+}
+
+var kernalJumpTable = kernalRoutines([]MemoryLocation{
+	{Start: 0xFF81, Name: "CINT", Purpose: "Initialise the screen editor and VIC-II."},
+	{Start: 0xFF84, Name: "IOINIT", Purpose: "Initialise I/O devices."},
+	{Start: 0xFF87, Name: "RAMTAS", Purpose: "Test RAM and initialise memory pointers."},
+	{Start: 0xFF8A, Name: "RESTOR", Purpose: "Restore the default KERNAL vectors."},
+	{Start: 0xFF8D, Name: "VECTOR", Purpose: "Read or set the KERNAL vectors."},
+	{Start: 0xFF90, Name: "SETMSG", Purpose: "Control KERNAL messages."},
+	{Start: 0xFF93, Name: "SECOND", Purpose: "Send a secondary address after LISTEN."},
+	{Start: 0xFF96, Name: "TKSA", Purpose: "Send a secondary address after TALK."},
+	{Start: 0xFF99, Name: "MEMTOP", Purpose: "Read or set the top of memory."},
+	{Start: 0xFF9C, Name: "MEMBOT", Purpose: "Read or set the bottom of memory."},
+	{Start: 0xFF9F, Name: "SCNKEY", Purpose: "Scan the keyboard."},
+	{Start: 0xFFA2, Name: "SETTMO", Purpose: "Set the IEEE bus timeout flag."},
+	{Start: 0xFFA5, Name: "ACPTR", Purpose: "Read a byte from the serial bus."},
+	{Start: 0xFFA8, Name: "CIOUT", Purpose: "Send a byte to the serial bus."},
+	{Start: 0xFFAB, Name: "UNTLK", Purpose: "Send UNTALK to the serial bus."},
+	{Start: 0xFFAE, Name: "UNLSN", Purpose: "Send UNLISTEN to the serial bus."},
+	{Start: 0xFFB1, Name: "LISTEN", Purpose: "Command a serial bus device to listen."},
+	{Start: 0xFFB4, Name: "TALK", Purpose: "Command a serial bus device to talk."},
+	{Start: 0xFFB7, Name: "READST", Purpose: "Read the I/O status word."},
+	{Start: 0xFFBA, Name: "SETLFS", Purpose: "Set logical file, device and secondary address."},
+	{Start: 0xFFBD, Name: "SETNAM", Purpose: "Set the file name."},
+	{Start: 0xFFC0, Name: "OPEN", Purpose: "Open a logical file."},
+	{Start: 0xFFC3, Name: "CLOSE", Purpose: "Close a logical file."},
+	{Start: 0xFFC6, Name: "CHKIN", Purpose: "Open a channel for input."},
+	{Start: 0xFFC9, Name: "CHKOUT", Purpose: "Open a channel for output."},
+	{Start: 0xFFCC, Name: "CLRCHN", Purpose: "Restore default I/O channels."},
+	{Start: 0xFFCF, Name: "CHRIN", Purpose: "Read a character from the input channel."},
+	{Start: 0xFFD2, Name: "CHROUT", Purpose: "Write the character in the accumulator to the output channel."},
+	{Start: 0xFFD5, Name: "LOAD", Purpose: "Load RAM from a device."},
+	{Start: 0xFFD8, Name: "SAVE", Purpose: "Save RAM to a device."},
+	{Start: 0xFFDB, Name: "SETTIM", Purpose: "Set the jiffy clock."},
+	{Start: 0xFFDE, Name: "RDTIM", Purpose: "Read the jiffy clock."},
+	{Start: 0xFFE1, Name: "STOP", Purpose: "Check the RUN/STOP key."},
+	{Start: 0xFFE4, Name: "GETIN", Purpose: "Get a character from the keyboard buffer."},
+	{Start: 0xFFE7, Name: "CLALL", Purpose: "Close all files."},
+	{Start: 0xFFEA, Name: "UDTIM", Purpose: "Increment the jiffy clock."},
+	{Start: 0xFFED, Name: "SCREEN", Purpose: "Return the screen size in columns and rows."},
+	{Start: 0xFFF0, Name: "PLOT", Purpose: "Read or set the cursor position."},
+	{Start: 0xFFF3, Name: "IOBASE", Purpose: "Return the base address of the I/O devices."},
+})
+
+// kernalRoutines fills in the 3-byte JMP instruction each jump table entry occupies.
+func kernalRoutines(routines []MemoryLocation) []MemoryLocation {
+	for i := range routines {
+		routines[i].End = routines[i].Start + 2
+		routines[i].Area = "KERNAL jump table"
+	}
+	return routines
+}
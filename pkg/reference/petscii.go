@@ -0,0 +1,373 @@
+package reference
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// PetsciiCode describes a PETSCII control code.
+type PetsciiCode struct {
+	Code int
+	// Mnemonic as written between braces in petcat listings, e.g. "clr".
+	Mnemonic string
+	// Mnemonic used by CBM prgStudio, if it differs.
+	PrgStudio string
+	// Other spellings used by CBM prgStudio and similar tools, e.g. "clear".
+	Aliases     []string
+	Description string
+	// Set for codes returned by GET for a key rather than printed.
+	Key bool
+}
+
+var PetsciiNotFound = errors.New("PETSCII code does not exist")
+
+// PetsciiControlCodes are the PETSCII codes that perform an action when printed.
+var PetsciiControlCodes = []PetsciiCode{
+	{Code: 5, Mnemonic: "wht", PrgStudio: "white", Aliases: []string{"white"}, Description: "Change the text colour to white."},
+	{Code: 8, Mnemonic: "dish", Aliases: []string{"disable shift"}, Description: "Disable SHIFT+Commodore character set switching."},
+	{Code: 9, Mnemonic: "ensh", Aliases: []string{"enable shift"}, Description: "Enable SHIFT+Commodore character set switching."},
+	{Code: 13, Mnemonic: "return", PrgStudio: "return", Aliases: []string{"cr", "ret"}, Description: "Carriage return: move to the start of the next line."},
+	{Code: 14, Mnemonic: "swlc", PrgStudio: "lower case", Aliases: []string{"lower case", "lower"}, Description: "Switch to the lowercase/uppercase character set."},
+	{Code: 17, Mnemonic: "down", PrgStudio: "cursor down", Aliases: []string{"cursor down", "crsr down"}, Description: "Move the cursor down one row."},
+	{Code: 18, Mnemonic: "rvon", PrgStudio: "reverse on", Aliases: []string{"rvs on", "reverse on"}, Description: "Reverse video on."},
+	{Code: 19, Mnemonic: "home", Aliases: []string{"cursor home", "crsr home"}, Description: "Move the cursor to the top left of the screen."},
+	{Code: 20, Mnemonic: "del", PrgStudio: "delete", Aliases: []string{"delete"}, Description: "Delete the character to the left of the cursor."},
+	{Code: 28, Mnemonic: "red", PrgStudio: "red", Description: "Change the text colour to red."},
+	{Code: 29, Mnemonic: "rght", PrgStudio: "cursor right", Aliases: []string{"right", "cursor right", "crsr right"}, Description: "Move the cursor right one column."},
+	{Code: 30, Mnemonic: "grn", PrgStudio: "green", Aliases: []string{"green"}, Description: "Change the text colour to green."},
+	{Code: 31, Mnemonic: "blu", PrgStudio: "blue", Aliases: []string{"blue"}, Description: "Change the text colour to blue."},
+	{Code: 129, Mnemonic: "orng", PrgStudio: "orange", Aliases: []string{"orange"}, Description: "Change the text colour to orange."},
+	{Code: 133, Mnemonic: "f1", Description: "F1 key.", Key: true},
+	{Code: 134, Mnemonic: "f3", Description: "F3 key.", Key: true},
+	{Code: 135, Mnemonic: "f5", Description: "F5 key.", Key: true},
+	{Code: 136, Mnemonic: "f7", Description: "F7 key.", Key: true},
+	{Code: 137, Mnemonic: "f2", Description: "F2 key (SHIFT+F1).", Key: true},
+	{Code: 138, Mnemonic: "f4", Description: "F4 key (SHIFT+F3).", Key: true},
+	{Code: 139, Mnemonic: "f6", Description: "F6 key (SHIFT+F5).", Key: true},
+	{Code: 140, Mnemonic: "f8", Description: "F8 key (SHIFT+F7).", Key: true},
+	{Code: 141, Mnemonic: "sret", Aliases: []string{"shift return"}, Description: "SHIFT+RETURN: move to the next line without executing it."},
+	{Code: 142, Mnemonic: "swuc", PrgStudio: "upper case", Aliases: []string{"upper case", "upper"}, Description: "Switch to the uppercase/graphics character set."},
+	{Code: 144, Mnemonic: "blk", PrgStudio: "black", Aliases: []string{"black"}, Description: "Change the text colour to black."},
+	{Code: 145, Mnemonic: "up", PrgStudio: "cursor up", Aliases: []string{"cursor up", "crsr up"}, Description: "Move the cursor up one row."},
+	{Code: 146, Mnemonic: "rvof", PrgStudio: "reverse off", Aliases: []string{"rvs off", "reverse off"}, Description: "Reverse video off."},
+	{Code: 147, Mnemonic: "clr", PrgStudio: "clear", Aliases: []string{"clear", "clear screen"}, Description: "Clear the screen and move the cursor home."},
+	{Code: 148, Mnemonic: "inst", PrgStudio: "insert", Aliases: []string{"insert"}, Description: "Insert a space at the cursor."},
+	{Code: 149, Mnemonic: "brn", PrgStudio: "brown", Aliases: []string{"brown"}, Description: "Change the text colour to brown."},
+	{Code: 150, Mnemonic: "lred", PrgStudio: "light red", Aliases: []string{"light red", "pink"}, Description: "Change the text colour to light red."},
+	{Code: 151, Mnemonic: "gry1", PrgStudio: "dark gray", Aliases: []string{"dark grey", "dark gray", "grey 1", "gray 1"}, Description: "Change the text colour to dark grey."},
+	{Code: 152, Mnemonic: "gry2", PrgStudio: "gray", Aliases: []string{"grey", "gray", "grey 2", "gray 2", "medium grey"}, Description: "Change the text colour to grey."},
+	{Code: 153, Mnemonic: "lgrn", PrgStudio: "light green", Aliases: []string{"light green"}, Description: "Change the text colour to light green."},
+	{Code: 154, Mnemonic: "lblu", PrgStudio: "light blue", Aliases: []string{"light blue"}, Description: "Change the text colour to light blue."},
+	{Code: 155, Mnemonic: "gry3", PrgStudio: "light gray", Aliases: []string{"light grey", "light gray", "grey 3", "gray 3"}, Description: "Change the text colour to light grey."},
+	{Code: 156, Mnemonic: "pur", PrgStudio: "purple", Aliases: []string{"purple"}, Description: "Change the text colour to purple."},
+	{Code: 157, Mnemonic: "left", PrgStudio: "cursor left", Aliases: []string{"cursor left", "crsr left"}, Description: "Move the cursor left one column."},
+	{Code: 158, Mnemonic: "yel", PrgStudio: "yellow", Aliases: []string{"yellow"}, Description: "Change the text colour to yellow."},
+	{Code: 159, Mnemonic: "cyn", PrgStudio: "cyan", Aliases: []string{"cyan"}, Description: "Change the text colour to cyan."},
+}
+
+// LookupPetscii returns the control code for a PETSCII value.
+func LookupPetscii(code int) (*PetsciiCode, error) {
+	for i := range PetsciiControlCodes {
+		if PetsciiControlCodes[i].Code == code {
+			return &PetsciiControlCodes[i], nil
+		}
+	}
+	return nil, PetsciiNotFound
+}
+
+// PetsciiToScreenCode converts a printable PETSCII character to the screen
+// code that displays it.
+func PetsciiToScreenCode(code int) (int, bool) {
+	switch {
+	case code >= 32 && code <= 63:
+		return code, true
+	case code >= 64 && code <= 95:
+		return code - 64, true
+	case code >= 96 && code <= 127:
+		return code - 32, true
+	case code >= 160 && code <= 191:
+		return code - 64, true
+	case code >= 192 && code <= 254:
+		return code - 128, true
+	case code == 255:
+		return 94, true
+	}
+	return 0, false
+}
+
+// ChrLabel returns a short label for the character produced by CHR$(code):
+// "{clr}" for control codes, "F1" for function keys, or the quoted glyph.
+func ChrLabel(code int) string {
+	if pc, err := LookupPetscii(code); err == nil {
+		if pc.Key {
+			return fmt.Sprintf("F%s", pc.Mnemonic[1:])
+		}
+		return fmt.Sprintf("{%s}", pc.Mnemonic)
+	}
+	if sc, ok := PetsciiToScreenCode(code); ok {
+		return fmt.Sprintf("\"%s\"", ScreenCodes[sc].Glyph)
+	}
+	return ""
+}
+
+var MnemonicNotFound = errors.New("unknown PETSCII mnemonic")
+
+// PetsciiGraphics are the printable characters typed with SHIFT or the
+// Commodore key, or that have no ASCII equivalent.
+var PetsciiGraphics = buildGraphics()
+
+func buildGraphics() []PetsciiCode {
+	graphics := []PetsciiCode{
+		{Code: 32, Mnemonic: "space"},
+		{Code: 92, Mnemonic: "pound", Aliases: []string{"£"}},
+		{Code: 94, Mnemonic: "arrow up", Aliases: []string{"up arrow", "↑"}},
+		{Code: 95, Mnemonic: "arrow left", Aliases: []string{"left arrow", "←"}},
+		{Code: 160, Mnemonic: "shift-space"},
+		{Code: 192, Mnemonic: "shift-*"},
+		{Code: 219, Mnemonic: "shift-+"},
+		{Code: 220, Mnemonic: "cbm--"},
+		{Code: 221, Mnemonic: "shift--"},
+		{Code: 222, Mnemonic: "pi", Aliases: []string{"shift-↑", "π"}},
+		{Code: 223, Mnemonic: "cbm-*"},
+		{Code: 164, Mnemonic: "cbm-@"},
+		{Code: 166, Mnemonic: "cbm-+"},
+		{Code: 168, Mnemonic: "cbm-£", Aliases: []string{"cbm-pound"}},
+		{Code: 169, Mnemonic: "shift-£", Aliases: []string{"shift-pound"}},
+	}
+
+	for c := 'a'; c <= 'z'; c++ {
+		graphics = append(graphics, PetsciiCode{Code: 0xC1 + int(c-'a'), Mnemonic: "shift-" + string(c)})
+	}
+
+	// the Commodore key graphics follow the keyboard legends, not the alphabet
+	for i, code := range []int{176, 191, 188, 172, 177, 187, 165, 180, 162, 181, 161, 182, 167, 170, 185, 175, 171, 178, 174, 163, 184, 190, 179, 189, 183, 173} {
+		graphics = append(graphics, PetsciiCode{Code: code, Mnemonic: "cbm-" + string(rune('a'+i))})
+	}
+
+	for i := range graphics {
+		if sc, ok := PetsciiToScreenCode(graphics[i].Code); ok {
+			graphics[i].Description = fmt.Sprintf("Prints '%s' (%s).", ScreenCodes[sc].Glyph, ScreenCodes[sc].Name)
+		}
+	}
+	return graphics
+}
+
+var mnemonicIndex = buildMnemonicIndex()
+
+// normaliseMnemonic folds case and drops separators, so that "RVS ON",
+// "rvs-on" and "rvson" are the same mnemonic. Graphics keys keep their key,
+// so "shift--" and "SHIFT -" are both SHIFT with the minus key.
+func normaliseMnemonic(name string) string {
+	name = strings.ToLower(strings.TrimSpace(name))
+
+	for _, prefix := range []string{"shift", "cbm", "c="} {
+		if !strings.HasPrefix(name, prefix) {
+			continue
+		}
+		key := name[len(prefix):]
+		if len(key) > 1 && strings.ContainsAny(key[:1], " -_") {
+			key = key[1:]
+		}
+		if utf8.RuneCountInString(key) == 1 {
+			if prefix == "c=" {
+				prefix = "cbm"
+			}
+			return prefix + ":" + key
+		}
+	}
+
+	return strings.NewReplacer(" ", "", "_", "", "-", "").Replace(name)
+}
+
+func buildMnemonicIndex() map[string]*PetsciiCode {
+	index := map[string]*PetsciiCode{}
+	for _, table := range [][]PetsciiCode{PetsciiControlCodes, PetsciiGraphics} {
+		for i := range table {
+			pc := &table[i]
+			index[normaliseMnemonic(pc.Mnemonic)] = pc
+			for _, alias := range pc.Aliases {
+				index[normaliseMnemonic(alias)] = pc
+			}
+		}
+	}
+	return index
+}
+
+// LookupMnemonic returns the PETSCII code for a mnemonic written between
+// braces. Besides named codes, "$93" and "147" give a code directly.
+func LookupMnemonic(name string) (int, error) {
+	name = strings.TrimSpace(name)
+	if strings.HasPrefix(name, "$") {
+		if code, err := strconv.ParseUint(name[1:], 16, 8); err == nil {
+			return int(code), nil
+		}
+		return 0, MnemonicNotFound
+	}
+	if code, err := strconv.ParseUint(name, 10, 8); err == nil {
+		return int(code), nil
+	}
+
+	pc, ok := mnemonicIndex[normaliseMnemonic(name)]
+	if !ok {
+		return 0, MnemonicNotFound
+	}
+	return pc.Code, nil
+}
+
+// MnemonicFor returns the canonical mnemonic for a PETSCII code that has no
+// plain text equivalent, or "$xx" if it has no name.
+func MnemonicFor(code int) string {
+	for _, table := range [][]PetsciiCode{PetsciiControlCodes, PetsciiGraphics} {
+		for _, pc := range table {
+			if pc.Code == code {
+				return pc.Mnemonic
+			}
+		}
+	}
+	return fmt.Sprintf("$%02x", code)
+}
+
+// AsciiToPetscii maps a character typed in a source listing to PETSCII.
+// Lowercase letters are the unshifted keys, and so are PETSCII 65-90, while
+// uppercase letters are the shifted keys. Unicode graphics are mapped with
+// UnicodeToPetscii.
+func AsciiToPetscii(r rune) byte {
+	switch {
+	case r >= 'a' && r <= 'z':
+		return byte(r - 'a' + 65)
+	case r >= 'A' && r <= 'Z':
+		return byte(r - 'A' + 193)
+	case r == '£' || r == '\\':
+		return 92
+	case r == '↑' || r == '^':
+		return 94
+	case r == '←' || r == '_':
+		return 95
+	case r == 'π' || r == '~':
+		return 255
+	case r < 128:
+		return byte(r)
+	}
+	if code, ok := UnicodeToPetscii(r); ok {
+		return byte(code)
+	}
+	return '?'
+}
+
+// LookupCharacter returns the named control code or graphics character for a
+// PETSCII value.
+func LookupCharacter(code int) (*PetsciiCode, error) {
+	for _, table := range [][]PetsciiCode{PetsciiControlCodes, PetsciiGraphics} {
+		for i := range table {
+			if table[i].Code == code {
+				return &table[i], nil
+			}
+		}
+	}
+	return nil, PetsciiNotFound
+}
+
+// QuoteModeGlyph returns the glyph a C64 shows for a PETSCII code typed inside
+// quotes. Control codes appear as reversed characters rather than acting.
+func QuoteModeGlyph(code int) (glyph string, reversed bool) {
+	switch {
+	case code < 32:
+		return ScreenCodes[code].Glyph, true
+	case code >= 128 && code < 160:
+		return ScreenCodes[code-64].Glyph, true
+	}
+	if sc, ok := PetsciiToScreenCode(code); ok {
+		return ScreenCodes[sc].Glyph, false
+	}
+	return "", false
+}
+
+// Markdown describes a PETSCII code.
+func (pc *PetsciiCode) Markdown() string {
+	var sb strings.Builder
+
+	sb.WriteString(fmt.Sprintf("## {%s}\n\n", pc.Mnemonic))
+	sb.WriteString(fmt.Sprintf("### CODE: $%02X (%d)\n", pc.Code, pc.Code))
+	sb.WriteString(fmt.Sprintf("### EQUIVALENT: `CHR$(%d)`\n", pc.Code))
+	if glyph, reversed := QuoteModeGlyph(pc.Code); glyph != "" {
+		if reversed {
+			sb.WriteString(fmt.Sprintf("### SHOWN IN QUOTES AS: reversed `%s`\n", glyph))
+		} else {
+			sb.WriteString(fmt.Sprintf("### GLYPH: `%s`\n", glyph))
+		}
+	}
+	sb.WriteString("\n")
+	if pc.Description != "" {
+		sb.WriteString(fmt.Sprintf("**Action:** %s\n\n", pc.Description))
+	}
+	if len(pc.Aliases) > 0 {
+		sb.WriteString(fmt.Sprintf("Also written as `{%s}`\n", strings.Join(pc.Aliases, "}`, `{")))
+	}
+
+	return sb.String()
+}
+
+// MnemonicStyle selects the spelling used for mnemonics in generated strings.
+type MnemonicStyle int
+
+const (
+	// PetcatStyle uses the short petcat mnemonics, e.g. {clr} and {rvon}.
+	PetcatStyle MnemonicStyle = iota
+	// PrgStudioStyle uses the CBM prgStudio mnemonics, e.g. {clear} and {reverse on}.
+	PrgStudioStyle
+)
+
+func (s MnemonicStyle) String() string {
+	if s == PrgStudioStyle {
+		return "CBM prgStudio"
+	}
+	return "petcat"
+}
+
+// Name returns the mnemonic for the code in the given style.
+func (pc *PetsciiCode) Name(style MnemonicStyle) string {
+	if style == PrgStudioStyle && pc.PrgStudio != "" {
+		return pc.PrgStudio
+	}
+	return pc.Mnemonic
+}
+
+// PetsciiToAscii maps a PETSCII code back to the character typed for it in a
+// source listing, the inverse of AsciiToPetscii. Codes that must be written
+// as mnemonics, including the quote itself, are not mapped.
+func PetsciiToAscii(code int) (rune, bool) {
+	switch {
+	case code >= 65 && code <= 90:
+		return rune(code - 65 + 'a'), true
+	case code >= 193 && code <= 218:
+		return rune(code - 193 + 'A'), true
+	case code == '"' || code == '{' || code == '}':
+		return 0, false
+	case (code > 32 && code <= 64) || code == 91 || code == 93:
+		return rune(code), true
+	case code == 32:
+		return ' ', true
+	}
+	return 0, false
+}
+
+// EncodeString writes PETSCII bytes as the contents of a string literal,
+// using mnemonics for anything that cannot be typed directly.
+func EncodeString(b []byte, style MnemonicStyle) string {
+	var sb strings.Builder
+	for _, c := range b {
+		if r, ok := PetsciiToAscii(int(c)); ok {
+			sb.WriteRune(r)
+			continue
+		}
+		name := fmt.Sprintf("$%02x", c)
+		if pc, err := LookupCharacter(int(c)); err == nil {
+			name = pc.Name(style)
+		}
+		sb.WriteString("{" + name + "}")
+	}
+	return sb.String()
+}
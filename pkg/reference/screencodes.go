@@ -0,0 +1,46 @@
+package reference
+
+import "fmt"
+
+// ScreenCode is a character as stored in screen RAM, in the uppercase/graphics
+// character set.
+type ScreenCode struct {
+	// Closest Unicode rendering of the glyph.
+	Glyph string
+	Name  string
+}
+
+// ScreenCodes are the 128 unreversed screen codes. Codes 128-255 show the same
+// glyphs in reverse video.
+var ScreenCodes = [128]ScreenCode{
+	{"@", "at sign"}, {"A", "A"}, {"B", "B"}, {"C", "C"}, {"D", "D"}, {"E", "E"}, {"F", "F"}, {"G", "G"},
+	{"H", "H"}, {"I", "I"}, {"J", "J"}, {"K", "K"}, {"L", "L"}, {"M", "M"}, {"N", "N"}, {"O", "O"},
+	{"P", "P"}, {"Q", "Q"}, {"R", "R"}, {"S", "S"}, {"T", "T"}, {"U", "U"}, {"V", "V"}, {"W", "W"},
+	{"X", "X"}, {"Y", "Y"}, {"Z", "Z"}, {"[", "left bracket"}, {"£", "pound sign"}, {"]", "right bracket"}, {"↑", "up arrow"}, {"←", "left arrow"},
+	{" ", "space"}, {"!", "exclamation mark"}, {"\"", "quote"}, {"#", "hash"}, {"$", "dollar"}, {"%", "percent"}, {"&", "ampersand"}, {"'", "apostrophe"},
+	{"(", "left parenthesis"}, {")", "right parenthesis"}, {"*", "asterisk"}, {"+", "plus"}, {",", "comma"}, {"-", "minus"}, {".", "full stop"}, {"/", "slash"},
+	{"0", "0"}, {"1", "1"}, {"2", "2"}, {"3", "3"}, {"4", "4"}, {"5", "5"}, {"6", "6"}, {"7", "7"},
+	{"8", "8"}, {"9", "9"}, {":", "colon"}, {";", "semicolon"}, {"<", "less than"}, {"=", "equals"}, {">", "greater than"}, {"?", "question mark"},
+	{"─", "horizontal line"}, {"♠", "spade"}, {"│", "vertical line"}, {"─", "horizontal line, raised"}, {"─", "horizontal line, high"}, {"▔", "horizontal line, top"}, {"─", "horizontal line, lowered"}, {"▏", "vertical line, left of centre"},
+	{"▕", "vertical line, right of centre"}, {"╮", "rounded corner, top right"}, {"╰", "rounded corner, bottom left"}, {"╯", "rounded corner, bottom right"}, {"⌞", "corner, bottom left"}, {"╲", "diagonal, top left to bottom right"}, {"╱", "diagonal, bottom left to top right"}, {"⌜", "corner, top left"},
+	{"⌝", "corner, top right"}, {"●", "ball"}, {"▁", "horizontal line, bottom"}, {"♥", "heart"}, {"▎", "vertical line, left"}, {"╭", "rounded corner, top left"}, {"╳", "diagonal cross"}, {"○", "circle"},
+	{"♣", "club"}, {"▊", "vertical line, right"}, {"♦", "diamond"}, {"┼", "cross"}, {"▒", "left half checkerboard"}, {"│", "vertical line, centre"}, {"π", "pi"}, {"◥", "triangle, top right"},
+	{" ", "shifted space"}, {"▌", "left half block"}, {"▄", "bottom half block"}, {"▔", "top line"}, {"▁", "bottom line"}, {"▏", "left line"}, {"▒", "checkerboard"}, {"▕", "right line"},
+	{"▄", "bottom half checkerboard"}, {"◤", "triangle, top left"}, {"▕", "right quarter"}, {"├", "tee, pointing right"}, {"▗", "bottom right quarter block"}, {"└", "corner, bottom left"}, {"┐", "corner, top right"}, {"▂", "bottom quarter block"},
+	{"┌", "corner, top left"}, {"┴", "tee, pointing up"}, {"┬", "tee, pointing down"}, {"┤", "tee, pointing left"}, {"▎", "left quarter block"}, {"▍", "left three-eighths block"}, {"▊", "right three-eighths block"}, {"▀", "top quarter block"},
+	{"▀", "top three-eighths block"}, {"▃", "bottom three-eighths block"}, {"▟", "bottom right corner block"}, {"▖", "bottom left quarter block"}, {"▝", "top right quarter block"}, {"┘", "corner, bottom right"}, {"▘", "top left quarter block"}, {"▚", "diagonal quarter blocks"},
+}
+
+// DescribeScreenCode describes the character shown for a screen code.
+func DescribeScreenCode(code int) string {
+	code &= 0xFF
+	sc := ScreenCodes[code&0x7F]
+	desc := fmt.Sprintf("'%s'", sc.Glyph)
+	if sc.Name != sc.Glyph {
+		desc += " " + sc.Name
+	}
+	if code >= 0x80 {
+		desc += " (reversed)"
+	}
+	return desc
+}
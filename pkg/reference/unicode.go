@@ -0,0 +1,104 @@
+package reference
+
+// GlyphSet selects how PETSCII graphics are written as Unicode characters.
+type GlyphSet int
+
+const (
+	// UnicodeBlocks approximates graphics with standard block, box-drawing
+	// and symbol characters. Not every graphic has an approximation.
+	UnicodeBlocks GlyphSet = iota
+	// C64ProMono uses the private use area of the C64 Pro Mono font, which
+	// covers every character.
+	C64ProMono
+)
+
+// C64 Pro Mono places the uppercase/graphics character set at U+E000 and the
+// lowercase/uppercase set at U+E100, in PETSCII order.
+const (
+	c64ProUpper = 0xE000
+	c64ProLower = 0xE100
+)
+
+// unicodeGraphics approximates PETSCII graphics (as shown in the
+// uppercase/graphics character set) with standard Unicode characters.
+var unicodeGraphics = map[int]rune{
+	160: ' ', // shifted space
+	161: '▌',
+	162: '▄',
+	163: '▔',
+	164: '▁',
+	165: '▏',
+	166: '▒',
+	167: '▕',
+	169: '◤',
+	171: '├',
+	172: '▗',
+	173: '└',
+	174: '┐',
+	175: '▂',
+	176: '┌',
+	177: '┴',
+	178: '┬',
+	179: '┤',
+	180: '▎',
+	181: '▍',
+	184: '▃',
+	187: '▖',
+	188: '▝',
+	189: '┘',
+	190: '▘',
+	191: '▚',
+	192: '─',
+	193: '♠',
+	201: '╮',
+	202: '╰',
+	203: '╯',
+	205: '╲',
+	206: '╱',
+	209: '●',
+	211: '♥',
+	213: '╭',
+	214: '╳',
+	215: '○',
+	216: '♣',
+	218: '♦',
+	219: '┼',
+	221: '│',
+	223: '◥',
+}
+
+var unicodeIndex = buildUnicodeIndex()
+
+func buildUnicodeIndex() map[rune]int {
+	index := map[rune]int{}
+	for code, r := range unicodeGraphics {
+		index[r] = code
+	}
+	return index
+}
+
+// UnicodeToPetscii maps a C64 Pro Mono private use character or a Unicode
+// approximation of a graphic to PETSCII.
+func UnicodeToPetscii(r rune) (int, bool) {
+	switch {
+	case r >= c64ProUpper && r < c64ProUpper+256:
+		return int(r - c64ProUpper), true
+	case r >= c64ProLower && r < c64ProLower+256:
+		return int(r - c64ProLower), true
+	}
+	code, ok := unicodeIndex[r]
+	return code, ok
+}
+
+// PetsciiToUnicode returns the Unicode character for a PETSCII graphic in the
+// given glyph set. Control codes have no glyph.
+func PetsciiToUnicode(code int, set GlyphSet) (rune, bool) {
+	if _, err := LookupPetscii(code); err == nil || code < 0 || code > 255 {
+		return 0, false
+	}
+	if set == C64ProMono {
+		return rune(c64ProUpper + code), true
+	}
+	r, ok := unicodeGraphics[code]
+	return r, ok
+}